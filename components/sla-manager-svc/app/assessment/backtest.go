@@ -0,0 +1,135 @@
+/*
+Copyright © 2024 EVIDEN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+This work has been implemented within the context of COLMENA project.
+*/
+package assessment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"colmena/sla-management-svc/app/assessment/monitor"
+	"colmena/sla-management-svc/app/common/logs"
+	"colmena/sla-management-svc/app/model"
+)
+
+// MaxBacktestSteps is the maximum number of evaluations of a backtest (steps × KPIs)
+const MaxBacktestSteps = 1000
+
+// ErrInvalidBacktest is returned by Backtest if the time range or the step are not valid
+var ErrInvalidBacktest = errors.New("invalid backtest")
+
+/*
+Backtest replays the assessment of the SLAs from "from" to "to", every step, and returns the levels and the violations
+that would have been raised in each guarantee.
+
+The assessment is the same as in the live assessment (AssessQoS and the level policies), but the clock is injected:
+in each step, cfg.Now is set to the time of the step. If the monitoring adapter (cfg.Adapter) supports range retrievals
+(see monitor.RangeAdapter), the values of each metric are retrieved once for the whole time range (e.g. a Prometheus
+range query); if not, the adapter is queried in each step. Nothing is persisted or notified: cfg.Repo, cfg.Notifier and
+cfg.History are not used. The labels of the scopes are not bound (the context is not checked).
+*/
+func Backtest(ctx context.Context, cfg Config, slas []model.SLA, from, to time.Time, step time.Duration) ([]model.OutputBacktestKpi, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("%w: step must be a positive duration", ErrInvalidBacktest)
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("%w: 'to' must be after 'from'", ErrInvalidBacktest)
+	}
+	steps := int(to.Sub(from)/step) + 1
+	gts := 0
+	for _, sla := range slas {
+		gts += len(sla.Details.Guarantees)
+	}
+	if steps*gts > MaxBacktestSteps {
+		return nil, fmt.Errorf("%w: too many evaluations (%d steps × %d KPIs); the maximum is %d", ErrInvalidBacktest,
+			steps, gts, MaxBacktestSteps)
+	}
+
+	if ra, ok := cfg.Adapter.(monitor.RangeAdapter); ok {
+		// the time of the last step
+		cfg.Adapter = ra.WithRange(from.Add(time.Duration(steps-1)*step), step)
+	}
+
+	res := make([]model.OutputBacktestKpi, 0)
+	for _, sla := range slas {
+		kpis, err := backtestSLA(ctx, cfg, sla, from, to, step)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, kpis...)
+	}
+	return res, nil
+}
+
+// backtestSLA replays the assessment of a copy of the SLA (see Backtest)
+func backtestSLA(ctx context.Context, cfg Config, sla model.SLA, from, to time.Time, step time.Duration) ([]model.OutputBacktestKpi, error) {
+	bt := sla.Clone()
+	bt.State = model.STARTED
+	bt.Creation = from
+	bt.Expiration = nil
+	bt.Assessment = model.Assessment{}
+
+	kpis := make([]model.OutputBacktestKpi, len(bt.Details.Guarantees))
+	for i, gt := range bt.Details.Guarantees {
+		kpis[i] = model.OutputBacktestKpi{
+			RoleId:     gt.Role,
+			Query:      gt.OQuery,
+			Constraint: gt.Constraint,
+			Threshold:  gt.Threshold,
+			Points:     []model.KPIHistoryPoint{},
+			Violations: []model.Violation{},
+		}
+	}
+
+	for now := from; !now.After(to); now = now.Add(step) {
+		stepCfg := Config{
+			Now:       now,
+			Adapter:   cfg.Adapter,
+			Transient: cfg.Transient,
+		}
+
+		result, _ := AssessQoS(ctx, &bt, stepCfg)
+		if ctx.Err() != nil {
+			logs.GetLogger().Warn(pathLOG+"[backtestSLA] Backtest of SLA "+bt.Id+" cancelled: ", ctx.Err())
+			return nil, ctx.Err()
+		}
+		checkViolationLevel(&bt, result)
+
+		for i, gt := range bt.Details.Guarantees {
+			var value interface{}
+			for _, v := range result.LastValues[gt.Name] {
+				value = v.Value
+				break
+			}
+
+			ag := bt.Assessment.GetGuarantee(gt.Name)
+			kpis[i].Points = append(kpis[i].Points, model.KPIHistoryPoint{
+				Timestamp:       now,
+				Guarantee:       gt.Name,
+				Value:           value,
+				Threshold:       gt.Threshold,
+				Level:           ag.Level,
+				Violated:        ag.Violated,
+				BudgetRemaining: budgetRemaining(ag),
+			})
+			kpis[i].Violations = append(kpis[i].Violations, result.Violated[gt.Name].Violations...)
+		}
+	}
+	return kpis, nil
+}
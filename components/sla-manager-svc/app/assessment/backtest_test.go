@@ -0,0 +1,26 @@
+package assessment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"colmena/sla-management-svc/app/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBacktestMaxEvaluations(t *testing.T) {
+	from := time.Date(2025, 5, 21, 17, 0, 0, 0, time.UTC)
+	sla := *newScheduledSLA()
+	cfg := Config{Adapter: fixedAdapter{value: 1, now: from}}
+
+	// 500 steps × 2 KPIs
+	_, err := Backtest(context.Background(), cfg, []model.SLA{sla}, from, from.Add(499*time.Second), time.Second)
+	assert.NoError(t, err)
+
+	// 501 steps × 2 KPIs
+	_, err = Backtest(context.Background(), cfg, []model.SLA{sla}, from, from.Add(500*time.Second), time.Second)
+	assert.ErrorIs(t, err, ErrInvalidBacktest)
+	assert.EqualError(t, err, "invalid backtest: too many evaluations (501 steps × 2 KPIs); the maximum is 1000")
+}
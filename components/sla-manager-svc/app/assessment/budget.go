@@ -0,0 +1,189 @@
+/*
+Copyright © 2024 EVIDEN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+This work has been implemented within the context of COLMENA project.
+*/
+package assessment
+
+import (
+	"time"
+
+	"colmena/sla-management-svc/app/common"
+	"colmena/sla-management-svc/app/common/logs"
+	"colmena/sla-management-svc/app/model"
+)
+
+/*
+The error budget is slice-based: each assessment of a guarantee is a time slice, good if the constraint is met and bad
+otherwise, whatever the number of values evaluated in the assessment. The ratio of bad slices in a window is compared
+with the ratio allowed by the objective (1 - target); it is not a ratio of good and total events (e.g. requests). An
+event-based objective can be set with a KPI whose query is the ratio of events (e.g. "[sum(rate(errors_total[5m])) /
+sum(rate(requests_total[5m]))] < 0.01").
+
+The slices are counted in buckets (good and bad slices of each bucket), so that the size of the assessment of a guarantee
+is bounded whatever the period of the guarantee.
+*/
+const (
+	// budgetSlices is the number of buckets of the compliance window of an objective (e.g. 1 hour for 30 days)
+	budgetSlices = 720
+	// burnRateSlices is the number of buckets of the shortest window of the burn rate rules (e.g. 1 minute for 5 minutes)
+	burnRateSlices = 5
+)
+
+// burnRateRule is a model.BurnRateRule with parsed windows
+type burnRateRule struct {
+	name   string
+	long   time.Duration
+	short  time.Duration
+	factor float64
+}
+
+/*
+budgetLevel updates the error budget of a guarantee with an objective with the result of an assessment (violated) at
+time now, counted as a good or bad time slice, and returns the level of the guarantee:
+
+  - Burning: a burn rate rule fired (the budget is burning too fast)
+  - Broken: the budget of the compliance window is exhausted
+  - Desired: no bad assessments in the longest window of the burn rate rules
+  - Met: otherwise
+*/
+func budgetLevel(ag *model.AssessmentGuarantee, o model.Objective, violated bool, now time.Time) string {
+	window := parseWindow(o.Window, model.DEFAULT_OBJECTIVE_WINDOW)
+	rules := parseBurnRateRules(o.BurnRates)
+
+	// resolution of the buckets: a fraction of the compliance window and of the shortest window of the rules
+	var shortest, longest time.Duration
+	for _, r := range rules {
+		if shortest == 0 || r.short < shortest {
+			shortest = r.short
+		}
+		if r.long > longest {
+			longest = r.long
+		}
+	}
+	sliceSize := bucketSize(window / budgetSlices)
+	recentSize := bucketSize(shortest / burnRateSlices)
+
+	b := ag.Budget
+	if b == nil {
+		b = &model.ErrorBudget{}
+		ag.Budget = b
+	}
+	b.Slices = addToBuckets(b.Slices, now, sliceSize, window, violated)
+	b.Recent = addToBuckets(b.Recent, now, recentSize, longest, violated)
+
+	// remaining budget and burn rates
+	allowed := 1 - o.Target
+	b.Remaining = 1 - badRatio(b.Slices, now, sliceSize, window)/allowed
+	b.BurnRates = make(map[string]float64, 2*len(rules))
+	b.Burning = ""
+	for _, r := range rules {
+		long := badRatio(b.Recent, now, recentSize, r.long) / allowed
+		short := badRatio(b.Recent, now, recentSize, r.short) / allowed
+		b.BurnRates[r.long.String()] = long
+		b.BurnRates[r.short.String()] = short
+		if b.Burning == "" && long >= r.factor && short >= r.factor {
+			b.Burning = r.name
+		}
+	}
+
+	switch {
+	case b.Burning != "":
+		return model.ASSESSMENT_LEVEL_BURNING
+	case b.Remaining <= 0:
+		return model.ASSESSMENT_LEVEL_BROKEN
+	case badRatio(b.Recent, now, recentSize, longest) == 0:
+		return model.ASSESSMENT_LEVEL_DESIRED
+	}
+	return model.ASSESSMENT_LEVEL_MET
+}
+
+// addToBuckets counts a time slice in the bucket of time now, and removes the buckets older than the retention time
+func addToBuckets(buckets []model.BudgetBucket, now time.Time, size, retention time.Duration, violated bool) []model.BudgetBucket {
+	start := now.Truncate(size)
+	if n := len(buckets); n == 0 || buckets[n-1].Start.Before(start) {
+		buckets = append(buckets, model.BudgetBucket{Start: start})
+	}
+	last := &buckets[len(buckets)-1]
+	if violated {
+		last.Bad++
+	} else {
+		last.Good++
+	}
+
+	limit := now.Add(-retention)
+	i := 0
+	for i < len(buckets)-1 && !buckets[i].Start.Add(size).After(limit) {
+		i++
+	}
+	return buckets[i:]
+}
+
+// badRatio returns the ratio of bad time slices in the buckets of the window that ends at time now
+func badRatio(buckets []model.BudgetBucket, now time.Time, size, window time.Duration) float64 {
+	limit := now.Add(-window)
+	bad, total := 0, 0
+	for _, bucket := range buckets {
+		if bucket.Start.Add(size).After(limit) {
+			bad += bucket.Bad
+			total += bucket.Good + bucket.Bad
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(bad) / float64(total)
+}
+
+// bucketSize returns the size of the buckets, rounded to seconds (1 second at least)
+func bucketSize(d time.Duration) time.Duration {
+	if d < time.Second {
+		return time.Second
+	}
+	return d.Round(time.Second)
+}
+
+// parseWindow parses the window of an objective or of a burn rate rule; the default value is used if not valid
+func parseWindow(value string, defaultValue string) time.Duration {
+	d, err := common.ParseDuration(value)
+	if err != nil || d <= 0 {
+		d, _ = common.ParseDuration(defaultValue)
+	}
+	return d
+}
+
+// parseBurnRateRules parses the burn rate rules of an objective; invalid rules are discarded
+func parseBurnRateRules(rules []model.BurnRateRule) []burnRateRule {
+	res := make([]burnRateRule, 0, len(rules))
+	for _, r := range rules {
+		long, err1 := common.ParseDuration(r.LongWindow)
+		short, err2 := common.ParseDuration(r.ShortWindow)
+		if err1 != nil || err2 != nil || short <= 0 || long < short || r.Factor <= 0 {
+			logs.GetLogger().Warn(pathLOG + "[parseBurnRateRules] Invalid burn rate rule '" + r.Name + "'. Discarding ...")
+			continue
+		}
+		res = append(res, burnRateRule{name: r.Name, long: long, short: short, factor: r.Factor})
+	}
+	return res
+}
+
+// budgetRemaining returns the remaining error budget of a guarantee, or nil if the guarantee has no objective
+func budgetRemaining(ag model.AssessmentGuarantee) *float64 {
+	if ag.Budget == nil {
+		return nil
+	}
+	remaining := ag.Budget.Remaining
+	return &remaining
+}
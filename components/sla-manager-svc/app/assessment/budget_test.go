@@ -0,0 +1,132 @@
+package assessment
+
+import (
+	"testing"
+	"time"
+
+	"colmena/sla-management-svc/app/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// budgetObjective is an objective of 90% over 1 hour with a burn rate rule (5m/1m, factor 5: 50% of bad slices)
+var budgetObjective = model.Objective{
+	Target:    0.9,
+	Window:    "1h",
+	BurnRates: []model.BurnRateRule{{Name: "fast", LongWindow: "5m", ShortWindow: "1m", Factor: 5}},
+}
+
+// assessBudget assesses the error budget every 10 seconds during d from *now (violated if bad), advancing *now, and
+// returns the last level
+func assessBudget(ag *model.AssessmentGuarantee, o model.Objective, now *time.Time, d time.Duration, bad func(i int) bool) string {
+	level := ""
+	for i := 0; i < int(d/(10*time.Second)); i++ {
+		*now = now.Add(10 * time.Second)
+		level = budgetLevel(ag, o, bad(i), *now)
+	}
+	return level
+}
+
+func always(v bool) func(int) bool { return func(int) bool { return v } }
+
+func TestBudgetLevelBurning(t *testing.T) {
+	now := time.Date(2025, 5, 21, 17, 0, 0, 0, time.UTC)
+	ag := &model.AssessmentGuarantee{}
+
+	assert.Equal(t, model.ASSESSMENT_LEVEL_DESIRED, assessBudget(ag, budgetObjective, &now, 40*time.Minute, always(false)))
+	assert.Equal(t, 1.0, ag.Budget.Remaining)
+
+	// fast burn
+	assert.Equal(t, model.ASSESSMENT_LEVEL_BURNING, assessBudget(ag, budgetObjective, &now, 3*time.Minute, always(true)))
+	assert.Equal(t, "fast", ag.Budget.Burning)
+	assert.GreaterOrEqual(t, ag.Budget.BurnRates["5m0s"], 5.0)
+	assert.GreaterOrEqual(t, ag.Budget.BurnRates["1m0s"], 5.0)
+	assert.Greater(t, ag.Budget.Remaining, 0.0)
+	assert.Less(t, ag.Budget.Remaining, 1.0)
+
+	// recovery: bad slices in the long window of the rule, but not burning
+	assert.Equal(t, model.ASSESSMENT_LEVEL_MET, assessBudget(ag, budgetObjective, &now, 4*time.Minute, always(false)))
+	assert.Empty(t, ag.Budget.Burning)
+	assert.Zero(t, ag.Budget.BurnRates["1m0s"])
+
+	// no bad slices in the long window
+	assert.Equal(t, model.ASSESSMENT_LEVEL_DESIRED, assessBudget(ag, budgetObjective, &now, 2*time.Minute, always(false)))
+	assert.Zero(t, ag.Budget.BurnRates["5m0s"])
+	assert.Less(t, ag.Budget.Remaining, 1.0)
+}
+
+func TestBudgetLevelExhausted(t *testing.T) {
+	now := time.Date(2025, 5, 21, 17, 0, 0, 0, time.UTC)
+	ag := &model.AssessmentGuarantee{}
+
+	// 1 of 6 slices are bad (~17%): the budget (10%) is exhausted, but it never burns fast (50%)
+	level := assessBudget(ag, budgetObjective, &now, time.Hour, func(i int) bool { return i%6 == 0 })
+	assert.Equal(t, model.ASSESSMENT_LEVEL_BROKEN, level)
+	assert.Less(t, ag.Budget.Remaining, 0.0)
+	assert.Empty(t, ag.Budget.Burning)
+
+	// the bad slices leave the compliance window: the budget is restored
+	assert.Equal(t, model.ASSESSMENT_LEVEL_DESIRED, assessBudget(ag, budgetObjective, &now, time.Hour+time.Minute, always(false)))
+	assert.Equal(t, 1.0, ag.Budget.Remaining)
+}
+
+func TestBudgetSlicesRotation(t *testing.T) {
+	now := time.Date(2025, 5, 21, 17, 0, 0, 0, time.UTC)
+	ag := &model.AssessmentGuarantee{}
+
+	assessBudget(ag, budgetObjective, &now, 3*time.Hour, always(false))
+
+	// buckets of 5s (1h / 720) in the compliance window and of 12s (1m / 5) in the long window of the rule
+	assert.LessOrEqual(t, len(ag.Budget.Slices), budgetSlices+1)
+	assert.False(t, ag.Budget.Slices[0].Start.Add(5*time.Second).Before(now.Add(-time.Hour)))
+	assert.LessOrEqual(t, len(ag.Budget.Recent), 5*60/12+1)
+	assert.False(t, ag.Budget.Recent[0].Start.Add(12*time.Second).Before(now.Add(-5*time.Minute)))
+}
+
+func TestAddToBuckets(t *testing.T) {
+	t0 := time.Date(2025, 5, 21, 17, 0, 0, 0, time.UTC)
+	var buckets []model.BudgetBucket
+
+	buckets = addToBuckets(buckets, t0, time.Minute, 3*time.Minute, false)
+	buckets = addToBuckets(buckets, t0.Add(30*time.Second), time.Minute, 3*time.Minute, true)
+	assert.Equal(t, []model.BudgetBucket{{Start: t0, Good: 1, Bad: 1}}, buckets)
+
+	buckets = addToBuckets(buckets, t0.Add(90*time.Second), time.Minute, 3*time.Minute, true)
+	assert.Equal(t, []model.BudgetBucket{{Start: t0, Good: 1, Bad: 1}, {Start: t0.Add(time.Minute), Bad: 1}}, buckets)
+
+	// the first bucket leaves the retention time
+	buckets = addToBuckets(buckets, t0.Add(4*time.Minute), time.Minute, 3*time.Minute, false)
+	assert.Equal(t, []model.BudgetBucket{{Start: t0.Add(time.Minute), Bad: 1}, {Start: t0.Add(4 * time.Minute), Good: 1}}, buckets)
+
+	// the last bucket is always kept
+	buckets = addToBuckets(buckets, t0.Add(time.Hour), time.Minute, 0, true)
+	assert.Equal(t, []model.BudgetBucket{{Start: t0.Add(time.Hour), Bad: 1}}, buckets)
+}
+
+func TestBadRatio(t *testing.T) {
+	t0 := time.Date(2025, 5, 21, 17, 0, 0, 0, time.UTC)
+	buckets := []model.BudgetBucket{
+		{Start: t0, Good: 3, Bad: 1},
+		{Start: t0.Add(time.Minute), Good: 1, Bad: 3},
+	}
+
+	assert.Zero(t, badRatio(nil, t0, time.Minute, time.Hour))
+	assert.Equal(t, 0.5, badRatio(buckets, t0.Add(2*time.Minute), time.Minute, time.Hour))
+	// only the last bucket in the window
+	assert.Equal(t, 0.75, badRatio(buckets, t0.Add(2*time.Minute), time.Minute, time.Minute))
+	assert.Zero(t, badRatio(buckets, t0.Add(time.Hour), time.Minute, time.Minute))
+}
+
+func TestParseBurnRateRules(t *testing.T) {
+	rules := parseBurnRateRules([]model.BurnRateRule{
+		{Name: "fast", LongWindow: "1h", ShortWindow: "5m", Factor: 14.4},
+		{Name: "slow", LongWindow: "6h", ShortWindow: "30m", Factor: 6},
+		{Name: "windows", LongWindow: "5m", ShortWindow: "1h", Factor: 1},
+		{Name: "factor", LongWindow: "1h", ShortWindow: "5m"},
+		{Name: "duration", LongWindow: "week", ShortWindow: "5m", Factor: 1},
+	})
+	assert.Equal(t, []burnRateRule{
+		{name: "fast", long: time.Hour, short: 5 * time.Minute, factor: 14.4},
+		{name: "slow", long: 6 * time.Hour, short: 30 * time.Minute, factor: 6},
+	}, rules)
+}
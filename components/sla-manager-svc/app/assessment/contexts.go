@@ -0,0 +1,333 @@
+/*
+Copyright © 2024 EVIDEN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+This work has been implemented within the context of COLMENA project.
+*/
+package assessment
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cfgconst "colmena/sla-management-svc/app/common/cfg"
+	"colmena/sla-management-svc/app/common/logs"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// minSubscriberBackoff is the time to wait before the first reconnection of the context subscriber
+	minSubscriberBackoff = time.Second
+	// maxSubscriberBackoff is the maximum time to wait between reconnections of the context subscriber
+	maxSubscriberBackoff = time.Minute
+	// snapshotTimeout is the maximum duration of the query of the current values of the contexts
+	snapshotTimeout = 10 * time.Second
+	// subscriberIdleTimeout is the maximum time without receiving data from the event stream. After it, the stream is
+	// considered lost (e.g. half-open connection) and the subscriber reconnects
+	subscriberIdleTimeout = 5 * time.Minute
+)
+
+// ContextSource provides the values of the contexts of the agent (see CheckScopedQoSDefinitions)
+type ContextSource interface {
+	Contexts() []ResponseData
+}
+
+// pollingContextSource queries all the contexts to Zenoh each time they are requested (see getContextResults)
+type pollingContextSource struct {
+	vconfig *viper.Viper
+}
+
+// NewPollingContextSource returns a ContextSource that queries all the contexts to Zenoh each time
+func NewPollingContextSource(vconfig *viper.Viper) ContextSource {
+	return pollingContextSource{vconfig: vconfig}
+}
+
+// Contexts returns the contexts from Zenoh
+func (p pollingContextSource) Contexts() []ResponseData {
+	return getContextResults(p.vconfig)
+}
+
+/*
+ContextSubscriber is a ContextSource that subscribes to the contexts of the agent with the Server-Sent Events mode of the
+Zenoh REST plugin (GET <endpoint>/colmena/contexts/<agent>/** with "Accept: text/event-stream"), and keeps a local
+cache of the values. Each time a value changes, a signal is sent to the Changes channel.
+
+On (re)connection, the current values of the contexts are queried to refresh the cache. If the connection fails, is
+closed or receives no data in subscriberIdleTimeout, the subscriber reconnects with exponential backoff. While the
+subscriber is not connected, the contexts are queried to Zenoh each time they are requested (as pollingContextSource).
+*/
+type ContextSubscriber struct {
+	keyExpr     string
+	client      *http.Client
+	idleTimeout time.Duration
+	polling     ContextSource
+
+	mu        sync.RWMutex
+	cache     map[string]ResponseData
+	connected bool
+	changes   chan struct{}
+}
+
+// NewContextSubscriber creates a subscriber to the contexts of the agent. Call Run to start the subscription.
+func NewContextSubscriber(vconfig *viper.Viper) *ContextSubscriber {
+	return &ContextSubscriber{
+		keyExpr:     vconfig.GetString(cfgconst.ContextZenohEndpointPropertyName) + agentContextsKey(vconfig) + "/**",
+		client:      &http.Client{},
+		idleTimeout: subscriberIdleTimeout,
+		polling:     NewPollingContextSource(vconfig),
+		cache:       make(map[string]ResponseData),
+		changes:     make(chan struct{}, 1),
+	}
+}
+
+// Contexts returns the cached values of the contexts, or the contexts from Zenoh if the subscriber is not connected
+func (s *ContextSubscriber) Contexts() []ResponseData {
+	s.mu.RLock()
+	if !s.connected {
+		s.mu.RUnlock()
+		logs.GetLogger().Debug(pathLOG + "[ContextSubscriber] Not connected. Querying contexts ...")
+		return s.polling.Contexts()
+	}
+	defer s.mu.RUnlock()
+
+	res := make([]ResponseData, 0, len(s.cache))
+	for _, item := range s.cache {
+		res = append(res, item)
+	}
+	return res
+}
+
+// Changes returns the channel that signals a change in the contexts. Several changes may be signaled only once.
+func (s *ContextSubscriber) Changes() <-chan struct{} {
+	return s.changes
+}
+
+// Run subscribes to the contexts until ctx is done, reconnecting with exponential backoff
+func (s *ContextSubscriber) Run(ctx context.Context) {
+	backoff := minSubscriberBackoff
+	for ctx.Err() == nil {
+		logs.GetLogger().Info(pathLOG + "[ContextSubscriber] Subscribing to contexts [" + s.keyExpr + "] ...")
+		connected, err := s.subscribe(ctx)
+		s.setConnected(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = minSubscriberBackoff
+		}
+		logs.GetLogger().Warn(pathLOG+"[ContextSubscriber] Subscription closed. Reconnecting in "+backoff.String()+" ... ", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxSubscriberBackoff {
+			backoff = maxSubscriberBackoff
+		}
+	}
+}
+
+// subscribe opens the event stream, refreshes the cache and processes the events until the stream is closed or idle.
+// Returns true if the stream was opened.
+func (s *ContextSubscriber) subscribe(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.keyExpr, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, errors.New("unexpected status " + strconv.Itoa(resp.StatusCode))
+	}
+
+	// the stream is opened before the snapshot, so that no change is lost
+	if err := s.refresh(ctx); err != nil {
+		logs.GetLogger().Warn(pathLOG+"[ContextSubscriber] Error getting the current contexts: ", err)
+	} else {
+		s.setConnected(true)
+	}
+	logs.GetLogger().Info(pathLOG + "[ContextSubscriber] Subscribed to contexts [" + s.keyExpr + "]")
+
+	// the request is cancelled (and the stream closed) if no data is received in idleTimeout
+	idle := time.AfterFunc(s.idleTimeout, cancel)
+	defer idle.Stop()
+	err = s.readEvents(resp.Body, func() { idle.Reset(s.idleTimeout) })
+	if !idle.Stop() && ctx.Err() != nil {
+		err = errors.New("no data received in " + s.idleTimeout.String())
+	}
+	return true, err
+}
+
+// setConnected sets whether the cache is kept up to date by the event stream
+func (s *ContextSubscriber) setConnected(connected bool) {
+	s.mu.Lock()
+	s.connected = connected
+	s.mu.Unlock()
+}
+
+/*
+readEvents processes the events of the stream:
+
+	event: PUT
+	data: {"key":"colmena/contexts/ColmenaAgent1/company_premises","value":{"building":"Red"},"encoding":"application/json","time":"..."}
+
+	event: DELETE
+	data: {"key":"colmena/contexts/ColmenaAgent1/company_premises","value":"","encoding":"","time":"..."}
+
+received is called for each line received (including the comments sent as keep-alive).
+*/
+func (s *ContextSubscriber) readEvents(body io.Reader, received func()) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	event, data := "", ""
+	for scanner.Scan() {
+		received()
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// end of event
+			if len(data) > 0 {
+				s.processEvent(event, data)
+			}
+			event, data = "", ""
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// processEvent updates the cache with an event of the stream
+func (s *ContextSubscriber) processEvent(event string, data string) {
+	var item ResponseData
+	if err := json.Unmarshal([]byte(data), &item); err != nil {
+		logs.GetLogger().Warn(pathLOG+"[ContextSubscriber] Invalid event data "+data+": ", err)
+		return
+	}
+	logs.GetLogger().Debug(pathLOG + "[ContextSubscriber] Event " + event + " " + item.Key)
+
+	s.mu.Lock()
+	old, found := s.cache[item.Key]
+	changed := false
+	if strings.EqualFold(event, "DELETE") {
+		delete(s.cache, item.Key)
+		changed = found
+	} else {
+		item.Value = decodeContextValue(item)
+		s.cache[item.Key] = item
+		changed = !found || !reflect.DeepEqual(old.Value, item.Value)
+	}
+	s.mu.Unlock()
+
+	if changed {
+		s.notify()
+	}
+}
+
+// refresh replaces the cache with the current values of the contexts
+func (s *ContextSubscriber) refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.keyExpr, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("unexpected status " + strconv.Itoa(resp.StatusCode))
+	}
+
+	var items []ResponseData
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return err
+	}
+
+	cache := make(map[string]ResponseData, len(items))
+	for _, item := range items {
+		item.Value = decodeContextValue(item)
+		cache[item.Key] = item
+	}
+
+	s.mu.Lock()
+	changed := !reflect.DeepEqual(s.cache, cache)
+	s.cache = cache
+	s.mu.Unlock()
+
+	if changed {
+		s.notify()
+	}
+	return nil
+}
+
+// notify signals a change without blocking; pending signals are merged
+func (s *ContextSubscriber) notify() {
+	select {
+	case s.changes <- struct{}{}:
+	default:
+	}
+}
+
+// decodeContextValue returns the value of a context, decoding the JSON values received as strings
+func decodeContextValue(item ResponseData) interface{} {
+	str, ok := item.Value.(string)
+	if !ok || !strings.Contains(item.Encoding, "json") {
+		return item.Value
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(str), &value); err != nil {
+		return item.Value
+	}
+	return value
+}
+
+// agentContextsKey returns the Zenoh key of the contexts of the agent (e.g. "colmena/contexts/ColmenaAgent1")
+func agentContextsKey(vconfig *viper.Viper) string {
+	agent := vconfig.GetString(cfgconst.AgentIdPropertyName)
+	if len(agent) == 0 {
+		agent = vconfig.GetString(cfgconst.ComposeProjectPropertyName)
+	}
+	return vconfig.GetString(cfgconst.ContextZenohContextsPropertyName) + "/" + agent
+}
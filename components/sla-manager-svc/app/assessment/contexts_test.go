@@ -0,0 +1,90 @@
+package assessment
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fixedContextSource returns always the same contexts
+type fixedContextSource []ResponseData
+
+func (f fixedContextSource) Contexts() []ResponseData { return f }
+
+const testContext = `{"key":"colmena/contexts/agent1/company_premises","value":{"building":"Red"},"encoding":"application/json"}`
+
+// newTestSubscriber returns a subscriber to the contexts served by handler
+func newTestSubscriber(t *testing.T, handler http.HandlerFunc) *ContextSubscriber {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return &ContextSubscriber{
+		keyExpr:     srv.URL + "/colmena/contexts/agent1/**",
+		client:      srv.Client(),
+		idleTimeout: 100 * time.Millisecond,
+		polling:     fixedContextSource{{Key: "polled"}},
+		cache:       make(map[string]ResponseData),
+		changes:     make(chan struct{}, 1),
+	}
+}
+
+func TestContextSubscriberRefreshStatus(t *testing.T) {
+	s := newTestSubscriber(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	s.cache["old"] = ResponseData{Key: "old"}
+
+	err := s.refresh(context.Background())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "503")
+	}
+	assert.Contains(t, s.cache, "old")
+}
+
+func TestContextSubscriberIdleTimeout(t *testing.T) {
+	s := newTestSubscriber(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "text/event-stream" {
+			fmt.Fprint(w, "["+testContext+"]")
+			return
+		}
+		// one event, and then nothing (e.g. half-open connection)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: PUT\ndata: "+testContext+"\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+
+	done := make(chan struct{})
+	var connected bool
+	var err error
+	go func() {
+		connected, err = s.subscribe(context.Background())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the idle stream was not closed")
+	}
+	assert.True(t, connected)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no data received")
+	}
+	assert.Len(t, s.cache, 1)
+}
+
+func TestContextSubscriberFallback(t *testing.T) {
+	s := newTestSubscriber(t, func(w http.ResponseWriter, r *http.Request) {})
+	s.cache["cached"] = ResponseData{Key: "cached"}
+
+	// not connected: the contexts are queried
+	assert.Equal(t, []ResponseData{{Key: "polled"}}, s.Contexts())
+
+	s.setConnected(true)
+	assert.Equal(t, []ResponseData{{Key: "cached"}}, s.Contexts())
+}
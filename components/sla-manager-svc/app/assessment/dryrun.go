@@ -0,0 +1,181 @@
+/*
+Copyright © 2024 EVIDEN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+This work has been implemented within the context of COLMENA project.
+*/
+package assessment
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	amodel "colmena/sla-management-svc/app/assessment/model"
+	"colmena/sla-management-svc/app/assessment/monitor/simpleadapter"
+	"colmena/sla-management-svc/app/common/expressions"
+	"colmena/sla-management-svc/app/common/logs"
+	"colmena/sla-management-svc/app/model"
+
+	"github.com/Knetic/govaluate"
+)
+
+/*
+DryRun evaluates once the guarantees of the SLAs (e.g. created from a service definition that is not deployed yet)
+and returns, for each guarantee, the parsed constraint, the PromQL queries, the values obtained and the resulting level.
+
+If values is not empty, the metrics are taken from values (synthetic values, by variable name); otherwise, they are
+retrieved from cfg.Adapter. Nothing is persisted or notified: cfg.Repo, cfg.Notifier and cfg.History are not used.
+The labels of the scopes are not bound (the context is not checked).
+*/
+func DryRun(ctx context.Context, cfg Config, slas []model.SLA, values map[string]float64) []model.OutputDryRunKpi {
+	dryCfg := Config{
+		Now:     cfg.Now,
+		Adapter: cfg.Adapter,
+	}
+	if len(values) > 0 {
+		dryCfg.Adapter = simpleadapter.New(syntheticValues(values, cfg.Now))
+	}
+
+	res := make([]model.OutputDryRunKpi, 0)
+	for _, sla := range slas {
+		res = append(res, dryRunSLA(ctx, dryCfg, sla, values)...)
+	}
+	return res
+}
+
+// dryRunSLA evaluates a copy of the SLA (see DryRun)
+func dryRunSLA(ctx context.Context, cfg Config, sla model.SLA, values map[string]float64) []model.OutputDryRunKpi {
+	dry := sla.Clone()
+	dry.State = model.STARTED
+	dry.Creation = cfg.Now
+
+	// check the constraints; only the valid guarantees are evaluated
+	kpis := make([]model.OutputDryRunKpi, len(dry.Details.Guarantees))
+	vars := make(map[string][]string)
+	valid := make([]model.Guarantee, 0, len(dry.Details.Guarantees))
+	for i, gt := range dry.Details.Guarantees {
+		kpis[i] = model.OutputDryRunKpi{
+			RoleId:     gt.Role,
+			Query:      gt.OQuery,
+			Constraint: gt.Constraint,
+			PromQL:     []string{},
+			Scope:      gt.Scope,
+			Threshold:  gt.Threshold,
+			Values:     []model.MetricValue{},
+			Level:      model.ASSESSMENT_LEVEL_NORESULTS,
+		}
+
+		if _, _, err := expressions.CheckAndParseConstraint(gt.OQuery); err != nil {
+			kpis[i].Error = err.Error()
+			continue
+		}
+		gtVars, err := constraintVars(gt.Constraint)
+		if err != nil {
+			kpis[i].Error = err.Error()
+			continue
+		}
+		for _, v := range gtVars {
+			variable, _ := dry.Details.GetVariable(v)
+			kpis[i].PromQL = append(kpis[i].PromQL, decodeQuery(variable.Metric))
+			if _, ok := values[decodeQuery(v)]; len(values) > 0 && !ok {
+				kpis[i].Error = "no synthetic value for metric '" + decodeQuery(v) + "'"
+			}
+		}
+		if len(kpis[i].Error) > 0 {
+			continue
+		}
+
+		vars[gt.Name] = gtVars
+		valid = append(valid, gt)
+	}
+	dry.Details.Guarantees = valid
+
+	if len(valid) > 0 {
+		result, _ := AssessQoS(ctx, &dry, cfg)
+		checkViolationLevel(&dry, result)
+
+		for i, gt := range sla.Details.Guarantees {
+			gtVars, ok := vars[gt.Name]
+			if !ok {
+				continue
+			}
+			if result.LastValues == nil {
+				// AssessQoS returns an empty result if the evaluation fails
+				kpis[i].Error = "error evaluating the constraint"
+				continue
+			}
+
+			kpis[i].Values = expressionValues(result.LastValues[gt.Name], gtVars)
+			ag := dry.Assessment.GetGuarantee(gt.Name)
+			kpis[i].Violated = ag.Violated
+			kpis[i].Level = ag.Level
+			if ag.Violated {
+				kpis[i].FailedTerms = failedTerms(gt.Constraint, result.LastValues[gt.Name])
+			}
+		}
+	}
+	return kpis
+}
+
+// constraintVars returns the variables (metrics) of a constraint
+func constraintVars(constraint string) ([]string, error) {
+	parsed, err := parseConstraint(constraint)
+	if err != nil {
+		return nil, err
+	}
+	expression, err := govaluate.NewEvaluableExpression(parsed)
+	if err != nil {
+		return nil, err
+	}
+	return expression.Vars(), nil
+}
+
+// syntheticValues returns the monitoring data used in a dry-run with synthetic values
+func syntheticValues(values map[string]float64, now time.Time) amodel.GuaranteeData {
+	data := make(amodel.ExpressionData, len(values))
+	for key, value := range values {
+		name := encodeQuery(key)
+		data[name] = model.MetricValue{
+			Key:      key,
+			Value:    value,
+			DateTime: now,
+		}
+	}
+	logs.GetLogger().Debug(pathLOG+"[syntheticValues] Synthetic values: ", data)
+	return amodel.GuaranteeData{data}
+}
+
+// expressionValues returns the values of the variables vars in data
+func expressionValues(data amodel.ExpressionData, vars []string) []model.MetricValue {
+	res := make([]model.MetricValue, 0, len(vars))
+	for _, v := range vars {
+		if value, ok := data[v]; ok {
+			res = append(res, value)
+		}
+	}
+	return res
+}
+
+// encodeQuery encodes the brackets of a query as in the constraint variables (see parseConstraint)
+func encodeQuery(query string) string {
+	query = strings.ReplaceAll(query, "[", "%5B")
+	return strings.ReplaceAll(query, "]", "%5D")
+}
+
+// decodeQuery returns the query sent to the monitoring from a constraint variable (see parseConstraint)
+func decodeQuery(query string) string {
+	query = strings.ReplaceAll(query, "%5B", "[")
+	return strings.ReplaceAll(query, "%5D", "]")
+}
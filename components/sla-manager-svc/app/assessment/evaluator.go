@@ -18,8 +18,12 @@ This work has been implemented within the context of COLMENA project.
 package assessment
 
 import (
+	"context"
+	"errors"
+	"sync"
 	"time"
 
+	"colmena/sla-management-svc/app/assessment/history"
 	amodel "colmena/sla-management-svc/app/assessment/model"
 	"colmena/sla-management-svc/app/assessment/monitor"
 	"colmena/sla-management-svc/app/assessment/notifier"
@@ -27,6 +31,7 @@ import (
 	"colmena/sla-management-svc/app/model"
 
 	"github.com/Knetic/govaluate"
+	uuid "github.com/lithammer/shortuuid/v4"
 )
 
 // path used in logs
@@ -51,92 +56,231 @@ type Config struct {
 
 	// Transient is time to wait until a new violation of a GT can be raised again (default value is zero)
 	Transient time.Duration
+
+	// History stores the result of each assessment cycle (optional)
+	History *history.Store
+
+	// Scheduler selects the SLAs to evaluate in each execution (optional; if nil, all the SLAs are evaluated)
+	Scheduler *Scheduler
+
+	// Workers is the maximum number of SLAs assessed in parallel (default value is 1)
+	Workers int
+
+	// Timeout is the maximum duration of the assessment of a SLA (default value is zero: no timeout)
+	Timeout time.Duration
 }
 
 /*
 AssessActiveQoSDefinitions will get the active QoS from the provided repository and assess them, notifying about violations with
 the provided notifier. Mandatory fields filled in cfg are Repo, Adapter and Now.
+
+The SLAs are assessed in parallel by up to cfg.Workers workers; each assessment is cancelled after cfg.Timeout. The violations
+of all the SLAs are notified at the end of the execution.
 */
 func AssessActiveQoSDefinitions(cfg Config) {
 	repo := cfg.Repo
 	not := cfg.Notifier
 
+	if cfg.Scheduler != nil && !cfg.Scheduler.Reload(cfg.Now) {
+		logs.GetLogger().Debug(pathLOG + "[AssessActiveQoSDefinitions] No guarantees due")
+		return
+	}
+
 	// Retrieve all active QoS definitions
 	qosdefs, err := repo.GetSLAsByState(model.STARTED, model.STOPPED)
 
 	if err != nil {
 		logs.GetLogger().Error(pathLOG+"[AssessActiveQoSDefinitions] Error getting active qos definitions: %s", err.Error())
-	} else {
-		logs.GetLogger().Infof(pathLOG+"[AssessActiveQoSDefinitions] [%d SLAs definitions to evaluate]", len(qosdefs))
-
-		grouped_qosdefs, err := groupSLAsByServiceId(qosdefs, repo)
-		if err == nil {
-
-			var violations []model.ColmenaOutputSLA // list of all violations
-			var statuses []model.OutputSLA   // list of all violations
-
-			// iterate SLA evaluation results
-			for _, qosdefs2 := range grouped_qosdefs {
-				if len(qosdefs2) > 0 {
-					logs.GetLogger().Infof(pathLOG + "[AssessActiveQoSDefinitions] => Evaluating service [" + qosdefs2[0].Name + "]")
-
-					for _, qosd := range qosdefs2 {
-						if qosd.State != model.STARTED {
-							logs.GetLogger().Warn(pathLOG + "[AssessActiveQoSDefinitions] SLA with ID " + qosd.Id + " has the status " + string(qosd.State))
-						} else {
-							// do QoS assessment
-							logs.GetLogger().Debug(pathLOG+"[AssessActiveQoSDefinitions] ===> SLA Assessment ", qosd.Id)
-
-							result, totalResults := AssessQoS(&qosd, cfg)
-							qosd.Assessment.TotalExecutions += 1
-
-							// violation?
-							violation := not != nil && len(result.Violated) > 0
-							if violation {
-								qosd.Assessment.TotalViolations += 1
-								qosd.Assessment.Violated = true
-
-							} else {
-								qosd.Assessment.Violated = false
-							}
-
-							// check and set violation levels
-							//logs.GetLogger().Debug(pathLOG+"[AssessActiveQoSDefinitions] SLA Assessment LEVEL ", qosd.Assessment.Level)
-							checkViolationLevel(&qosd, totalResults, result)
-							//logs.GetLogger().Debug(pathLOG+"[AssessActiveQoSDefinitions] SLA Assessment LEVEL ", qosd.Assessment.Level)
-
-							// notify violations or status
-							if violation {
-								violation_result := GenerateViolationOutput(qosd, result)
-								if violation_result.ServiceId != "" {
-									violations = append(violations, violation_result)
-								}
-							} else {
-								//logs.GetLogger().Debug(pathLOG+"[AssessActiveQoSDefinitions] SLA Assessment LEVEL ", qosd.Assessment.Level)
-								not.NotifyStatus(&qosd, &result)
-							}
-
-							// update SLA
-							repo.UpdateSLA(&qosd)
-						}
-					}
-				} else {
-					logs.GetLogger().Error(pathLOG + "[AssessActiveQoSDefinitions] Error: emty service SLA found!")
-				}
-			}
+		return
+	}
 
-			if len(violations) > 0 {
-				not.NotifyAllViolations(violations)
-			}
+	if cfg.Scheduler != nil {
+		qosdefs = cfg.Scheduler.DueSLAs(qosdefs, cfg.Now)
+		if len(qosdefs) == 0 {
+			logs.GetLogger().Debug(pathLOG + "[AssessActiveQoSDefinitions] No SLAs to evaluate")
+			return
+		}
+	}
+	logs.GetLogger().Infof(pathLOG+"[AssessActiveQoSDefinitions] [%d SLAs definitions to evaluate]", len(qosdefs))
+
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	outputs := make([]*model.ColmenaOutputSLA, 0, len(qosdefs))
+	var mu sync.Mutex
 
-			if len(statuses) > 0 {
-				// TODO send all notifications
+	// iterate SLA evaluation results
+	for _, qosdefs2 := range groupSLAsByServiceId(qosdefs) {
+		logs.GetLogger().Infof(pathLOG + "[AssessActiveQoSDefinitions] => Evaluating service [" + qosdefs2[0].Name + "]")
 
+		for _, qosd := range qosdefs2 {
+			if qosd.State != model.STARTED {
+				logs.GetLogger().Warn(pathLOG + "[AssessActiveQoSDefinitions] SLA with ID " + qosd.Id + " has the status " + string(qosd.State))
+				continue
 			}
-		} else {
-			logs.GetLogger().Error(pathLOG+"[AssessActiveQoSDefinitions] Error getting SLAs by id: %s", err.Error())
+
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(qosd model.SLA) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				ctx, cancel := slaContext(cfg)
+				defer cancel()
+
+				if out := assessSLA(ctx, &qosd, cfg); out != nil {
+					mu.Lock()
+					outputs = append(outputs, out)
+					mu.Unlock()
+				}
+			}(qosd)
 		}
 	}
+	wg.Wait()
+
+	// list of all violations
+	var violations []model.ColmenaOutputSLA
+	for _, out := range outputs {
+		violations = append(violations, *out)
+	}
+	if len(violations) > 0 {
+		not.NotifyAllViolations(violations)
+	}
+}
+
+// slaContext returns the context of the assessment of a SLA, with the deadline set in cfg.Timeout (if any)
+func slaContext(cfg Config) (context.Context, context.CancelFunc) {
+	if cfg.Timeout > 0 {
+		return context.WithTimeout(context.Background(), cfg.Timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+/*
+assessSLA assesses a STARTED SLA: it evaluates the guarantees, sets the levels, stores the SLA, the violations and the
+history, and notifies the status (if not violated). Returns the violation output to be notified, or nil if not violated.
+The results are only notified if the SLA is stored (see updateSLA).
+
+If ctx is done before the assessment ends (e.g. the monitoring is too slow), the results are discarded.
+*/
+func assessSLA(ctx context.Context, qosd *model.SLA, cfg Config) *model.ColmenaOutputSLA {
+	repo := cfg.Repo
+	not := cfg.Notifier
+
+	// do QoS assessment
+	logs.GetLogger().Debug(pathLOG+"[assessSLA] ===> SLA Assessment ", qosd.Id)
+
+	result, _ := AssessQoS(ctx, qosd, cfg)
+	if ctx.Err() != nil {
+		logs.GetLogger().Warn(pathLOG+"[assessSLA] Assessment of SLA "+qosd.Id+" cancelled. Discarding results: ", ctx.Err())
+		discarded(cfg, qosd)
+		return nil
+	}
+	qosd.Assessment.TotalExecutions += 1
+
+	// check and set violation levels (before checking the violations, as the error budgets may discard them)
+	checkViolationLevel(qosd, result)
+
+	// violation?
+	violation := not != nil && len(result.Violated) > 0
+	if violation {
+		qosd.Assessment.TotalViolations += 1
+		qosd.Assessment.Violated = true
+	} else {
+		qosd.Assessment.Violated = false
+	}
+
+	// update SLA: if the update is discarded, nothing is notified nor stored
+	if !updateSLA(repo, qosd) {
+		discarded(cfg, qosd)
+		return nil
+	}
+
+	// store the raised violations
+	persistViolations(repo, result)
+	recordHistory(cfg, qosd, result)
+
+	// notify violations or status
+	var output *model.ColmenaOutputSLA
+	if violation {
+		violation_result := GenerateViolationOutput(*qosd, result)
+		if violation_result.ServiceId != "" {
+			output = &violation_result
+		}
+	} else if not != nil {
+		not.NotifyStatus(qosd, &result)
+	}
+	return output
+}
+
+// discarded tells the scheduler (if any) that the results of the assessment of the SLA were discarded
+func discarded(cfg Config, qosd *model.SLA) {
+	if cfg.Scheduler != nil {
+		cfg.Scheduler.Discarded(qosd.Id)
+	}
+}
+
+/*
+updateSLA persists the SLA. If the SLA was modified by another thread (e.g. the context check thread) after being read,
+the update is discarded: the SLA will be assessed again, with the stored values, in the next execution.
+Returns true if the SLA was updated.
+*/
+func updateSLA(repo model.IRepository, qosd *model.SLA) bool {
+	_, err := repo.UpdateSLA(qosd)
+	if errors.Is(err, model.ErrConflict) {
+		logs.GetLogger().Warn(pathLOG + "[updateSLA] SLA " + qosd.Id + " was modified during the assessment. Discarding assessment results ...")
+		return false
+	} else if err != nil {
+		logs.GetLogger().Error(pathLOG+"[updateSLA] Error updating SLA "+qosd.Id+": ", err)
+		return false
+	}
+	return true
+}
+
+/*
+persistViolations stores in the repository the violations raised in an assessment
+*/
+func persistViolations(repo model.IRepository, result amodel.Result) {
+	for _, v := range result.GetViolations() {
+		if _, err := repo.CreateViolation(&v); err != nil {
+			logs.GetLogger().Error(pathLOG+"[persistViolations] Error storing violation "+v.Id+": ", err)
+		}
+	}
+}
+
+/*
+recordHistory adds the result of the assessment cycle of each guarantee to the KPI history of the SLA
+*/
+func recordHistory(cfg Config, qosd *model.SLA, result amodel.Result) {
+	if cfg.History == nil {
+		return
+	}
+
+	for _, gt := range qosd.Details.Guarantees {
+		if result.Skipped[gt.Name] {
+			continue
+		}
+		var value interface{}
+		for _, v := range result.LastValues[gt.Name] {
+			value = v.Value
+			break
+		}
+
+		ag := qosd.Assessment.GetGuarantee(gt.Name)
+		cfg.History.Add(qosd.Id, model.KPIHistoryPoint{
+			Timestamp:       cfg.Now,
+			Guarantee:       gt.Name,
+			Value:           value,
+			Threshold:       gt.Threshold,
+			Level:           ag.Level,
+			Violated:        ag.Violated,
+			BudgetRemaining: budgetRemaining(ag),
+		})
+	}
 }
 
 /*
@@ -153,7 +297,7 @@ The output is:
 The function results are not persisted. The output must be persisted/handled accordingly.
 E.g.: QoSDefinition and Violations must be persisted to DB. Violations must be notified to observers
 */
-func AssessQoS(a *model.SLA, cfg Config) (amodel.Result, int) {
+func AssessQoS(ctx context.Context, a *model.SLA, cfg Config) (amodel.Result, int) {
 	now := cfg.Now
 
 	if a.Expiration != nil && a.Expiration.Before(now) {
@@ -166,7 +310,7 @@ func AssessQoS(a *model.SLA, cfg Config) (amodel.Result, int) {
 		logs.GetLogger().Debug(pathLOG+"[AssessQoS] Assessing QoS with ID: ", a.Id)
 
 		// evaluates the guarantee terms defined in the QoS definition
-		result, t, err := EvaluateGuaranteeTerms(a, cfg)
+		result, t, err := EvaluateGuaranteeTerms(ctx, a, cfg)
 		if err != nil {
 			logs.GetLogger().Warn(pathLOG + "[AssessQoS] Error evaluating QoSDefinition " + a.Id + ": " + err.Error())
 			return amodel.Result{}, 0
@@ -189,7 +333,7 @@ EvaluateGuaranteeTerms evaluates the guarantee terms of a QoS definition. The me
 The MonitoringAdapter must feed the process correctly (e.g. if the constraint of a guarantee term is of the type "A>B && C>D", the
 MonitoringAdapter must supply pairs of values).
 */
-func EvaluateGuaranteeTerms(a *model.SLA, cfg Config) (amodel.Result, int, error) {
+func EvaluateGuaranteeTerms(ctx context.Context, a *model.SLA, cfg Config) (amodel.Result, int, error) {
 	ma := cfg.Adapter.Initialize(a)
 	now := cfg.Now
 
@@ -197,14 +341,28 @@ func EvaluateGuaranteeTerms(a *model.SLA, cfg Config) (amodel.Result, int, error
 		Violated:      map[string]amodel.EvaluationGtResult{},
 		LastValues:    map[string]amodel.ExpressionData{},
 		LastExecution: map[string]time.Time{},
+		Series:        map[string]map[string]amodel.ExpressionData{},
+		Skipped:       map[string]bool{},
 	}
 	gts := a.Details.Guarantees
 
 	totalResults := 0
 
 	for _, gt := range gts {
+		if ctx.Err() != nil {
+			return amodel.Result{}, 0, ctx.Err()
+		}
+		if cfg.Scheduler != nil && !cfg.Scheduler.IsDue(a.Id, gt.Name) {
+			result.Skipped[gt.Name] = true
+			continue
+		}
+		if len(gt.Period) == 0 && cfg.Scheduler != nil {
+			// the retrieval window is bounded by the period of the guarantee (see getDefaultFrom)
+			gt.Period = cfg.Scheduler.Period().String()
+		}
+
 		// evaluates a guarantee term of the QoS Definition
-		failed, lastvalues, _, err := EvaluateGuarantee(a, gt, ma, cfg)
+		failed, lastvalues, series, _, err := EvaluateGuarantee(ctx, a, gt, ma, cfg)
 		if err != nil {
 			logs.GetLogger().Warn(pathLOG + "[EvaluateGuaranteeTerms] Error evaluating expression " + gt.Constraint + ": " + err.Error())
 			return amodel.Result{}, 0, err
@@ -212,7 +370,7 @@ func EvaluateGuaranteeTerms(a *model.SLA, cfg Config) (amodel.Result, int, error
 
 		if len(failed) > 0 {
 			// VIOLATIONS
-			violations := EvaluateGtViolations(a, gt, failed, cfg.Transient) // Evaluates violation
+			violations := EvaluateGtViolations(a, gt, failed, cfg.Transient, len(series) > 0) // Evaluates violation
 			gtResult := amodel.EvaluationGtResult{
 				Metrics:    failed,
 				Violations: violations,
@@ -222,6 +380,9 @@ func EvaluateGuaranteeTerms(a *model.SLA, cfg Config) (amodel.Result, int, error
 		}
 		result.LastValues[gt.Name] = lastvalues
 		result.LastExecution[gt.Name] = now
+		if len(series) > 0 {
+			result.Series[gt.Name] = series
+		}
 
 		logs.GetLogger().Debug(pathLOG+"[EvaluateGuaranteeTerms] result: ", result)
 
@@ -231,9 +392,11 @@ func EvaluateGuaranteeTerms(a *model.SLA, cfg Config) (amodel.Result, int, error
 
 /*
 EvaluateGuarantee evaluates a guarantee term of a QoS Definition (see EvaluateGuaranteeTerms) and returns the metrics that failed the GT constraint.
+If the queries of the guarantee return several series (e.g. one per instance of a role), each series is evaluated
+independently, and the last values of each series are returned too (see lastSeriesValues).
 */
-func EvaluateGuarantee(a *model.SLA, gt model.Guarantee, ma monitor.MonitoringAdapter,
-	cfg Config) (failed []amodel.ExpressionData, last amodel.ExpressionData, totalResults int, err error) {
+func EvaluateGuarantee(ctx context.Context, a *model.SLA, gt model.Guarantee, ma monitor.MonitoringAdapter,
+	cfg Config) (failed []amodel.ExpressionData, last amodel.ExpressionData, series map[string]amodel.ExpressionData, totalResults int, err error) {
 
 	logs.GetLogger().Debug(pathLOG + "[EvaluateGuarantee] Evaluating Guarantee [" + gt.Name + "] of QoS with ID [" + a.Id + "]; Expression: " + gt.Constraint)
 	totalResults = 0
@@ -242,17 +405,17 @@ func EvaluateGuarantee(a *model.SLA, gt model.Guarantee, ma monitor.MonitoringAd
 	constraintParsedExpr, err := parseConstraint(gt.Constraint)
 	if err != nil {
 		logs.GetLogger().Error(pathLOG+"[EvaluateGuarantee] Error parsing expression: ", gt.Constraint)
-		return nil, nil, totalResults, err
+		return nil, nil, nil, totalResults, err
 	}
 
 	expression, err := govaluate.NewEvaluableExpression(constraintParsedExpr) //constraintParsedExpr) //gt.Constraint)
 	if err != nil {
 		logs.GetLogger().Error(pathLOG+"[EvaluateGuarantee] Error parsing expression: ", constraintParsedExpr)
-		return nil, nil, totalResults, err
+		return nil, nil, nil, totalResults, err
 	}
 
 	logs.GetLogger().Debug(pathLOG + "[EvaluateGuarantee] Getting values from monitor ...")
-	values := ma.GetValues(gt, expression.Vars(), cfg.Now)
+	values := ma.GetValues(ctx, gt, expression.Vars(), cfg.Now)
 
 	if len(values) == 0 {
 		logs.GetLogger().Warn(pathLOG+"[EvaluateGuarantee] No values found for Guarantee ["+gt.Name+"] of agreement with ID: ", a.Id)
@@ -264,7 +427,7 @@ func EvaluateGuarantee(a *model.SLA, gt model.Guarantee, ma monitor.MonitoringAd
 		aux, err := evaluateExpression(expression, value)
 		if err != nil {
 			logs.GetLogger().Warn("[EvaluateGuarantee] Error evaluating expression " + gt.Constraint + ": " + err.Error())
-			return nil, nil, totalResults, err
+			return nil, nil, nil, totalResults, err
 		}
 		if aux != nil {
 			failed = append(failed, aux)
@@ -272,18 +435,41 @@ func EvaluateGuarantee(a *model.SLA, gt model.Guarantee, ma monitor.MonitoringAd
 	}
 	if len(values) > 0 {
 		last = values[len(values)-1]
+		series = lastSeriesValues(a.Assessment.GetGuarantee(gt.Name), values)
 	}
 	totalResults = len(failed)
 
-	return failed, last, totalResults, nil
+	return failed, last, series, totalResults, nil
 }
 
 /*
-EvaluateGtViolations creates violations for the detected violated metrics in EvaluateGuarantee
+lastSeriesValues returns the last values of each series (by key) of a guarantee, if the values contain several series
+or the guarantee was already assessed by series; nil otherwise
 */
-func EvaluateGtViolations(a *model.SLA, gt model.Guarantee, violated amodel.GuaranteeData, transientTime time.Duration) []model.Violation {
+func lastSeriesValues(ag model.AssessmentGuarantee, values amodel.GuaranteeData) map[string]amodel.ExpressionData {
+	series := make(map[string]amodel.ExpressionData)
+	for _, value := range values {
+		series[value.Series()] = value
+	}
+	if len(series) > 1 {
+		return series
+	}
+	if _, unlabeled := series[""]; len(ag.Series) > 0 && !unlabeled {
+		return series
+	}
+	return nil
+}
+
+/*
+EvaluateGtViolations creates violations for the detected violated metrics in EvaluateGuarantee. If perSeries is true
+(the guarantee has several series), the violations are raised per series: each violation contains the labels of its
+series, and the transient time is applied to each series independently.
+*/
+func EvaluateGtViolations(a *model.SLA, gt model.Guarantee, violated amodel.GuaranteeData, transientTime time.Duration, perSeries bool) []model.Violation {
 	gtv := make([]model.Violation, 0, len(violated))
-	lastViolation := a.Assessment.GetGuarantee(gt.Name).LastViolation
+	ag := a.Assessment.GetGuarantee(gt.Name)
+	lastViolation := ag.LastViolation
+	lastSeriesViolation := make(map[string]*model.Violation)
 
 	for _, tuple := range violated {
 		// build values map and find newer metric
@@ -295,21 +481,38 @@ func EvaluateGtViolations(a *model.SLA, gt model.Guarantee, violated amodel.Guar
 				d = &m.DateTime
 			}
 		}
-		if inTransientTime(*d, lastViolation, transientTime) {
-			logs.GetLogger().Debug(pathLOG+"[EvaluateGtViolations] Skipping failed metrics %v; last=%s transient=%d newTime=%s", tuple, lastViolation, transientTime, *d)
+		last := lastViolation
+		series := ""
+		if perSeries {
+			series = tuple.Series()
+			var ok bool
+			if last, ok = lastSeriesViolation[series]; !ok {
+				last = ag.Series[series].LastViolation
+			}
+		}
+		if inTransientTime(*d, last, transientTime) {
+			logs.GetLogger().Debug(pathLOG+"[EvaluateGtViolations] Skipping failed metrics %v; last=%s transient=%d newTime=%s", tuple, last, transientTime, *d)
 			continue
 		}
 
 		// VIOLATION object
 		// with default violation leveles - Importance fields: (intervalName := "Default"), (interval := -1)
 		v := model.Violation{
+			Id:          a.Id + "-" + uuid.New(),
 			AgreementId: a.Id,
 			Guarantee:   gt.Name,
 			Datetime:    *d,
 			Constraint:  gt.Constraint,
 			Values:      values,
 			AppId:       a.Id,
+			ServiceId:   a.Name,
 			Description: "",
+			FailedTerms: failedTerms(gt.Constraint, tuple),
+			Source:      tuple.Source(),
+		}
+		if perSeries {
+			v.Labels = tuple.Labels()
+			lastSeriesViolation[series] = &v
 		}
 
 		lastViolation = &v // update last violation value
@@ -329,7 +532,7 @@ BuildRetrievalItems returns the RetrievalItems to be passed to an EarlyRetriever
 func BuildRetrievalItems(a *model.SLA, gt model.Guarantee, varnames []string, to time.Time) []monitor.RetrievalItem {
 	result := make([]monitor.RetrievalItem, 0, len(varnames))
 
-	defaultFrom := getDefaultFrom(a, gt)
+	defaultFrom := getDefaultFrom(a, gt, to)
 	for _, name := range varnames {
 		v, _ := a.Details.GetVariable(name)
 		from := getFromForVariable(v, defaultFrom, to)
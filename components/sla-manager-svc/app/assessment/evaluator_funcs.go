@@ -31,7 +31,10 @@ import (
 	"github.com/Knetic/govaluate"
 )
 
-// updateAssessment
+/*
+updateAssessment updates the assessment of the evaluated guarantees with the last values of the result. The last
+violations are recorded by checkViolationLevel, as the error budgets may discard the violations.
+*/
 func updateAssessment(a *model.SLA, result amodel.Result, now time.Time) {
 	if a.Assessment.FirstExecution.IsZero() {
 		a.Assessment.FirstExecution = now
@@ -40,19 +43,20 @@ func updateAssessment(a *model.SLA, result amodel.Result, now time.Time) {
 
 	for _, gt := range a.Details.Guarantees {
 		gtname := gt.Name
+		if result.Skipped[gtname] {
+			continue
+		}
 		last := result.LastValues[gtname]
 
-		violations := []model.Violation{}
-		if violated, ok := result.Violated[gtname]; ok {
-			violations = violated.Violations
+		updateAssessmentGuarantee(a, gtname, last, now)
+		if series := result.Series[gtname]; len(series) > 0 {
+			updateAssessmentSeries(a, gtname, series, now)
 		}
-		updateAssessmentGuarantee(a, gtname, last, violations, now)
 	}
 }
 
 // updateAssessmentGuarantee
-func updateAssessmentGuarantee(a *model.SLA, gtname string, last amodel.ExpressionData,
-	violations []model.Violation, now time.Time) {
+func updateAssessmentGuarantee(a *model.SLA, gtname string, last amodel.ExpressionData, now time.Time) {
 
 	ag := a.Assessment.GetGuarantee(gtname)
 	ag.LastExecution = now
@@ -62,22 +66,47 @@ func updateAssessmentGuarantee(a *model.SLA, gtname string, last amodel.Expressi
 	for _, v := range last {
 		ag.LastValues[v.Key] = v
 	}
-	if len(violations) > 0 {
-		ag.LastViolation = &violations[len(violations)-1]
+	a.Assessment.SetGuarantee(gtname, ag)
+}
+
+/*
+updateAssessmentSeries updates the assessment of each series of a guarantee with several series (e.g. one per instance
+of a role). The series that are not in the results anymore (e.g. instances that were stopped) are removed.
+*/
+func updateAssessmentSeries(a *model.SLA, gtname string, series map[string]amodel.ExpressionData, now time.Time) {
+
+	ag := a.Assessment.GetGuarantee(gtname)
+	res := make(map[string]model.AssessmentGuarantee, len(series))
+	for key, last := range series {
+		s, ok := ag.Series[key]
+		if !ok {
+			s = model.AssessmentGuarantee{FirstExecution: now, Labels: last.Labels()}
+		}
+		s.LastExecution = now
+		s.LastValues = model.LastValues{}
+		for _, v := range last {
+			s.LastValues[v.Key] = v
+		}
+		res[key] = s
 	}
+	ag.Series = res
 	a.Assessment.SetGuarantee(gtname, ag)
 }
 
-// getDefaultFrom
-func getDefaultFrom(a *model.SLA, gt model.Guarantee) time.Time {
-	var defaultFrom = a.Assessment.GetGuarantee(gt.Name).LastExecution
-	if defaultFrom.IsZero() {
-		defaultFrom = a.Assessment.LastExecution
+/*
+getDefaultFrom returns the start of the retrieval window of a guarantee: the last execution of the guarantee, up to a
+period of the guarantee before "to" (e.g. after a restart, or when the SLA is started again after being paused). In the
+first execution of the guarantee the window is empty ("to"), so that only the current values are retrieved.
+*/
+func getDefaultFrom(a *model.SLA, gt model.Guarantee, to time.Time) time.Time {
+	from := a.Assessment.GetGuarantee(gt.Name).LastExecution
+	if from.IsZero() {
+		return to
 	}
-	if defaultFrom.IsZero() {
-		defaultFrom = a.Creation
+	if period := gt.GetPeriod(0); period > 0 && from.Before(to.Add(-period)) {
+		from = to.Add(-period)
 	}
-	return defaultFrom
+	return from
 }
 
 // getFromForVariable returns the interval start for the query to monitoring.
@@ -132,55 +161,106 @@ func evaluateExpression(expression *govaluate.EvaluableExpression, values amodel
 }
 
 /*
-1) Primera vez que una KPI se infringe -> Level = Broken
-2) Después de X veces [seguidas] que se ha infringido -> Level = Critical
-3) Primera vez que una KPI se cumple [después de estar Broken] -> Level = Met
-4) Después de Y veces [seguidas] que se ha cumplido -> Level = Desired
-5) Sí ha cambiado de KPI met a KPI broken Z veces -> Level = Unstable
+checkViolationLevel sets the levels of the guarantee terms of a SLA after an execution.
+Levels: Broken, Critical, Burning, Met, Desired, Unstable, Unknown
+
+The level of each guarantee term is calculated by its level policy (see LevelPolicy), or by its error budget if the
+guarantee has an objective (see budgetLevel); in that case, the violations of the guarantee are removed from result
+while the budget is not exhausted nor burning too fast. The violations that remain are recorded as the last violations
+of the guarantee (that start its transient time). Guarantees without results in this execution are set to
+Unknown_NoResults. The level of the SLA is the most severe level of its guarantees.
+
+In guarantees with several series (e.g. one per instance of a role), the level of each series is calculated by the
+level policy too (see seriesLevels); the level of the guarantee is the aggregated (role-level) level: the guarantee is
+violated if any of its series is violated.
+*/
+func checkViolationLevel(qos *model.SLA, result amodel.Result) {
+	level := model.ASSESSMENT_LEVEL_NORESULTS
+
+	for _, gt := range qos.Details.Guarantees {
+		ag := qos.Assessment.GetGuarantee(gt.Name)
+		if result.Skipped[gt.Name] {
+			// not evaluated in this execution: the guarantee keeps its level
+			level = model.WorstLevel(level, ag.Level)
+			continue
+		}
+		_, violated := result.Violated[gt.Name]
+		logs.GetLogger().Debug(pathLOG+"[checkViolationLevel] Guarantee ["+gt.Name+"] violated: ", violated)
+
+		if len(result.LastValues[gt.Name]) == 0 {
+			ag.Level = model.ASSESSMENT_LEVEL_NORESULTS
+			ag.Violated = false
+			for key, s := range ag.Series {
+				s.Level = model.ASSESSMENT_LEVEL_NORESULTS
+				s.Violated = false
+				ag.Series[key] = s
+			}
+		} else {
+			seriesLevels(qos, gt, &ag, result)
+			ag.Violated = violated
+			if violated {
+				ag.TotalViolations += 1
+			}
+			if gt.Objective != nil {
+				ag.Level = budgetLevel(&ag, qos.GetObjective(gt), violated, ag.LastExecution)
+				if ag.Level != model.ASSESSMENT_LEVEL_BURNING && ag.Level != model.ASSESSMENT_LEVEL_BROKEN {
+					// bad assessments within the error budget do not raise violations
+					delete(result.Violated, gt.Name)
+				}
+			} else {
+				levels := qos.GetLevels(gt)
+				ag.Level = levelPolicy(levels.Policy).Level(&ag, levels)
+			}
+			// only the violations raised (not discarded by the error budget) start the transient time
+			setLastViolations(&ag, result.Violated[gt.Name].Violations)
+		}
+
+		qos.Assessment.SetGuarantee(gt.Name, ag)
+		level = model.WorstLevel(level, ag.Level)
+	}
+
+	qos.Assessment.Level = level
+}
 
-Levels: Broken, Critical, Met, Desired, Unstable, Unknown
+/*
+seriesLevels sets the level of each series of a guarantee with several series (see LevelPolicy). A series is violated
+if any of its values failed the constraint in this execution.
 */
-func checkViolationLevel(qos *model.SLA, totalResults int, result amodel.Result) {
-	logs.GetLogger().Debug(pathLOG+"[checkViolationLevel] totalResults: ", totalResults)
-	if totalResults == 0 {
-		qos.Assessment.Level = model.ASSESSMENT_LEVEL_NORESULTS
+func seriesLevels(qos *model.SLA, gt model.Guarantee, ag *model.AssessmentGuarantee, result amodel.Result) {
+	if len(result.Series[gt.Name]) == 0 {
 		return
 	}
 
-	/*if totalResults == 0 && ((result.LastExecution == nil) || (qos.Assessment.Level == model.ASSESSMENT_LEVEL_NORESULTS)) {
-		qos.Assessment.Level = model.ASSESSMENT_LEVEL_NORESULTS
-		return
-	}*/
+	failed := make(map[string]bool)
+	for _, tuple := range result.Violated[gt.Name].Metrics {
+		failed[tuple.Series()] = true
+	}
 
-	if qos.Assessment.Violated {
-		qos.Assessment.XCounter += 1
-		if qos.Assessment.YCounter > 0 {
-			qos.Assessment.ZCounter += 1
-			qos.Assessment.YCounter = 0
+	levels := qos.GetLevels(gt)
+	policy := levelPolicy(levels.Policy)
+	for key, s := range ag.Series {
+		s.Violated = failed[key]
+		if s.Violated {
+			s.TotalViolations += 1
 		}
-	} else {
-		qos.Assessment.YCounter += 1
-	}
-
-	if qos.Assessment.Violated && qos.Assessment.XCounter == 1 {
-		// 1) Primera vez que una KPI se infringe -> Level = Broken
-		qos.Assessment.Level = model.ASSESSMENT_LEVEL_BROKEN
-	} else if qos.Assessment.Violated && qos.Assessment.XCounter >= model.DEFAULT_ASSESSMENT_X {
-		// 2) Después de X veces [seguidas] que se ha infringido -> Level = Critical
-		qos.Assessment.Level = model.ASSESSMENT_LEVEL_CRITICAL
-	} else if !qos.Assessment.Violated && qos.Assessment.YCounter == 1 {
-		// 3) Primera vez que una KPI se cumple [después de estar Broken] -> Level = Met
-		qos.Assessment.Level = model.ASSESSMENT_LEVEL_MET
-	} else if !qos.Assessment.Violated && qos.Assessment.YCounter >= model.DEFAULT_ASSESSMENT_Y {
-		// 4) Después de Y veces [seguidas] que se ha cumplido -> Level = Desired
-		qos.Assessment.Level = model.ASSESSMENT_LEVEL_DESIRED
-	} else if qos.Assessment.Violated && qos.Assessment.ZCounter >= model.DEFAULT_ASSESSMENT_Z {
-		// 5) Sí ha cambiado de KPI met a KPI broken Z veces -> Level = Unstable
-		qos.Assessment.Level = model.ASSESSMENT_LEVEL_UNSTABLE
-	} else {
-		qos.Assessment.Level = model.ASSESSMENT_LEVEL_UNKNOWN
+		s.Level = policy.Level(&s, levels)
+		ag.Series[key] = s
 	}
+}
 
+// setLastViolations sets the last violation of a guarantee, and of each of its series, raised in an assessment
+func setLastViolations(ag *model.AssessmentGuarantee, violations []model.Violation) {
+	if len(violations) == 0 {
+		return
+	}
+	ag.LastViolation = &violations[len(violations)-1]
+	for i := range violations {
+		key := model.SeriesKey(violations[i].Labels)
+		if s, ok := ag.Series[key]; ok {
+			s.LastViolation = &violations[i]
+			ag.Series[key] = s
+		}
+	}
 }
 
 // inTransientTime returns if the new violation detected occurs in the transient time
@@ -193,26 +273,21 @@ func inTransientTime(newViolation time.Time, last *model.Violation, transientTim
 	return newViolation.Before(last.Datetime.Add(transientTime))
 }
 
-// func groupSLAsByServiceId
-func groupSLAsByServiceId(qosdefs model.SLAs, repo model.IRepository) ([]model.SLAs, error) {
+// groupSLAsByServiceId groups the SLAs by service (name), keeping the order of the list
+func groupSLAsByServiceId(qosdefs model.SLAs) []model.SLAs {
 	var ids []string
-	var res []model.SLAs
+	groups := make(map[string]model.SLAs)
 
 	for _, qosd := range qosdefs {
-		if len(ids) == 0 || !slices.Contains(ids, qosd.Name) {
+		if !slices.Contains(ids, qosd.Name) {
 			ids = append(ids, qosd.Name)
 		}
+		groups[qosd.Name] = append(groups[qosd.Name], qosd)
 	}
 
+	res := make([]model.SLAs, 0, len(ids))
 	for _, id := range ids {
-		// Retrieve all active QoS definitions
-		r, err := repo.GetSLAsByName(id)
-		if err == nil {
-			res = append(res, r)
-		} else {
-			return nil, err
-		}
+		res = append(res, groups[id])
 	}
-
-	return res, nil
+	return res
 }
@@ -0,0 +1,401 @@
+package assessment
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	amodel "colmena/sla-management-svc/app/assessment/model"
+	"colmena/sla-management-svc/app/assessment/monitor"
+	"colmena/sla-management-svc/app/model"
+	"colmena/sla-management-svc/app/repositories/memrepository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fixedAdapter is a monitoring adapter that returns the same value of every variable
+type fixedAdapter struct {
+	value float64
+	now   time.Time
+}
+
+func (ma fixedAdapter) Initialize(a *model.SLA) monitor.MonitoringAdapter { return ma }
+
+func (ma fixedAdapter) GetValues(ctx context.Context, gt model.Guarantee, vars []string, to time.Time) amodel.GuaranteeData {
+	data := amodel.ExpressionData{}
+	for _, v := range vars {
+		data[v] = model.MetricValue{Key: v, Value: ma.value, DateTime: ma.now}
+	}
+	return amodel.GuaranteeData{data}
+}
+
+func (ma fixedAdapter) Query(metric string, path string) (interface{}, error) { return nil, nil }
+
+// slowAdapter is a monitoring adapter that takes delay to return the values, and blocks until the context is done for
+// the SLAs in blocked. It records the maximum number of concurrent queries
+type slowAdapter struct {
+	fixedAdapter
+	delay   time.Duration
+	blocked map[string]bool
+	sla     string
+
+	mu      *sync.Mutex
+	running *int
+	max     *int
+}
+
+func newSlowAdapter(value float64, now time.Time, delay time.Duration, blocked ...string) slowAdapter {
+	ma := slowAdapter{
+		fixedAdapter: fixedAdapter{value: value, now: now},
+		delay:        delay,
+		blocked:      make(map[string]bool),
+		mu:           &sync.Mutex{},
+		running:      new(int),
+		max:          new(int),
+	}
+	for _, id := range blocked {
+		ma.blocked[id] = true
+	}
+	return ma
+}
+
+func (ma slowAdapter) Initialize(a *model.SLA) monitor.MonitoringAdapter {
+	ma.sla = a.Id
+	return ma
+}
+
+func (ma slowAdapter) GetValues(ctx context.Context, gt model.Guarantee, vars []string, to time.Time) amodel.GuaranteeData {
+	ma.mu.Lock()
+	*ma.running++
+	if *ma.running > *ma.max {
+		*ma.max = *ma.running
+	}
+	ma.mu.Unlock()
+	defer func() {
+		ma.mu.Lock()
+		*ma.running--
+		ma.mu.Unlock()
+	}()
+
+	if ma.blocked[ma.sla] {
+		<-ctx.Done()
+		return amodel.GuaranteeData{}
+	}
+	select {
+	case <-time.After(ma.delay):
+	case <-ctx.Done():
+		return amodel.GuaranteeData{}
+	}
+	return ma.fixedAdapter.GetValues(ctx, gt, vars, to)
+}
+
+// countingNotifier counts the notifications
+type countingNotifier struct {
+	mu         sync.Mutex
+	statuses   int
+	violations int
+	batches    int
+}
+
+func (n *countingNotifier) NotifyViolations(agreement *model.SLA, result *amodel.Result) {
+	n.violations++
+}
+
+func (n *countingNotifier) NotifyAllViolations(results []model.ColmenaOutputSLA) {
+	n.violations += len(results)
+	n.batches++
+}
+
+func (n *countingNotifier) NotifyStatus(agreement *model.SLA, result *amodel.Result) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.statuses++
+}
+
+func (n *countingNotifier) NotifyAllStatuses(results []model.OutputSLA) { n.statuses += len(results) }
+
+// conflictRepository is a repository where the SLAs are always modified by someone else
+type conflictRepository struct {
+	memrepository.MemRepository
+}
+
+func (r conflictRepository) UpdateSLA(agreement *model.SLA) (*model.SLA, error) {
+	return agreement, model.ErrConflict
+}
+
+// newTestSLA returns a started SLA with a guarantee "metric < 5"
+func newTestSLA() *model.SLA {
+	return &model.SLA{
+		Id:    "sla1",
+		Name:  "service1",
+		State: model.STARTED,
+		Details: model.Details{
+			Guarantees: []model.Guarantee{{Name: "gt1", Constraint: "metric < 5", Threshold: 5}},
+		},
+	}
+}
+
+func TestAssessSLANotifiesAfterUpdate(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name       string
+		value      float64
+		statuses   int
+		violated   bool
+		violations int
+	}{
+		{name: "fulfilled", value: 1, statuses: 1},
+		{name: "violated", value: 10, violated: true, violations: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mem, _ := memrepository.New()
+			sla := newTestSLA()
+			_, err := mem.CreateSLA(sla)
+			assert.NoError(t, err)
+
+			not := &countingNotifier{}
+			cfg := Config{Now: now, Repo: mem, Adapter: fixedAdapter{value: tc.value, now: now}, Notifier: not}
+			out := assessSLA(context.Background(), sla, cfg)
+
+			assert.Equal(t, tc.violated, out != nil)
+			assert.Equal(t, tc.statuses, not.statuses)
+			stored, _ := mem.GetViolations(sla.Id)
+			assert.Len(t, stored, tc.violations)
+		})
+	}
+}
+
+func TestAssessSLAConflictDiscardsResults(t *testing.T) {
+	now := time.Now()
+	for _, value := range []float64{1, 10} {
+		mem, _ := memrepository.New()
+		repo := conflictRepository{mem}
+		sla := newTestSLA()
+		_, err := repo.CreateSLA(sla)
+		assert.NoError(t, err)
+
+		not := &countingNotifier{}
+		cfg := Config{Now: now, Repo: repo, Adapter: fixedAdapter{value: value, now: now}, Notifier: not}
+
+		assert.Nil(t, assessSLA(context.Background(), sla, cfg))
+		assert.Zero(t, not.statuses)
+		stored, _ := mem.GetViolations(sla.Id)
+		assert.Empty(t, stored)
+	}
+}
+
+func TestGetDefaultFrom(t *testing.T) {
+	to := time.Now()
+	for _, tc := range []struct {
+		name   string
+		last   time.Time
+		period string
+		from   time.Time
+	}{
+		{name: "first execution", from: to},
+		{name: "previous execution", last: to.Add(-5 * time.Second), period: "5s", from: to.Add(-5 * time.Second)},
+		{name: "restart", last: to.Add(-time.Hour), period: "5s", from: to.Add(-5 * time.Second)},
+		{name: "no period", last: to.Add(-time.Hour), from: to.Add(-time.Hour)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sla := newTestSLA()
+			gt := sla.Details.Guarantees[0]
+			gt.Period = tc.period
+			ag := sla.Assessment.GetGuarantee(gt.Name)
+			ag.LastExecution = tc.last
+			sla.Assessment.SetGuarantee(gt.Name, ag)
+
+			assert.True(t, tc.from.Equal(getDefaultFrom(sla, gt, to)))
+		})
+	}
+}
+
+func TestBudgetDiscardedViolationsDoNotStartTransient(t *testing.T) {
+	mem, _ := memrepository.New()
+	sla := newTestSLA()
+	sla.Details.Guarantees[0].Objective = &model.Objective{Target: 0.5}
+	_, err := mem.CreateSLA(sla)
+	assert.NoError(t, err)
+
+	t0 := time.Now()
+	for i, value := range []float64{1, 1, 1, 10, 10, 10} {
+		now := t0.Add(time.Duration(i) * time.Minute)
+		cfg := Config{Now: now, Repo: mem, Adapter: fixedAdapter{value: value, now: now}, Notifier: &countingNotifier{}, Transient: time.Hour}
+		stored, _ := mem.GetSLA(sla.Id)
+		assessSLA(context.Background(), stored, cfg)
+
+		stored, _ = mem.GetSLA(sla.Id)
+		ag := stored.Assessment.GetGuarantee("gt1")
+		violations, _ := mem.GetViolations(sla.Id)
+		if i < 5 {
+			// bad assessments within the budget: no violations, and the transient time is not started
+			assert.Nil(t, ag.LastViolation, "assessment %d", i)
+			assert.Empty(t, violations, "assessment %d", i)
+		} else {
+			// budget exhausted
+			assert.Equal(t, model.ASSESSMENT_LEVEL_BROKEN, ag.Level)
+			assert.NotNil(t, ag.LastViolation)
+			assert.Len(t, violations, 1)
+		}
+	}
+}
+
+// seriesData returns the values of a series with the given labels
+func seriesData(value float64, labels map[string]string) amodel.ExpressionData {
+	return amodel.ExpressionData{"metric": model.MetricValue{Key: "metric", Value: value, Labels: labels}}
+}
+
+func TestLastSeriesValues(t *testing.T) {
+	a := map[string]string{"instance": "a"}
+	b := map[string]string{"instance": "b"}
+	assessed := model.AssessmentGuarantee{Series: map[string]model.AssessmentGuarantee{model.SeriesKey(a): {}}}
+
+	for _, tc := range []struct {
+		name     string
+		ag       model.AssessmentGuarantee
+		values   amodel.GuaranteeData
+		expected map[string]amodel.ExpressionData
+	}{
+		{name: "unlabeled", values: amodel.GuaranteeData{seriesData(1, nil), seriesData(2, nil)}},
+		{name: "single series", values: amodel.GuaranteeData{seriesData(1, a), seriesData(2, a)}},
+		{name: "unlabeled, assessed by series", ag: assessed, values: amodel.GuaranteeData{seriesData(1, nil)}},
+		{
+			name:     "single series, assessed by series",
+			ag:       assessed,
+			values:   amodel.GuaranteeData{seriesData(1, a), seriesData(2, a)},
+			expected: map[string]amodel.ExpressionData{model.SeriesKey(a): seriesData(2, a)},
+		},
+		{
+			name:   "several series",
+			values: amodel.GuaranteeData{seriesData(1, a), seriesData(2, b), seriesData(3, a)},
+			expected: map[string]amodel.ExpressionData{
+				model.SeriesKey(a): seriesData(3, a),
+				model.SeriesKey(b): seriesData(2, b),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, lastSeriesValues(tc.ag, tc.values))
+		})
+	}
+}
+
+func TestSeriesLevels(t *testing.T) {
+	a := map[string]string{"instance": "a"}
+	b := map[string]string{"instance": "b"}
+	sla := newTestSLA()
+	gt := sla.Details.Guarantees[0]
+
+	newAssessment := func() model.AssessmentGuarantee {
+		return model.AssessmentGuarantee{Series: map[string]model.AssessmentGuarantee{
+			model.SeriesKey(a): {Labels: a},
+			model.SeriesKey(b): {Labels: b},
+		}}
+	}
+	result := amodel.Result{
+		Violated: map[string]amodel.EvaluationGtResult{
+			gt.Name: {Metrics: amodel.GuaranteeData{seriesData(10, a)}},
+		},
+		Series: map[string]map[string]amodel.ExpressionData{
+			gt.Name: {model.SeriesKey(a): seriesData(10, a), model.SeriesKey(b): seriesData(1, b)},
+		},
+	}
+
+	ag := newAssessment()
+	seriesLevels(sla, gt, &ag, result)
+	sa, sb := ag.Series[model.SeriesKey(a)], ag.Series[model.SeriesKey(b)]
+	assert.True(t, sa.Violated)
+	assert.Equal(t, 1, sa.TotalViolations)
+	assert.Equal(t, model.ASSESSMENT_LEVEL_BROKEN, sa.Level)
+	assert.False(t, sb.Violated)
+	assert.Zero(t, sb.TotalViolations)
+	assert.Equal(t, model.ASSESSMENT_LEVEL_MET, sb.Level)
+
+	// no series in the result: the series are not assessed
+	ag = newAssessment()
+	seriesLevels(sla, gt, &ag, amodel.Result{})
+	assert.Equal(t, newAssessment(), ag)
+}
+
+// newTestSLAs creates n started SLAs (sla0, sla1...) of different services with a guarantee "metric < 5"
+func newTestSLAs(t *testing.T, repo model.IRepository, n int) {
+	for i := 0; i < n; i++ {
+		sla := newTestSLA()
+		sla.Id = fmt.Sprintf("sla%d", i)
+		sla.Name = fmt.Sprintf("service%d", i)
+		_, err := repo.CreateSLA(sla)
+		assert.NoError(t, err)
+	}
+}
+
+func TestAssessActiveQoSDefinitionsWorkers(t *testing.T) {
+	for _, workers := range []int{0, 1, 2, 4} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			mem, _ := memrepository.New()
+			newTestSLAs(t, mem, 6)
+
+			now := time.Now()
+			ma := newSlowAdapter(1, now, 20*time.Millisecond)
+			not := &countingNotifier{}
+			AssessActiveQoSDefinitions(Config{Now: now, Repo: mem, Adapter: ma, Notifier: not, Workers: workers})
+
+			expected := workers
+			if workers == 0 {
+				expected = 1
+			}
+			assert.Equal(t, expected, *ma.max)
+			assert.Equal(t, 6, not.statuses)
+		})
+	}
+}
+
+func TestAssessActiveQoSDefinitionsTimeout(t *testing.T) {
+	mem, _ := memrepository.New()
+	newTestSLAs(t, mem, 3)
+
+	now := time.Now()
+	ma := newSlowAdapter(10, now, time.Millisecond, "sla1")
+	not := &countingNotifier{}
+	start := time.Now()
+	AssessActiveQoSDefinitions(Config{Now: now, Repo: mem, Adapter: ma, Notifier: not, Workers: 3,
+		Timeout: 50 * time.Millisecond})
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// the results of the late SLA are discarded
+	late, _ := mem.GetSLA("sla1")
+	assert.Zero(t, late.Assessment.TotalExecutions)
+	assert.Empty(t, late.Assessment.Guarantees)
+	stored, _ := mem.GetViolations("sla1")
+	assert.Empty(t, stored)
+
+	for _, id := range []string{"sla0", "sla2"} {
+		sla, _ := mem.GetSLA(id)
+		assert.Equal(t, 1, sla.Assessment.TotalExecutions, id)
+		stored, _ := mem.GetViolations(id)
+		assert.Len(t, stored, 1, id)
+	}
+	// the violations of all the SLAs are notified in one batch
+	assert.Equal(t, 1, not.batches)
+	assert.Equal(t, 2, not.violations)
+}
+
+func TestAssessActiveQoSDefinitionsBatchedNotifications(t *testing.T) {
+	mem, _ := memrepository.New()
+	newTestSLAs(t, mem, 4)
+	fulfilled, _ := mem.GetSLA("sla3")
+	fulfilled.Details.Guarantees[0].Constraint = "metric > 5"
+	_, err := mem.UpdateSLA(fulfilled)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	not := &countingNotifier{}
+	AssessActiveQoSDefinitions(Config{Now: now, Repo: mem, Adapter: newSlowAdapter(10, now, time.Millisecond),
+		Notifier: not, Workers: 2})
+
+	// one status for the fulfilled SLA; one notification with the violations of the other SLAs
+	assert.Equal(t, 1, not.statuses)
+	assert.Equal(t, 1, not.batches)
+	assert.Equal(t, 3, not.violations)
+}
@@ -18,57 +18,53 @@ This work has been implemented within the context of COLMENA project.
 package assessment
 
 import (
+	amodel "colmena/sla-management-svc/app/assessment/model"
+	"colmena/sla-management-svc/app/common/expressions"
 	"colmena/sla-management-svc/app/common/logs"
 	"errors"
-	"strings"
+
+	"github.com/Knetic/govaluate"
 )
 
+// parseConstraint encodes the brackets inside the terms of a constraint, so that each term (metric query) is a variable
+// of the govaluate expression (see expressions.EncodeConstraint)
 func parseConstraint(constraint string) (string, error) {
-	// example: "[avg_over_time(go_goroutines[60m])] < 50000"
+	// example: "[avg_over_time(go_goroutines[60m])] < 50000" => "[avg_over_time(go_goroutines%5B60m%5D)] < 50000"
 	logs.GetLogger().Debug(pathLOG + "[parseConstraint] Checking and parsing constraint expression " + constraint + " ...")
-	logs.GetLogger().Debug(pathLOG + "[parseConstraint] Constraint expression format: [expression] '<'/'='/'>' value")
-
-	expr := strings.TrimSpace(constraint)
-	// example: "[avg_over_time(go_goroutines[60m])] < 50000"
-	logs.GetLogger().Debug(pathLOG + "[parseConstraint] expr: " + expr)
 
-	posBracketIni := strings.Index(expr, "[")
-	if posBracketIni != 0 {
-		return "", errors.New("bad constraint expression: No initial bracket found on left side. \n" +
+	exprStrFinal, err := expressions.EncodeConstraint(constraint)
+	if err != nil {
+		return "", errors.New("bad constraint expression: " + err.Error() + ". \n" +
 			"Expected Constraint expression format: '['<expression>']' '<'/'='/'>' <value> \n" +
 			"Example: [avg_over_time(go_goroutines[60m])] < 50000") // ERROR
-	} else {
-		exprsubstring1 := expr[1:]
-		// example: "avg_over_time(go_goroutines[60m])] < 50000"
-		logs.GetLogger().Debug(pathLOG + "[parseConstraint] exprsubstring1: " + exprsubstring1)
-
-		posBracketIni2 := strings.Index(exprsubstring1, "[")
-		if posBracketIni2 < 0 {
-			// example: "[avg_over_time(go_goroutines[60m])] < 50000"
-			return constraint, nil
-		} else {
-			posBracketEnd := strings.LastIndex(exprsubstring1, "]")
-			if posBracketEnd < 0 {
-				return "", errors.New("bad constraint expression: No final bracket found. \n" +
-					"Expected Constraint expression format: '['<expression>']' '<'/'='/'>' <value> \n" +
-					"Example: [avg_over_time(go_goroutines[60m])] < 50000") // ERROR
-			} else {
-
-				exprsubstring2 := exprsubstring1[0:posBracketEnd]
-				logs.GetLogger().Debug(pathLOG + "[parseConstraint] exprsubstring2: " + exprsubstring2)
-
-				exprsubstring3 := exprsubstring1[posBracketEnd+1:]
-				logs.GetLogger().Debug(pathLOG + "[parseConstraint] exprsubstring3: " + exprsubstring3)
-
-				// https://www.w3schools.com/tags/ref_urlencode.asp
-				exprsubstring2 = strings.ReplaceAll(exprsubstring2, "[", "%5B")
-				exprsubstring2 = strings.ReplaceAll(exprsubstring2, "]", "%5D")
+	}
+	logs.GetLogger().Debug(pathLOG + "[parseConstraint] exprStrFinal: " + exprStrFinal)
+	return exprStrFinal, nil
+}
 
-				exprStrFinal := "[" + exprsubstring2 + "]" + exprsubstring3
-				logs.GetLogger().Debug(pathLOG + "[parseConstraint] exprStrFinal: " + exprStrFinal)
+/*
+failedTerms returns the comparisons of a compound constraint that are not met by values (e.g. ["[avg(cpu)] > 0.9"]),
+or nil if the constraint is not compound
+*/
+func failedTerms(constraint string, values amodel.ExpressionData) []string {
+	terms, err := expressions.ConstraintTerms(constraint)
+	if err != nil || len(terms) < 2 {
+		return nil
+	}
 
-				return exprStrFinal, nil
-			}
+	res := make([]string, 0, len(terms))
+	for _, term := range terms {
+		parsed, err := parseConstraint(term)
+		if err != nil {
+			continue
+		}
+		expression, err := govaluate.NewEvaluableExpression(parsed)
+		if err != nil {
+			continue
+		}
+		if failed, err := evaluateExpression(expression, values); err == nil && failed != nil {
+			res = append(res, term)
 		}
 	}
+	return res
 }
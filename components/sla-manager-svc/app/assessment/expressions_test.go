@@ -0,0 +1,66 @@
+package assessment
+
+import (
+	"testing"
+
+	amodel "colmena/sla-management-svc/app/assessment/model"
+	"colmena/sla-management-svc/app/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailedTerms(t *testing.T) {
+	values := func(cpu, latency float64) amodel.ExpressionData {
+		return amodel.ExpressionData{
+			"avg(cpu)":                  model.MetricValue{Key: "avg(cpu)", Value: cpu},
+			"rate(latency_sum%5B1m%5D)": model.MetricValue{Key: "rate(latency_sum[1m])", Value: latency},
+		}
+	}
+
+	for _, tc := range []struct {
+		name       string
+		constraint string
+		values     amodel.ExpressionData
+		expected   []string
+	}{
+		{
+			name:       "single comparison",
+			constraint: "[avg(cpu)] < 0.9",
+			values:     values(1, 1),
+		},
+		{
+			name:       "and, one term failed",
+			constraint: "[avg(cpu)] < 0.9 && [rate(latency_sum[1m])] < 2",
+			values:     values(1, 1),
+			expected:   []string{"[avg(cpu)] < 0.9"},
+		},
+		{
+			name:       "and, all the terms failed",
+			constraint: "[avg(cpu)] < 0.9 && [rate(latency_sum[1m])] < 2",
+			values:     values(1, 3),
+			expected:   []string{"[avg(cpu)] < 0.9", "[rate(latency_sum[1m])] < 2"},
+		},
+		{
+			name:       "or",
+			constraint: "[avg(cpu)] < 0.9 || [rate(latency_sum[1m])] < 2",
+			values:     values(1, 3),
+			expected:   []string{"[avg(cpu)] < 0.9", "[rate(latency_sum[1m])] < 2"},
+		},
+		{
+			name:       "parenthesis",
+			constraint: "([avg(cpu)] < 0.9 || [avg(cpu)] > 0.95) && [rate(latency_sum[1m])] < 2",
+			values:     values(0.92, 1),
+			expected:   []string{"[avg(cpu)] < 0.9", "[avg(cpu)] > 0.95"},
+		},
+		{
+			name:       "no failed terms",
+			constraint: "[avg(cpu)] < 0.9 || [rate(latency_sum[1m])] < 2",
+			values:     values(0.5, 1),
+			expected:   []string{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, failedTerms(tc.constraint, tc.values))
+		})
+	}
+}
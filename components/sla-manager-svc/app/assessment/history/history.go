@@ -0,0 +1,119 @@
+/*
+Copyright © 2024 EVIDEN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+This work has been implemented within the context of COLMENA project.
+*/
+
+/*
+Package history keeps the time series of the assessment results of each SLA (one point per assessment cycle).
+
+The store is bounded: only the last N points of each SLA are kept.
+
+Usage:
+
+	store := history.New(1000)
+	store.Add(sla.Id, model.KPIHistoryPoint{...})
+	points := store.Get(sla.Id, "", from, to, step)
+*/
+package history
+
+import (
+	"colmena/sla-management-svc/app/model"
+	"sync"
+	"time"
+)
+
+// DefaultSize is the default maximum number of points kept per SLA
+const DefaultSize = 1000
+
+// Store is a bounded in-memory history of assessment points, safe for concurrent use
+type Store struct {
+	mu     sync.RWMutex
+	size   int
+	series map[string][]model.KPIHistoryPoint
+}
+
+// New creates a Store that keeps up to size points per SLA
+func New(size int) *Store {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Store{
+		size:   size,
+		series: make(map[string][]model.KPIHistoryPoint),
+	}
+}
+
+// Add appends a point to the history of the SLA identified by id, discarding the oldest point if the history is full
+func (s *Store) Add(id string, p model.KPIHistoryPoint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	points := append(s.series[id], p)
+	if len(points) > s.size {
+		// the discarded points are released when append reallocates the underlying array
+		points = points[len(points)-s.size:]
+	}
+	s.series[id] = points
+}
+
+// Delete removes the history of the SLA identified by id
+func (s *Store) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.series, id)
+}
+
+/*
+Get returns the points of the SLA identified by id in the range [from, to] (zero values mean no limit), sorted by time.
+If guarantee is not empty, only the points of that guarantee are returned.
+
+If step > 0, the points are downsampled: only the last point of each guarantee in each interval of length step is returned.
+*/
+func (s *Store) Get(id string, guarantee string, from time.Time, to time.Time, step time.Duration) []model.KPIHistoryPoint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]model.KPIHistoryPoint, 0)
+	type last struct {
+		bucket int64
+		index  int
+	}
+	lasts := make(map[string]last)
+	for _, p := range s.series[id] {
+		if len(guarantee) > 0 && p.Guarantee != guarantee {
+			continue
+		}
+		if !from.IsZero() && p.Timestamp.Before(from) {
+			continue
+		}
+		if !to.IsZero() && p.Timestamp.After(to) {
+			continue
+		}
+
+		if step > 0 {
+			b := p.Timestamp.UnixNano() / int64(step)
+			if l, ok := lasts[p.Guarantee]; ok && l.bucket == b {
+				// same interval: keep the last point
+				result[l.index] = p
+				continue
+			}
+			lasts[p.Guarantee] = last{bucket: b, index: len(result)}
+		}
+		result = append(result, p)
+	}
+	return result
+}
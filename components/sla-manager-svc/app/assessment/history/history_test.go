@@ -0,0 +1,79 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"colmena/sla-management-svc/app/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var t0 = time.Date(2025, 5, 21, 17, 0, 0, 0, time.UTC)
+
+// point returns a point of a guarantee at the second i, with value i
+func point(guarantee string, i int) model.KPIHistoryPoint {
+	return model.KPIHistoryPoint{Timestamp: t0.Add(time.Duration(i) * time.Second), Guarantee: guarantee, Value: i}
+}
+
+// seconds returns the values (seconds) of the points
+func seconds(points []model.KPIHistoryPoint) []int {
+	res := make([]int, 0, len(points))
+	for _, p := range points {
+		res = append(res, p.Value.(int))
+	}
+	return res
+}
+
+func TestStoreSize(t *testing.T) {
+	s := New(3)
+	for i := 0; i < 5; i++ {
+		s.Add("sla1", point("gt1", i))
+	}
+	s.Add("sla2", point("gt1", 10))
+
+	// only the last points of each SLA are kept
+	assert.Equal(t, []int{2, 3, 4}, seconds(s.Get("sla1", "", time.Time{}, time.Time{}, 0)))
+	assert.Equal(t, []int{10}, seconds(s.Get("sla2", "", time.Time{}, time.Time{}, 0)))
+
+	s.Delete("sla1")
+	assert.Empty(t, s.Get("sla1", "", time.Time{}, time.Time{}, 0))
+
+	assert.Equal(t, DefaultSize, New(0).size)
+}
+
+func TestStoreGet(t *testing.T) {
+	s := New(100)
+	for i := 0; i < 10; i++ {
+		s.Add("sla1", point("gt1", i))
+		s.Add("sla1", point("gt2", i))
+	}
+	at := func(i int) time.Time { return t0.Add(time.Duration(i) * time.Second) }
+
+	for _, tc := range []struct {
+		name      string
+		id        string
+		guarantee string
+		from      time.Time
+		to        time.Time
+		step      time.Duration
+		expected  []int
+	}{
+		{name: "guarantee", guarantee: "gt2", expected: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{name: "range (inclusive)", guarantee: "gt1", from: at(3), to: at(5), expected: []int{3, 4, 5}},
+		{name: "from", guarantee: "gt1", from: at(8), expected: []int{8, 9}},
+		{name: "to", guarantee: "gt1", to: at(1), expected: []int{0, 1}},
+		{name: "step: last point of each interval", guarantee: "gt1", step: 4 * time.Second, expected: []int{3, 7, 9}},
+		{name: "step and range", guarantee: "gt1", from: at(2), to: at(6), step: 4 * time.Second, expected: []int{3, 6}},
+		{name: "step of each guarantee", from: at(4), to: at(7), step: 4 * time.Second, expected: []int{7, 7}},
+		{name: "unknown SLA", id: "unknown", guarantee: "gt1", expected: []int{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			id := "sla1"
+			if tc.id != "" {
+				id = tc.id
+			}
+			assert.Equal(t, tc.expected, seconds(s.Get(id, tc.guarantee, tc.from, tc.to, tc.step)))
+		})
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright © 2024 EVIDEN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+This work has been implemented within the context of COLMENA project.
+*/
+package assessment
+
+import (
+	"colmena/sla-management-svc/app/common/logs"
+	"colmena/sla-management-svc/app/model"
+)
+
+/*
+LevelPolicy calculates the level (Broken, Critical, Met, Desired, Unstable, Unknown) of a guarantee term after an
+execution with results. ag.Violated contains the result of the execution; the policy can keep its state in ag.
+
+The policy of a guarantee term is selected by name in its levels ("policy" field); see GetLevelPolicy.
+*/
+type LevelPolicy interface {
+	Level(ag *model.AssessmentGuarantee, levels model.Levels) string
+}
+
+// names of the level policies
+const (
+	COUNTER_POLICY = model.LEVEL_POLICY_COUNTER
+	WINDOW_POLICY  = model.LEVEL_POLICY_WINDOW
+	EWMA_POLICY    = model.LEVEL_POLICY_EWMA
+)
+
+// levelPolicies contains the available level policies
+var levelPolicies = map[string]LevelPolicy{
+	COUNTER_POLICY: counterPolicy{},
+	WINDOW_POLICY:  windowPolicy{},
+	EWMA_POLICY:    ewmaPolicy{},
+}
+
+// GetLevelPolicy returns the level policy with the given name. An empty name returns the default policy ("counter")
+func GetLevelPolicy(name string) (LevelPolicy, bool) {
+	if len(name) == 0 {
+		name = COUNTER_POLICY
+	}
+	p, ok := levelPolicies[name]
+	return p, ok
+}
+
+// levelPolicy returns the level policy with the given name, or the default policy if the name is not valid
+func levelPolicy(name string) LevelPolicy {
+	p, ok := GetLevelPolicy(name)
+	if !ok {
+		logs.GetLogger().Warn(pathLOG + "[levelPolicy] Level policy '" + name + "' not found. Using '" + COUNTER_POLICY + "' ...")
+		return levelPolicies[COUNTER_POLICY]
+	}
+	return p
+}
+
+/*
+counterPolicy is the default policy, based on consecutive counters:
+
+1) Primera vez que una KPI se infringe -> Level = Broken
+2) Después de X veces [seguidas] que se ha infringido -> Level = Critical
+3) Primera vez que una KPI se cumple [después de estar Broken] -> Level = Met
+4) Después de Y veces [seguidas] que se ha cumplido -> Level = Desired
+5) Sí ha cambiado de KPI met a KPI broken Z veces -> Level = Unstable
+*/
+type counterPolicy struct{}
+
+func (counterPolicy) Level(ag *model.AssessmentGuarantee, levels model.Levels) string {
+	if ag.Violated {
+		ag.XCounter += 1
+		if ag.YCounter > 0 {
+			ag.ZCounter += 1
+			ag.YCounter = 0
+		}
+	} else {
+		ag.YCounter += 1
+	}
+
+	if ag.Violated && ag.XCounter == 1 {
+		// 1) Primera vez que una KPI se infringe -> Level = Broken
+		return model.ASSESSMENT_LEVEL_BROKEN
+	} else if ag.Violated && ag.XCounter >= levels.CriticalAfter {
+		// 2) Después de X veces [seguidas] que se ha infringido -> Level = Critical
+		return model.ASSESSMENT_LEVEL_CRITICAL
+	} else if !ag.Violated && ag.YCounter == 1 {
+		// 3) Primera vez que una KPI se cumple [después de estar Broken] -> Level = Met
+		return model.ASSESSMENT_LEVEL_MET
+	} else if !ag.Violated && ag.YCounter >= levels.DesiredAfter {
+		// 4) Después de Y veces [seguidas] que se ha cumplido -> Level = Desired
+		return model.ASSESSMENT_LEVEL_DESIRED
+	} else if ag.Violated && ag.ZCounter >= levels.UnstableAfter {
+		// 5) Sí ha cambiado de KPI met a KPI broken Z veces -> Level = Unstable
+		return model.ASSESSMENT_LEVEL_UNSTABLE
+	}
+	return model.ASSESSMENT_LEVEL_UNKNOWN
+}
+
+/*
+windowPolicy calculates the level from the results of the last N executions (N = levels.Window):
+
+  - violated, and more than CriticalRatio of the window violated -> Critical
+  - violated, and Z or more changes from met to broken in the window -> Unstable
+  - violated -> Broken
+  - not violated, and no violations in a full window -> Desired
+  - not violated -> Met
+*/
+type windowPolicy struct{}
+
+func (windowPolicy) Level(ag *model.AssessmentGuarantee, levels model.Levels) string {
+	ag.Window = append(ag.Window, ag.Violated)
+	if len(ag.Window) > levels.Window {
+		ag.Window = ag.Window[len(ag.Window)-levels.Window:]
+	}
+
+	violations := 0
+	changes := 0
+	for i, v := range ag.Window {
+		if v {
+			violations++
+			if i > 0 && !ag.Window[i-1] {
+				changes++
+			}
+		}
+	}
+	ratio := float64(violations) / float64(len(ag.Window))
+
+	switch {
+	case ag.Violated && ratio > levels.CriticalRatio:
+		return model.ASSESSMENT_LEVEL_CRITICAL
+	case ag.Violated && changes >= levels.UnstableAfter:
+		return model.ASSESSMENT_LEVEL_UNSTABLE
+	case ag.Violated:
+		return model.ASSESSMENT_LEVEL_BROKEN
+	case violations == 0 && len(ag.Window) == levels.Window:
+		return model.ASSESSMENT_LEVEL_DESIRED
+	}
+	return model.ASSESSMENT_LEVEL_MET
+}
+
+/*
+ewmaPolicy calculates the level from the exponentially weighted moving average of the violations
+(ewma = alpha * violated + (1 - alpha) * ewma, with violated = 1 or 0):
+
+  - violated, and ewma > CriticalRatio -> Critical
+  - violated -> Broken
+  - not violated, and ewma < 1 - CriticalRatio -> Desired
+  - not violated -> Met
+*/
+type ewmaPolicy struct{}
+
+func (ewmaPolicy) Level(ag *model.AssessmentGuarantee, levels model.Levels) string {
+	v := 0.0
+	if ag.Violated {
+		v = 1.0
+	}
+	ag.Ewma = levels.Alpha*v + (1-levels.Alpha)*ag.Ewma
+
+	switch {
+	case ag.Violated && ag.Ewma > levels.CriticalRatio:
+		return model.ASSESSMENT_LEVEL_CRITICAL
+	case ag.Violated:
+		return model.ASSESSMENT_LEVEL_BROKEN
+	case ag.Ewma < 1-levels.CriticalRatio:
+		return model.ASSESSMENT_LEVEL_DESIRED
+	}
+	return model.ASSESSMENT_LEVEL_MET
+}
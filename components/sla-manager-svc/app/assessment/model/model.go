@@ -21,12 +21,62 @@ package model
 
 import (
 	"colmena/sla-management-svc/app/model"
+	"sort"
 	"time"
 )
 
 // ExpressionData represents the set of values needed to evaluate an expression at a single time
 type ExpressionData map[string]model.MetricValue
 
+// Labels returns the labels of the series of the values (the labels of all the values; if a label has several values,
+// the value of the first variable in alphabetical order is taken), or nil if the values have no labels
+func (d ExpressionData) Labels() map[string]string {
+	names := make([]string, 0, len(d))
+	for name := range d {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var labels map[string]string
+	for _, name := range names {
+		for k, v := range d[name].Labels {
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			if _, ok := labels[k]; !ok {
+				labels[k] = v
+			}
+		}
+	}
+	return labels
+}
+
+// Series returns the key of the series of the values (see model.SeriesKey)
+func (d ExpressionData) Series() string {
+	return model.SeriesKey(d.Labels())
+}
+
+// Source returns the monitoring source that served the values, or "" if unknown
+func (d ExpressionData) Source() string {
+	for _, v := range d {
+		if len(v.Source) > 0 {
+			return v.Source
+		}
+	}
+	return ""
+}
+
+// Time returns the time of the values (the time of the newest value)
+func (d ExpressionData) Time() time.Time {
+	var t time.Time
+	for _, v := range d {
+		if v.DateTime.After(t) {
+			t = v.DateTime
+		}
+	}
+	return t
+}
+
 // GuaranteeData represents the list of values needed to evaluate an expression at several points
 // in time
 type GuaranteeData []ExpressionData
@@ -44,6 +94,12 @@ type Result struct {
 	Violated      map[string]EvaluationGtResult // terms that were violated
 	LastValues    map[string]ExpressionData     // last value of variables in the term
 	LastExecution map[string]time.Time          // last execution of a guarantee
+
+	// Series contains the last values of each series (by key) of the guarantees whose queries returned several series
+	Series map[string]map[string]ExpressionData
+
+	// Skipped contains the guarantees that were not evaluated in this execution (not due, see assessment.Scheduler)
+	Skipped map[string]bool
 }
 
 // GetViolations return the violations contained in a Result
@@ -0,0 +1,190 @@
+/*
+Copyright © 2024 EVIDEN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+This work has been implemented within the context of COLMENA project.
+*/
+
+/*
+Package federated provides a composite MonitoringAdapter that retrieves the metrics from an ordered list of sources
+(e.g. the local Prometheus of the agent and the Thanos of the control panel).
+
+The sources are queried in order: if a source is unreachable or returns no data, the next one is queried. A guarantee
+can pin a source by name (see model.Guarantee.Source); in that case, only that source is queried. The values are tagged
+with the name of the source that served them (see model.MetricValue.Source).
+
+Usage:
+
+	ma := federated.New([]federated.Source{{Name: "local", Adapter: local}, {Name: "thanos", Adapter: thanos}})
+	ma = ma.Initialize(&agreement)
+	for _, gt := range gts {
+		for values := range ma.GetValues(ctx, gt, ...) {
+			...
+		}
+	}
+*/
+package federated
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	amodel "colmena/sla-management-svc/app/assessment/model"
+	"colmena/sla-management-svc/app/assessment/monitor"
+	"colmena/sla-management-svc/app/common/logs"
+	"colmena/sla-management-svc/app/model"
+)
+
+// path used in logs
+const pathLOG string = "SLA > Assessment > Monitor > FEDERATED "
+
+const (
+	// Name is the unique identifier of this adapter
+	Name = "federated"
+
+	// SourcesPropertyName is the config property name of the ordered list of sources, separated by commas
+	// (e.g. "local,thanos"). The Prometheus client of each source is configured with the properties prefixed by
+	// SourcePrefix (e.g. PROMETHEUS_SOURCE_THANOS_ADDRESS, PROMETHEUS_SOURCE_THANOS_HEADERS)
+	SourcesPropertyName = "PROMETHEUS_SOURCES"
+)
+
+// Source is a named monitoring source
+type Source struct {
+	Name    string
+	Adapter monitor.MonitoringAdapter
+}
+
+// Adapter is a MonitoringAdapter that retrieves the metrics from an ordered list of sources, with failover
+type Adapter struct {
+	sources []Source
+}
+
+// SourcePrefix returns the prefix of the config properties of a source (e.g. "PROMETHEUS_SOURCE_THANOS_")
+func SourcePrefix(name string) string {
+	return "PROMETHEUS_SOURCE_" + strings.ToUpper(name) + "_"
+}
+
+// ParseSources returns the names of the sources of a list separated by commas (see SourcesPropertyName)
+func ParseSources(value string) []string {
+	res := []string{}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// New builds a federated adapter from an ordered list of sources
+func New(sources []Source) monitor.MonitoringAdapter {
+	return &Adapter{sources: sources}
+}
+
+// Initialize implements monitor.MonitoringAdapter.Initialize
+func (fa *Adapter) Initialize(a *model.SLA) monitor.MonitoringAdapter {
+	sources := make([]Source, 0, len(fa.sources))
+	for _, s := range fa.sources {
+		sources = append(sources, Source{Name: s.Name, Adapter: s.Adapter.Initialize(a)})
+	}
+	return &Adapter{sources: sources}
+}
+
+// WithRange implements monitor.RangeAdapter: the sources that support range retrievals retrieve the values once
+func (fa *Adapter) WithRange(to time.Time, step time.Duration) monitor.MonitoringAdapter {
+	sources := make([]Source, 0, len(fa.sources))
+	for _, s := range fa.sources {
+		if ra, ok := s.Adapter.(monitor.RangeAdapter); ok {
+			s.Adapter = ra.WithRange(to, step)
+		}
+		sources = append(sources, s)
+	}
+	return &Adapter{sources: sources}
+}
+
+/*
+GetValues implements monitor.MonitoringAdapter.GetValues. The sources are queried in order until one of them returns
+values; if the guarantee pins a source, only that source is queried.
+*/
+func (fa *Adapter) GetValues(ctx context.Context, gt model.Guarantee, vars []string, to time.Time) amodel.GuaranteeData {
+	for _, s := range fa.candidates(gt) {
+		if ctx.Err() != nil {
+			break
+		}
+		values := s.Adapter.GetValues(ctx, gt, vars, to)
+		if len(values) > 0 {
+			logs.GetLogger().Debug(pathLOG + "[GetValues] Guarantee [" + gt.Name + "] served by source [" + s.Name + "]")
+			return tagSource(values, s.Name)
+		}
+		logs.GetLogger().Warn(pathLOG + "[GetValues] No values from source [" + s.Name + "] for guarantee [" + gt.Name + "]")
+	}
+	return amodel.GuaranteeData{}
+}
+
+/*
+Query implements monitor.MonitoringAdapter.Query. The sources are queried in order until one of them returns a result
+without errors.
+*/
+func (fa *Adapter) Query(metric string, path string) (interface{}, error) {
+	err := errors.New("no monitoring sources configured")
+	for _, s := range fa.sources {
+		var res interface{}
+		res, err = s.Adapter.Query(metric, path)
+		if err == nil && res != nil {
+			return res, nil
+		}
+		logs.GetLogger().Warn(pathLOG+"[Query] No result from source ["+s.Name+"]: ", err)
+	}
+	return nil, err
+}
+
+// HasSource implements monitor.SourceAdapter. The names of the sources are case insensitive
+func (fa *Adapter) HasSource(name string) bool {
+	_, ok := fa.source(name)
+	return ok
+}
+
+// candidates returns the sources to query for a guarantee: the pinned source, or all the sources in order
+func (fa *Adapter) candidates(gt model.Guarantee) []Source {
+	if len(gt.Source) == 0 {
+		return fa.sources
+	}
+	if s, ok := fa.source(gt.Source); ok {
+		return []Source{s}
+	}
+	logs.GetLogger().Warn(pathLOG + "[candidates] Source [" + gt.Source + "] of guarantee [" + gt.Name + "] not found")
+	return nil
+}
+
+// source returns the source with the given name
+func (fa *Adapter) source(name string) (Source, bool) {
+	for _, s := range fa.sources {
+		if strings.EqualFold(s.Name, name) {
+			return s, true
+		}
+	}
+	return Source{}, false
+}
+
+// tagSource sets the source of the values
+func tagSource(data amodel.GuaranteeData, source string) amodel.GuaranteeData {
+	for _, values := range data {
+		for k, v := range values {
+			v.Source = source
+			values[k] = v
+		}
+	}
+	return data
+}
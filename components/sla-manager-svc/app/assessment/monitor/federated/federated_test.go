@@ -0,0 +1,162 @@
+package federated
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"colmena/sla-management-svc/app/assessment"
+	amodel "colmena/sla-management-svc/app/assessment/model"
+	"colmena/sla-management-svc/app/assessment/monitor"
+	"colmena/sla-management-svc/app/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAdapter is a source that returns value for every variable, or no data if it is not reachable. It counts the
+// retrievals
+type fakeAdapter struct {
+	value     float64
+	reachable bool
+	calls     *int
+}
+
+func newFakeAdapter(value float64, reachable bool) fakeAdapter {
+	return fakeAdapter{value: value, reachable: reachable, calls: new(int)}
+}
+
+func (ma fakeAdapter) Initialize(a *model.SLA) monitor.MonitoringAdapter { return ma }
+
+func (ma fakeAdapter) GetValues(ctx context.Context, gt model.Guarantee, vars []string, to time.Time) amodel.GuaranteeData {
+	*ma.calls++
+	if !ma.reachable {
+		return amodel.GuaranteeData{}
+	}
+	data := amodel.ExpressionData{}
+	for _, v := range vars {
+		data[v] = model.MetricValue{Key: v, Value: ma.value, DateTime: to}
+	}
+	return amodel.GuaranteeData{data}
+}
+
+func (ma fakeAdapter) Query(metric string, path string) (interface{}, error) {
+	if !ma.reachable {
+		return nil, errors.New("unreachable")
+	}
+	return ma.value, nil
+}
+
+// getValues returns the values of the variable "metric" of a guarantee retrieved by the adapter
+func getValues(ctx context.Context, ma monitor.MonitoringAdapter, gt model.Guarantee) amodel.GuaranteeData {
+	sla := &model.SLA{Id: "sla1", Details: model.Details{Guarantees: []model.Guarantee{gt}}}
+	return ma.Initialize(sla).GetValues(ctx, gt, []string{"metric"}, time.Now())
+}
+
+func TestGetValuesFailover(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		local  fakeAdapter
+		thanos fakeAdapter
+		source string
+		value  float64
+		calls  [2]int
+	}{
+		{name: "first source", local: newFakeAdapter(1, true), thanos: newFakeAdapter(2, true), source: "local", value: 1, calls: [2]int{1, 0}},
+		{name: "first source unreachable", local: newFakeAdapter(1, false), thanos: newFakeAdapter(2, true), source: "thanos", value: 2, calls: [2]int{1, 1}},
+		{name: "no source reachable", local: newFakeAdapter(1, false), thanos: newFakeAdapter(2, false), calls: [2]int{1, 1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ma := New([]Source{{Name: "local", Adapter: tc.local}, {Name: "thanos", Adapter: tc.thanos}})
+
+			data := getValues(context.Background(), ma, model.Guarantee{Name: "gt1"})
+			assert.Equal(t, tc.calls, [2]int{*tc.local.calls, *tc.thanos.calls})
+			if tc.source == "" {
+				assert.Empty(t, data)
+				return
+			}
+			if assert.Len(t, data, 1) {
+				assert.Equal(t, tc.value, data[0]["metric"].Value)
+				assert.Equal(t, tc.source, data[0]["metric"].Source)
+				assert.Equal(t, tc.source, data[0].Source())
+			}
+		})
+	}
+}
+
+func TestGetValuesPinnedSource(t *testing.T) {
+	local, thanos := newFakeAdapter(1, true), newFakeAdapter(2, true)
+	ma := New([]Source{{Name: "local", Adapter: local}, {Name: "thanos", Adapter: thanos}})
+
+	// only the pinned source is queried (case insensitive)
+	data := getValues(context.Background(), ma, model.Guarantee{Name: "gt1", Source: "THANOS"})
+	if assert.Len(t, data, 1) {
+		assert.Equal(t, 2.0, data[0]["metric"].Value)
+		assert.Equal(t, "thanos", data[0]["metric"].Source)
+	}
+	assert.Zero(t, *local.calls)
+
+	// no failover from a pinned source
+	unreachable := newFakeAdapter(3, false)
+	ma = New([]Source{{Name: "local", Adapter: local}, {Name: "thanos", Adapter: unreachable}})
+	assert.Empty(t, getValues(context.Background(), ma, model.Guarantee{Name: "gt1", Source: "thanos"}))
+	assert.Zero(t, *local.calls)
+
+	// unknown source
+	assert.Empty(t, getValues(context.Background(), ma, model.Guarantee{Name: "gt1", Source: "other"}))
+	assert.Zero(t, *local.calls)
+	assert.Equal(t, 1, *unreachable.calls)
+}
+
+func TestGetValuesCancelled(t *testing.T) {
+	local := newFakeAdapter(1, true)
+	ma := New([]Source{{Name: "local", Adapter: local}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Empty(t, getValues(ctx, ma, model.Guarantee{Name: "gt1"}))
+	assert.Zero(t, *local.calls)
+}
+
+func TestHasSourceAndQuery(t *testing.T) {
+	ma := New([]Source{{Name: "local", Adapter: newFakeAdapter(1, false)}, {Name: "thanos", Adapter: newFakeAdapter(2, true)}})
+
+	sa, ok := ma.(monitor.SourceAdapter)
+	if assert.True(t, ok) {
+		assert.True(t, sa.HasSource("local"))
+		assert.True(t, sa.HasSource("Thanos"))
+		assert.False(t, sa.HasSource("other"))
+	}
+
+	res, err := ma.Query("metric", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, res)
+
+	_, err = New([]Source{{Name: "local", Adapter: newFakeAdapter(1, false)}}).Query("metric", "")
+	assert.EqualError(t, err, "unreachable")
+	_, err = New(nil).Query("metric", "")
+	assert.Error(t, err)
+}
+
+func TestViolationSource(t *testing.T) {
+	now := time.Now()
+	sla := &model.SLA{
+		Id:    "sla1",
+		Name:  "service1",
+		State: model.STARTED,
+		Details: model.Details{
+			Guarantees: []model.Guarantee{{Name: "gt1", Constraint: "metric < 5", Threshold: 5}},
+		},
+	}
+	ma := New([]Source{{Name: "local", Adapter: newFakeAdapter(1, false)}, {Name: "thanos", Adapter: newFakeAdapter(10, true)}})
+
+	result, _ := assessment.AssessQoS(context.Background(), sla, assessment.Config{Now: now, Adapter: ma})
+	violations := result.GetViolations()
+	if assert.Len(t, violations, 1) {
+		assert.Equal(t, "thanos", violations[0].Source)
+		if assert.Len(t, violations[0].Values, 1) {
+			assert.Equal(t, "thanos", violations[0].Values[0].Source)
+		}
+	}
+	assert.Equal(t, "thanos", result.LastValues["gt1"]["metric"].Source)
+}
@@ -42,7 +42,7 @@ Usage:
 	ma := genericadapter.New(retriever, processor)
 	ma = ma.Initialize(&agreement)
 	for _, gt := range gts {
-		for values := range ma.GetValues(gt, ...) {
+		for values := range ma.GetValues(ctx, gt, ...) {
 			...
 		}
 	}
@@ -54,10 +54,13 @@ import (
 	"colmena/sla-management-svc/app/assessment"
 	amodel "colmena/sla-management-svc/app/assessment/model"
 	monitor "colmena/sla-management-svc/app/assessment/monitor"
-	query_prometheus "colmena/sla-management-svc/app/assessment/monitor/queries/prometheus"
 	"colmena/sla-management-svc/app/common/logs"
 	"colmena/sla-management-svc/app/model"
-	"strings"
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
 
 	"math/rand"
 	"time"
@@ -68,7 +71,9 @@ Adapter is the type of a customizable adapter.
 
 The Retrieve field is a function to query data to monitoring;
 the Process field is a function to perform additional processing
-on data.
+on data; the Querier field (optional) is the function that realizes
+the queries of the REST API (see Query); the Ranged field tells if
+the Retrieve function supports the Step of the retrieval items (see WithRange).
 
 Two Process functions are provided in the package:
 Identity (returns the input) and Aggregation (aggregates values according
@@ -78,18 +83,24 @@ type Adapter struct {
 	Type      string
 	Retrieve  Retrieve
 	Process   Process
+	Querier   Querier
+	Ranged    bool
 	agreement *model.SLA
 }
 
 // Retrieve is the type of the function that makes the actual request to monitoring.
 //
 // It receives the list of variables to be able to retrieve all of them at once if possible.
-type Retrieve func(agreement model.SLA, items []monitor.RetrievalItem) map[model.Variable][]model.MetricValue
+// The requests must be cancelled when ctx is done.
+type Retrieve func(ctx context.Context, agreement model.SLA, items []monitor.RetrievalItem) map[model.Variable][]model.MetricValue
 
 // Process is the type of the function that performs additional custom processing on
 // retrieved data.
 type Process func(v model.Variable, values []model.MetricValue) []model.MetricValue
 
+// Querier is the type of the function that realizes a query to monitoring (see monitor.MonitoringAdapter.Query)
+type Querier func(metric string, path string) (interface{}, error)
+
 // New is a helper function to build an Adapter from a Retriever and the Process function.
 func New(t string, retrieve Retrieve, process Process) monitor.MonitoringAdapter {
 	return &Adapter{
@@ -99,6 +110,27 @@ func New(t string, retrieve Retrieve, process Process) monitor.MonitoringAdapter
 	}
 }
 
+// NewWithQuerier builds an Adapter from a Retriever, the Process function and the Querier function.
+func NewWithQuerier(t string, retrieve Retrieve, process Process, querier Querier) monitor.MonitoringAdapter {
+	return &Adapter{
+		Type:     t,
+		Retrieve: retrieve,
+		Process:  process,
+		Querier:  querier,
+	}
+}
+
+// NewRanged builds an Adapter from a Retriever that supports the Step of the retrieval items (see WithRange), the Process function and the Querier function.
+func NewRanged(t string, retrieve Retrieve, process Process, querier Querier) monitor.MonitoringAdapter {
+	return &Adapter{
+		Type:     t,
+		Retrieve: retrieve,
+		Process:  process,
+		Querier:  querier,
+		Ranged:   true,
+	}
+}
+
 /*
 Initialize implements MonitoringAdapter.Initialize().
 
@@ -110,7 +142,7 @@ Usage:
 	}
 	ga := ga.Initialize(agreement)
 	for _, gt := range gts {
-		for values := range ga.GetValues(gt, ...) {
+		for values := range ga.GetValues(ctx, gt, ...) {
 			...
 		}
 	}
@@ -124,8 +156,8 @@ func (ga *Adapter) Initialize(a *model.SLA) monitor.MonitoringAdapter {
 // Query realizes a query to monitoring adapter (i.e. Prometheus) to get the metric values
 func (ga *Adapter) Query(metric string, path string) (interface{}, error) {
 	logs.GetLogger().Debug("Adapter: " + ga.Type)
-	if strings.ToLower(ga.Type) == strings.ToLower("prometheus") {
-		return query_prometheus.Query(metric, path)
+	if ga.Querier != nil {
+		return ga.Querier(metric, path)
 	}
 
 	logs.GetLogger().Warn("Query adapter not supported")
@@ -133,22 +165,108 @@ func (ga *Adapter) Query(metric string, path string) (interface{}, error) {
 }
 
 // GetValues implements Monitoring.GetValues().
-func (ga *Adapter) GetValues(gt model.Guarantee, varnames []string, now time.Time) amodel.GuaranteeData {
+func (ga *Adapter) GetValues(ctx context.Context, gt model.Guarantee, varnames []string, now time.Time) amodel.GuaranteeData {
 
 	a := ga.agreement
 
 	items := assessment.BuildRetrievalItems(a, gt, varnames, now)
-	unprocessed := ga.Retrieve(*a, items)
+	unprocessed := ga.Retrieve(ctx, *a, items)
 
 	/* process each of the series*/
 	valuesmap := map[model.Variable][]model.MetricValue{}
 	for v := range unprocessed {
 		valuesmap[v] = ga.Process(v, unprocessed[v])
 	}
-	result := Mount(valuesmap, lastvalues(a, gt), 0.1)
+
+	groups, err := splitSeries(valuesmap)
+	if err != nil {
+		logs.GetLogger().Error("[GetValues] Guarantee ["+gt.Name+"] of SLA "+a.Id+" can not be evaluated: ", err)
+		return amodel.GuaranteeData{}
+	}
+	if len(groups) <= 1 {
+		result := Mount(valuesmap, lastvalues(a, gt), 0.1)
+		return result
+	}
+
+	/* several series (e.g. one per instance of a role): each series is mounted with its own last values */
+	result := amodel.GuaranteeData{}
+	for _, group := range groups {
+		result = append(result, Mount(group, seriesLastValues(a, gt, group), 0.1)...)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Time().Before(result[j].Time())
+	})
 	return result
 }
 
+/*
+WithRange implements monitor.RangeAdapter. The values of each metric are retrieved once, until "to" and one every step;
+the following retrievals of the metric return the retrieved values in their window (From, To], or in (To - step, To] if
+the window is empty. If the Retrieve function does not support the Step of the retrieval items, the adapter is returned
+as is (the values are retrieved in each retrieval).
+*/
+func (ga *Adapter) WithRange(to time.Time, step time.Duration) monitor.MonitoringAdapter {
+	if !ga.Ranged || step <= 0 {
+		return ga
+	}
+	result := *ga
+	result.Retrieve = rangeRetrieve(ga.Retrieve, to, step)
+	return &result
+}
+
+// rangeRetrieve returns a Retrieve function that retrieves the values of each metric once (see WithRange)
+func rangeRetrieve(retrieve Retrieve, to time.Time, step time.Duration) Retrieve {
+	var mu sync.Mutex
+	cache := make(map[string][]model.MetricValue) // retrieved values, by metric
+
+	windowFrom := func(item monitor.RetrievalItem) time.Time {
+		if item.From.Before(item.To) {
+			return item.From
+		}
+		return item.To.Add(-step)
+	}
+
+	return func(ctx context.Context, agreement model.SLA, items []monitor.RetrievalItem) map[model.Variable][]model.MetricValue {
+		mu.Lock()
+		defer mu.Unlock()
+
+		missing := make([]monitor.RetrievalItem, 0)
+		for _, item := range items {
+			if _, ok := cache[item.Var.Metric]; !ok {
+				missing = append(missing, monitor.RetrievalItem{
+					Guarantee: item.Guarantee,
+					Var:       item.Var,
+					From:      windowFrom(item),
+					To:        to,
+					Step:      step,
+				})
+			}
+		}
+		if len(missing) > 0 {
+			retrieved := retrieve(ctx, agreement, missing)
+			if ctx.Err() != nil {
+				return retrieved
+			}
+			for _, item := range missing {
+				cache[item.Var.Metric] = retrieved[item.Var]
+			}
+		}
+
+		result := make(map[model.Variable][]model.MetricValue, len(items))
+		for _, item := range items {
+			from := windowFrom(item)
+			values := make([]model.MetricValue, 0)
+			for _, value := range cache[item.Var.Metric] {
+				if value.DateTime.After(from) && !value.DateTime.After(item.To) {
+					values = append(values, value)
+				}
+			}
+			result[item.Var] = values
+		}
+		return result
+	}
+}
+
 func lastvalues(a *model.SLA, gt model.Guarantee) model.LastValues {
 	empty := model.LastValues{}
 	if a.Assessment.Guarantees == nil {
@@ -164,6 +282,133 @@ func lastvalues(a *model.SLA, gt model.Guarantee) model.LastValues {
 	return ag.LastValues
 }
 
+/*
+splitSeries splits the values of the variables by series. The values of a variable with several series are split by
+series, and the values of a variable with a single series are used in all the series (e.g. "[cpu] > [limit]", with a cpu
+series per instance and a single limit). Returns a single group if no variable has several series.
+
+The series of several variables are matched on the labels that all their series share (as PromQL on(...)), e.g.
+cpu{instance="a",mode="user"} with mem{instance="a"}. A series without a match in all the variables is discarded. An
+error is returned if the variables share no label, or if several series of a variable match the same labels.
+*/
+func splitSeries(valuesmap map[model.Variable][]model.MetricValue) ([]map[model.Variable][]model.MetricValue, error) {
+	multi := make([]model.Variable, 0, len(valuesmap)) // variables with several series
+	for v, values := range valuesmap {
+		series := make(map[string]bool)
+		for _, value := range values {
+			series[model.SeriesKey(value.Labels)] = true
+		}
+		if len(series) > 1 {
+			multi = append(multi, v)
+		}
+	}
+	if len(multi) == 0 {
+		return []map[model.Variable][]model.MetricValue{valuesmap}, nil
+	}
+	sort.Slice(multi, func(i, j int) bool { return multi[i].Name < multi[j].Name })
+
+	// labels shared by all the series of the variables with several series (all the labels if there is one variable)
+	var shared []string
+	if len(multi) > 1 {
+		shared = sharedLabels(valuesmap, multi)
+		if len(shared) == 0 {
+			return nil, errors.New("the series of the variables share no label")
+		}
+	}
+	matchKey := func(labels map[string]string) string {
+		if shared == nil {
+			return model.SeriesKey(labels)
+		}
+		matched := make(map[string]string, len(shared))
+		for _, name := range shared {
+			matched[name] = labels[name]
+		}
+		return model.SeriesKey(matched)
+	}
+
+	bySeries := make(map[model.Variable]map[string][]model.MetricValue, len(multi))
+	keys := []string{}
+	for _, v := range multi {
+		series := make(map[string][]model.MetricValue)
+		full := make(map[string]string) // series (full label set) of each match key
+		for _, value := range valuesmap[v] {
+			key := matchKey(value.Labels)
+			if fk, ok := full[key]; ok && fk != model.SeriesKey(value.Labels) {
+				return nil, errors.New("several series of variable '" + v.Name + "' match the labels " + key)
+			}
+			full[key] = model.SeriesKey(value.Labels)
+			series[key] = append(series[key], value)
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+		bySeries[v] = series
+	}
+	sort.Strings(keys)
+
+	groups := make([]map[model.Variable][]model.MetricValue, 0, len(keys))
+	for _, key := range keys {
+		group := make(map[model.Variable][]model.MetricValue, len(valuesmap))
+		matched := true
+		for v, values := range valuesmap {
+			if series, ok := bySeries[v]; !ok {
+				group[v] = values
+			} else if group[v], matched = series[key]; !matched {
+				logs.GetLogger().Warn("[splitSeries] Series " + key + " not found in variable '" + v.Name + "'. Discarding series ...")
+				break
+			}
+		}
+		if matched {
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+// sharedLabels returns the names (sorted) of the labels present in all the values of the variables
+func sharedLabels(valuesmap map[model.Variable][]model.MetricValue, vars []model.Variable) []string {
+	var shared []string
+	first := true
+	for _, v := range vars {
+		for _, value := range valuesmap[v] {
+			if first {
+				for name := range value.Labels {
+					shared = append(shared, name)
+				}
+				first = false
+				continue
+			}
+			shared = slices.DeleteFunc(shared, func(name string) bool {
+				_, ok := value.Labels[name]
+				return !ok
+			})
+		}
+	}
+	sort.Strings(shared)
+	return shared
+}
+
+/*
+seriesLastValues returns a copy of the last values of the series of a group of values (see splitSeries), or empty last
+values if the series was not assessed before
+*/
+func seriesLastValues(a *model.SLA, gt model.Guarantee, group map[model.Variable][]model.MetricValue) model.LastValues {
+	first := amodel.ExpressionData{}
+	for v, values := range group {
+		if len(values) > 0 {
+			first[v.Name] = values[0]
+		}
+	}
+
+	result := model.LastValues{}
+	if s, ok := a.Assessment.GetGuarantee(gt.Name).Series[first.Series()]; ok {
+		for k, v := range s.LastValues {
+			result[k] = v
+		}
+	}
+	return result
+}
+
 // DummyRetriever is a simple struct that generates a RetrieveFunction that works similar
 // to the DummyAdapter, returning random values for each variable.
 //
@@ -178,7 +423,7 @@ type DummyRetriever struct {
 // Retrieve returns a Retrieve function.
 func (r DummyRetriever) Retrieve() Retrieve {
 
-	return func(agreement model.SLA,
+	return func(ctx context.Context, agreement model.SLA,
 		items []monitor.RetrievalItem) map[model.Variable][]model.MetricValue {
 
 		result := map[model.Variable][]model.MetricValue{}
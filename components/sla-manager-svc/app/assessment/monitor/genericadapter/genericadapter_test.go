@@ -0,0 +1,168 @@
+package genericadapter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"colmena/sla-management-svc/app/assessment"
+	"colmena/sla-management-svc/app/assessment/monitor"
+	"colmena/sla-management-svc/app/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stepRetrieve returns a Retrieve function that counts the retrievals, and returns a value every step (or the item
+// step) in the window of each item: 10 until violatedFrom, and 1 from then on
+func stepRetrieve(calls *int, step time.Duration, violatedFrom time.Time) Retrieve {
+	return func(ctx context.Context, agreement model.SLA, items []monitor.RetrievalItem) map[model.Variable][]model.MetricValue {
+		*calls++
+		res := make(map[model.Variable][]model.MetricValue)
+		for _, item := range items {
+			s := step
+			if item.Step > 0 {
+				s = item.Step
+			}
+			values := []model.MetricValue{}
+			for t := item.To; t.After(item.From) || t.Equal(item.To); t = t.Add(-s) {
+				v := 10.0
+				if !t.Before(violatedFrom) {
+					v = 1
+				}
+				values = append([]model.MetricValue{{Key: item.Var.Name, Value: v, DateTime: t}}, values...)
+			}
+			res[item.Var] = values
+		}
+		return res
+	}
+}
+
+func TestBacktestRange(t *testing.T) {
+	from := time.Date(2025, 5, 21, 17, 0, 0, 0, time.UTC)
+	to := from.Add(10 * time.Minute)
+	step := time.Minute
+	sla := model.SLA{
+		Id:   "sla1",
+		Name: "service1",
+		Details: model.Details{
+			Variables:  []model.Variable{{Name: "metric", Metric: "metric"}},
+			Guarantees: []model.Guarantee{{Name: "gt1", Constraint: "metric > 5", Threshold: 5}},
+		},
+	}
+
+	for _, ranged := range []bool{true, false} {
+		calls := 0
+		ga := &Adapter{Type: "test", Retrieve: stepRetrieve(&calls, step, from.Add(5*time.Minute)), Process: Identity, Ranged: ranged}
+
+		kpis, err := assessment.Backtest(context.Background(), assessment.Config{Adapter: ga}, []model.SLA{sla}, from, to, step)
+		assert.NoError(t, err)
+		assert.Len(t, kpis, 1)
+
+		points := kpis[0].Points
+		assert.Len(t, points, 11)
+		for i, p := range points {
+			assert.True(t, from.Add(time.Duration(i)*step).Equal(p.Timestamp))
+			assert.Equal(t, i >= 5, p.Violated, "step %d (ranged: %v)", i, ranged)
+		}
+
+		if ranged {
+			assert.Equal(t, 1, calls)
+		} else {
+			assert.Equal(t, 11, calls)
+		}
+	}
+}
+
+// seriesValue returns a value of a series of a variable
+func seriesValue(v string, value float64, labels map[string]string) model.MetricValue {
+	return model.MetricValue{Key: v, Value: value, Labels: labels}
+}
+
+func TestSplitSeries(t *testing.T) {
+	cpu := model.Variable{Name: "cpu", Metric: "cpu"}
+	mem := model.Variable{Name: "mem", Metric: "mem"}
+	limit := model.Variable{Name: "limit", Metric: "limit"}
+	a := map[string]string{"instance": "a"}
+	b := map[string]string{"instance": "b"}
+
+	for _, tc := range []struct {
+		name      string
+		valuesmap map[model.Variable][]model.MetricValue
+		expected  []map[model.Variable][]model.MetricValue
+		err       string
+	}{
+		{
+			name:      "single series",
+			valuesmap: map[model.Variable][]model.MetricValue{cpu: {seriesValue("cpu", 1, a)}, limit: {seriesValue("limit", 5, nil)}},
+			expected:  []map[model.Variable][]model.MetricValue{{cpu: {seriesValue("cpu", 1, a)}, limit: {seriesValue("limit", 5, nil)}}},
+		},
+		{
+			name:      "single series variable used in all the series",
+			valuesmap: map[model.Variable][]model.MetricValue{cpu: {seriesValue("cpu", 1, a), seriesValue("cpu", 2, b)}, limit: {seriesValue("limit", 5, nil)}},
+			expected: []map[model.Variable][]model.MetricValue{
+				{cpu: {seriesValue("cpu", 1, a)}, limit: {seriesValue("limit", 5, nil)}},
+				{cpu: {seriesValue("cpu", 2, b)}, limit: {seriesValue("limit", 5, nil)}},
+			},
+		},
+		{
+			name: "same labels",
+			valuesmap: map[model.Variable][]model.MetricValue{
+				cpu: {seriesValue("cpu", 1, a), seriesValue("cpu", 2, b)},
+				mem: {seriesValue("mem", 4, b), seriesValue("mem", 3, a)},
+			},
+			expected: []map[model.Variable][]model.MetricValue{
+				{cpu: {seriesValue("cpu", 1, a)}, mem: {seriesValue("mem", 3, a)}},
+				{cpu: {seriesValue("cpu", 2, b)}, mem: {seriesValue("mem", 4, b)}},
+			},
+		},
+		{
+			name: "matched on the shared labels",
+			valuesmap: map[model.Variable][]model.MetricValue{
+				cpu: {seriesValue("cpu", 1, map[string]string{"instance": "a", "mode": "user"}), seriesValue("cpu", 2, map[string]string{"instance": "b", "mode": "user"})},
+				mem: {seriesValue("mem", 3, map[string]string{"instance": "a", "pod": "p1"}), seriesValue("mem", 4, map[string]string{"instance": "b", "pod": "p2"})},
+			},
+			expected: []map[model.Variable][]model.MetricValue{
+				{cpu: {seriesValue("cpu", 1, map[string]string{"instance": "a", "mode": "user"})}, mem: {seriesValue("mem", 3, map[string]string{"instance": "a", "pod": "p1"})}},
+				{cpu: {seriesValue("cpu", 2, map[string]string{"instance": "b", "mode": "user"})}, mem: {seriesValue("mem", 4, map[string]string{"instance": "b", "pod": "p2"})}},
+			},
+		},
+		{
+			name: "series without match discarded",
+			valuesmap: map[model.Variable][]model.MetricValue{
+				cpu: {seriesValue("cpu", 1, a), seriesValue("cpu", 2, b)},
+				mem: {seriesValue("mem", 3, a), seriesValue("mem", 4, map[string]string{"instance": "c"})},
+			},
+			expected: []map[model.Variable][]model.MetricValue{
+				{cpu: {seriesValue("cpu", 1, a)}, mem: {seriesValue("mem", 3, a)}},
+			},
+		},
+		{
+			name: "no shared labels",
+			valuesmap: map[model.Variable][]model.MetricValue{
+				cpu: {seriesValue("cpu", 1, a), seriesValue("cpu", 2, b)},
+				mem: {seriesValue("mem", 3, map[string]string{"pod": "p1"}), seriesValue("mem", 4, map[string]string{"pod": "p2"})},
+			},
+			err: "share no label",
+		},
+		{
+			name: "several series match the same labels",
+			valuesmap: map[model.Variable][]model.MetricValue{
+				cpu: {seriesValue("cpu", 1, map[string]string{"instance": "a", "mode": "user"}), seriesValue("cpu", 2, map[string]string{"instance": "a", "mode": "system"})},
+				mem: {seriesValue("mem", 3, a), seriesValue("mem", 4, b)},
+			},
+			err: "several series of variable 'cpu'",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			groups, err := splitSeries(tc.valuesmap)
+			if tc.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.err)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, groups)
+		})
+	}
+}
@@ -43,6 +43,7 @@ import (
 	assessment_model "colmena/sla-management-svc/app/assessment/model"
 	"colmena/sla-management-svc/app/model"
 
+	"context"
 	"time"
 )
 
@@ -53,8 +54,10 @@ type MonitoringAdapter interface {
 	// A new MonitoringAdapter, copy of current adapter, must be returned
 	Initialize(a *model.SLA) MonitoringAdapter
 
-	// GetValues retrieve the metrics corresponding to the variables found in a guarantee
-	GetValues(gt model.Guarantee, vars []string, to time.Time) assessment_model.GuaranteeData
+	// GetValues retrieve the metrics corresponding to the variables found in a guarantee.
+	//
+	// The retrieval must be stopped when ctx is done (e.g. the deadline of the SLA evaluation is exceeded)
+	GetValues(ctx context.Context, gt model.Guarantee, vars []string, to time.Time) assessment_model.GuaranteeData
 
 	// Queries
 	Query(metric string, path string) (interface{}, error)
@@ -62,12 +65,33 @@ type MonitoringAdapter interface {
 
 // RetrievalItem contains the retrieval information for a variable
 //
+// Step (optional) is the resolution of the values retrieved in the window (see RangeAdapter); the retriever uses its
+// own resolution if 0.
+//
 // Used in EarlyRetriever interface
 type RetrievalItem struct {
 	Guarantee model.Guarantee
 	Var       model.Variable
 	From      time.Time
 	To        time.Time
+	Step      time.Duration
+}
+
+/*
+RangeAdapter is implemented by the adapters that can retrieve the values of a variable over a long time range with a
+single query (e.g. a Prometheus range query). It is used to replay the assessment over a past time range (backtest).
+*/
+type RangeAdapter interface {
+	// WithRange returns a copy of the adapter that retrieves the values of each variable until "to", one every step,
+	// in the first retrieval of the variable, and returns the values in the window of each retrieval from them
+	WithRange(to time.Time, step time.Duration) MonitoringAdapter
+}
+
+// SourceAdapter is implemented by the adapters with several named monitoring sources, that a guarantee can pin (see
+// model.Guarantee.Source)
+type SourceAdapter interface {
+	// HasSource returns true if the adapter has a source with the given name
+	HasSource(name string) bool
 }
 
 // EarlyRetriever is implemented by adapters that want to (and can) retrieve
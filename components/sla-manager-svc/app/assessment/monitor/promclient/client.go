@@ -0,0 +1,239 @@
+/*
+Copyright © 2024 EVIDEN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+This work has been implemented within the context of COLMENA project.
+*/
+
+/*
+Package promclient provides the Prometheus client used by the monitoring adapters and by the queries of the REST API.
+The client is built once from the configuration (address, timeout, authentication, TLS and custom headers) and reuses
+its connections.
+*/
+package promclient
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"colmena/sla-management-svc/app/common"
+	"colmena/sla-management-svc/app/common/logs"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	promconfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/spf13/viper"
+)
+
+// path used in logs
+const pathLOG string = "SLA > Assessment > Monitor > Prometheus Client "
+
+// Config property names (suffixes of the prefix, e.g. "PROMETHEUS_" + AddressProperty)
+const (
+	// AddressProperty is the URL of Prometheus (e.g. "http://localhost:9090")
+	AddressProperty = "ADDRESS"
+	// TimeoutProperty is the maximum duration of a query (e.g. "10s")
+	TimeoutProperty = "TIMEOUT"
+	// UsernameProperty and PasswordProperty are the credentials of the basic authentication
+	UsernameProperty = "USERNAME"
+	PasswordProperty = "PASSWORD"
+	// BearerTokenProperty and BearerTokenFileProperty are the token (or the file with the token) of the bearer authentication
+	BearerTokenProperty     = "BEARER_TOKEN"
+	BearerTokenFileProperty = "BEARER_TOKEN_FILE"
+	// CAFileProperty is the CA certificate used to validate the certificate of Prometheus
+	CAFileProperty = "CA_FILE"
+	// CertFileProperty and KeyFileProperty are the client certificate and key
+	CertFileProperty = "CERT_FILE"
+	KeyFileProperty  = "KEY_FILE"
+	// InsecureSkipVerifyProperty disables the validation of the certificate of Prometheus ("true")
+	InsecureSkipVerifyProperty = "INSECURE_SKIP_VERIFY"
+	// HeadersProperty are custom headers sent in each request (e.g. "X-Scope-OrgID=tenant1,X-Other=value")
+	HeadersProperty = "HEADERS"
+
+	// DefaultPrefix is the prefix of the config properties of the Prometheus client
+	DefaultPrefix = "PROMETHEUS_"
+	// DefaultAddress is the URL of Prometheus if not configured
+	DefaultAddress = "http://localhost:9090"
+	// DefaultTimeout is the maximum duration of a query if not configured
+	DefaultTimeout = 10 * time.Second
+)
+
+/*
+Config is the configuration of a Prometheus client
+*/
+type Config struct {
+	Address            string
+	Timeout            time.Duration
+	Username           string
+	Password           string
+	BearerToken        string
+	BearerTokenFile    string
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+	Headers            map[string]string
+}
+
+/*
+Client is a Prometheus client. It is safe for concurrent use, and must be reused (the connections are kept open).
+*/
+type Client struct {
+	address string
+	timeout time.Duration
+	api     v1.API
+}
+
+/*
+ConfigFromViper reads the configuration of a client from the properties (or environment variables) with a prefix,
+e.g. PROMETHEUS_ADDRESS, PROMETHEUS_TIMEOUT, PROMETHEUS_USERNAME, PROMETHEUS_HEADERS with the prefix "PROMETHEUS_"
+*/
+func ConfigFromViper(config *viper.Viper, prefix string) Config {
+	get := func(property string) string {
+		if value := os.Getenv(prefix + property); value != "" {
+			return value
+		}
+		return config.GetString(prefix + property)
+	}
+
+	c := Config{
+		Address:            get(AddressProperty),
+		Timeout:            DefaultTimeout,
+		Username:           get(UsernameProperty),
+		Password:           get(PasswordProperty),
+		BearerToken:        get(BearerTokenProperty),
+		BearerTokenFile:    get(BearerTokenFileProperty),
+		CAFile:             get(CAFileProperty),
+		CertFile:           get(CertFileProperty),
+		KeyFile:            get(KeyFileProperty),
+		InsecureSkipVerify: strings.EqualFold(get(InsecureSkipVerifyProperty), "true"),
+		Headers:            parseHeaders(get(HeadersProperty)),
+	}
+	if len(c.Address) == 0 {
+		c.Address = DefaultAddress
+	}
+	if value := get(TimeoutProperty); len(value) > 0 {
+		if d, err := common.ParseDuration(value); err == nil && d > 0 {
+			c.Timeout = d
+		} else {
+			logs.GetLogger().Warn(pathLOG + "Invalid timeout '" + value + "'. Using default value (" + DefaultTimeout.String() + ") ...")
+		}
+	}
+	return c
+}
+
+// parseHeaders parses a list of headers "name=value" separated by commas
+func parseHeaders(value string) map[string]string {
+	headers := make(map[string]string)
+	for _, h := range strings.Split(value, ",") {
+		if name, v, ok := strings.Cut(h, "="); ok && len(strings.TrimSpace(name)) > 0 {
+			headers[strings.TrimSpace(name)] = strings.TrimSpace(v)
+		}
+	}
+	return headers
+}
+
+/*
+New builds a client from a configuration
+*/
+func New(c Config) (*Client, error) {
+	if len(c.Address) == 0 {
+		return nil, errors.New("the address of Prometheus is required")
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+
+	httpConfig := promconfig.DefaultHTTPClientConfig
+	httpConfig.TLSConfig = promconfig.TLSConfig{
+		CAFile:             c.CAFile,
+		CertFile:           c.CertFile,
+		KeyFile:            c.KeyFile,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if len(c.Username) > 0 {
+		httpConfig.BasicAuth = &promconfig.BasicAuth{Username: c.Username, Password: promconfig.Secret(c.Password)}
+	}
+	if len(c.BearerToken) > 0 || len(c.BearerTokenFile) > 0 {
+		httpConfig.Authorization = &promconfig.Authorization{
+			Type:            "Bearer",
+			Credentials:     promconfig.Secret(c.BearerToken),
+			CredentialsFile: c.BearerTokenFile,
+		}
+	}
+	if len(c.Headers) > 0 {
+		httpConfig.HTTPHeaders = &promconfig.Headers{Headers: make(map[string]promconfig.Header, len(c.Headers))}
+		for name, value := range c.Headers {
+			httpConfig.HTTPHeaders.Headers[name] = promconfig.Header{Values: []string{value}}
+		}
+	}
+	if err := httpConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	rt, err := promconfig.NewRoundTripperFromConfig(httpConfig, "sla-manager")
+	if err != nil {
+		return nil, err
+	}
+	client, err := api.NewClient(api.Config{Address: c.Address, RoundTripper: rt})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{address: c.Address, timeout: c.Timeout, api: v1.NewAPI(client)}, nil
+}
+
+// Address returns the URL of Prometheus
+func (c *Client) Address() string {
+	return c.address
+}
+
+/*
+Query executes a PromQL instant query evaluated at time ts. The query is cancelled when ctx is done, or after the timeout
+of the client
+*/
+func (c *Client) Query(ctx context.Context, query string, ts time.Time) (model.Value, error) {
+	logs.GetLogger().Debug(pathLOG+"PromQL Query: ", query)
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	result, warnings, err := c.api.Query(ctx, query, ts, v1.WithTimeout(c.timeout))
+	if len(warnings) > 0 {
+		logs.GetLogger().Warn(pathLOG+"Warnings: ", warnings)
+	}
+	return result, err
+}
+
+/*
+QueryRange executes a PromQL range query evaluated every step from start to end (both included). The query is cancelled
+when ctx is done, or after the timeout of the client
+*/
+func (c *Client) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) (model.Value, error) {
+	logs.GetLogger().Debug(pathLOG+"PromQL Range Query: ", query, " [", start, ", ", end, "], step ", step)
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	r := v1.Range{Start: start, End: end, Step: step}
+	result, warnings, err := c.api.QueryRange(ctx, query, r, v1.WithTimeout(c.timeout))
+	if len(warnings) > 0 {
+		logs.GetLogger().Warn(pathLOG+"Warnings: ", warnings)
+	}
+	return result, err
+}
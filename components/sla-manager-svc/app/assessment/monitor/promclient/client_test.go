@@ -0,0 +1,165 @@
+package promclient
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const vectorResponse = `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"__name__":"up"},"value":[1700000000,"1"]}]}}`
+
+// newTestServer returns a Prometheus server that answers every query with a vector, and the last request received
+func newTestServer(t *testing.T, tls bool) (*httptest.Server, *http.Request) {
+	last := &http.Request{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		*last = *r
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, vectorResponse)
+	})
+	var srv *httptest.Server
+	if tls {
+		srv = httptest.NewTLSServer(handler)
+	} else {
+		srv = httptest.NewServer(handler)
+	}
+	t.Cleanup(srv.Close)
+	return srv, last
+}
+
+// query executes a query with a client built from c
+func query(t *testing.T, c Config) error {
+	client, err := New(c)
+	if !assert.NoError(t, err) {
+		return err
+	}
+	value, err := client.Query(context.Background(), "up", time.Now())
+	if err == nil {
+		assert.Equal(t, model.ValVector, value.Type())
+	}
+	return err
+}
+
+func TestClientAuthentication(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("file-token\n"), 0600))
+
+	for _, tc := range []struct {
+		name          string
+		config        Config
+		authorization string
+		username      string
+		password      string
+	}{
+		{name: "no authentication", config: Config{}},
+		{name: "basic", config: Config{Username: "user", Password: "secret"}, username: "user", password: "secret"},
+		{name: "bearer", config: Config{BearerToken: "token"}, authorization: "Bearer token"},
+		{name: "bearer file", config: Config{BearerTokenFile: tokenFile}, authorization: "Bearer file-token"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, last := newTestServer(t, false)
+			tc.config.Address = srv.URL
+
+			assert.NoError(t, query(t, tc.config))
+			username, password, ok := last.BasicAuth()
+			assert.Equal(t, tc.username != "", ok)
+			assert.Equal(t, tc.username, username)
+			assert.Equal(t, tc.password, password)
+			if tc.username == "" {
+				assert.Equal(t, tc.authorization, last.Header.Get("Authorization"))
+			}
+			assert.Equal(t, "up", last.Form.Get("query"))
+		})
+	}
+}
+
+func TestClientAuthenticationErrors(t *testing.T) {
+	// basic and bearer authentication at the same time
+	_, err := New(Config{Address: "http://localhost:9090", Username: "user", BearerToken: "token"})
+	assert.Error(t, err)
+
+	_, err = New(Config{})
+	assert.EqualError(t, err, "the address of Prometheus is required")
+}
+
+func TestClientHeaders(t *testing.T) {
+	srv, last := newTestServer(t, false)
+
+	assert.NoError(t, query(t, Config{Address: srv.URL, Headers: map[string]string{"X-Scope-OrgID": "tenant1", "X-Other": "value"}}))
+	assert.Equal(t, "tenant1", last.Header.Get("X-Scope-OrgID"))
+	assert.Equal(t, "value", last.Header.Get("X-Other"))
+}
+
+func TestClientTLS(t *testing.T) {
+	srv, _ := newTestServer(t, true)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	assert.NoError(t, os.WriteFile(caFile, ca, 0600))
+
+	// unknown certificate authority
+	assert.Error(t, query(t, Config{Address: srv.URL}))
+
+	assert.NoError(t, query(t, Config{Address: srv.URL, InsecureSkipVerify: true}))
+	assert.NoError(t, query(t, Config{Address: srv.URL, CAFile: caFile}))
+
+	// missing files
+	_, err := New(Config{Address: srv.URL, CAFile: filepath.Join(t.TempDir(), "none.pem")})
+	assert.Error(t, err)
+}
+
+func TestClientTimeout(t *testing.T) {
+	timeout := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		timeout <- r.Form.Get("timeout")
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	start := time.Now()
+	err := query(t, Config{Address: srv.URL, Timeout: 100 * time.Millisecond})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	// the timeout is also sent to Prometheus
+	assert.Equal(t, "100ms", <-timeout)
+}
+
+func TestConfigFromViper(t *testing.T) {
+	config := viper.New()
+	config.Set("PROMETHEUS_ADDRESS", "http://prometheus:9090")
+	config.Set("PROMETHEUS_TIMEOUT", "30s")
+	config.Set("PROMETHEUS_USERNAME", "user")
+	config.Set("PROMETHEUS_HEADERS", "X-Scope-OrgID=tenant1, X-Other = value,invalid,=empty")
+	config.Set("PROMETHEUS_INSECURE_SKIP_VERIFY", "TRUE")
+	// environment variables take precedence
+	t.Setenv("PROMETHEUS_USERNAME", "env-user")
+
+	c := ConfigFromViper(config, DefaultPrefix)
+	assert.Equal(t, "http://prometheus:9090", c.Address)
+	assert.Equal(t, 30*time.Second, c.Timeout)
+	assert.Equal(t, "env-user", c.Username)
+	assert.True(t, c.InsecureSkipVerify)
+	assert.Equal(t, map[string]string{"X-Scope-OrgID": "tenant1", "X-Other": "value"}, c.Headers)
+
+	// defaults
+	config = viper.New()
+	config.Set("PROMETHEUS_TIMEOUT", "soon")
+	c = ConfigFromViper(config, DefaultPrefix)
+	assert.Equal(t, DefaultAddress, c.Address)
+	assert.Equal(t, DefaultTimeout, c.Timeout)
+	assert.Empty(t, c.Headers)
+}
@@ -20,11 +20,17 @@ package prometheus
 import (
 	"colmena/sla-management-svc/app/assessment/monitor"
 	"colmena/sla-management-svc/app/assessment/monitor/genericadapter"
+	"colmena/sla-management-svc/app/assessment/monitor/promclient"
+	"colmena/sla-management-svc/app/common"
 	"colmena/sla-management-svc/app/common/logs"
 	"colmena/sla-management-svc/app/model"
+	"context"
 	"os"
+	"sort"
 	"strconv"
+	"time"
 
+	prommodel "github.com/prometheus/common/model"
 	"github.com/spf13/viper"
 )
 
@@ -35,42 +41,62 @@ const (
 	// Name is the unique identifier of this adapter/retriever
 	Name = "prometheus"
 
-	// PrometheusURLPropertyName is the config property name of the Prometheus URL
-	PrometheusURLPropertyName = "PROMETHEUS_ADDRESS"
+	// PrometheusURLPropertyName is the config property name of the Prometheus URL (see promclient.ConfigFromViper)
+	PrometheusURLPropertyName = promclient.DefaultPrefix + promclient.AddressProperty
 
-	// defaultURL is the value of the Prometheus URL is PrometheusURLPropertyName is not set
-	defaultURL = "http://localhost:9090"
+	// PrometheusStepPropertyName is the config property name of the step of the range queries (e.g. "15s"; "0"
+	// disables the range queries)
+	PrometheusStepPropertyName = "PROMETHEUS_STEP"
+
+	// defaultStep is the value of the step of the range queries if PrometheusStepPropertyName is not set
+	defaultStep = "15s"
+
+	// maxRangePoints is the maximum number of points retrieved by a range query; older points are not retrieved
+	maxRangePoints = 1000
 )
 
 // Retriever implements genericadapter.Retrieve
+//
+// Step is the resolution of the range queries over the window of each retrieval item (see RetrievalItem); if 0,
+// instant queries are executed at the end of the window. Client is the (shared) client used to query Prometheus.
 type Retriever struct {
-	URL string
+	URL    string
+	Step   time.Duration
+	Client *promclient.Client
 }
 
 /*
-New constructs a Prometheus adapter from a Viper configuration
+New constructs a Prometheus adapter from a Viper configuration and the Prometheus client
 */
-func New(config *viper.Viper) Retriever {
-	if os.Getenv(PrometheusURLPropertyName) != "" {
-		config.Set(PrometheusURLPropertyName, os.Getenv(PrometheusURLPropertyName))
+func New(config *viper.Viper, client *promclient.Client) Retriever {
+	if os.Getenv(PrometheusStepPropertyName) != "" {
+		config.Set(PrometheusStepPropertyName, os.Getenv(PrometheusStepPropertyName))
 	} else {
-		config.SetDefault(PrometheusURLPropertyName, defaultURL)
+		config.SetDefault(PrometheusStepPropertyName, defaultStep)
+	}
+	step, err := common.ParseDuration(config.GetString(PrometheusStepPropertyName))
+	if err != nil || step < 0 {
+		logs.GetLogger().Warn(pathLOG + "Invalid step of the range queries '" + config.GetString(PrometheusStepPropertyName) + "'. Using default value (" + defaultStep + ") ...")
+		step, _ = common.ParseDuration(defaultStep)
 	}
 
 	r := Retriever{
-		config.GetString(PrometheusURLPropertyName),
+		URL:    client.Address(),
+		Step:   step,
+		Client: client,
 	}
 
-	logConfig(config)
+	logConfig(config, r)
 
 	return r
 }
 
 // logConfig
-func logConfig(config *viper.Viper) {
+func logConfig(config *viper.Viper, r Retriever) {
 	logs.GetLogger().Info(pathLOG + "Prometheus configuration:\n" +
 		"\t-----------------------------------------------------------------\n" +
-		"\tURL (Prometheus location): " + config.GetString(PrometheusURLPropertyName) + "\n" +
+		"\tURL (Prometheus location): " + r.URL + "\n" +
+		"\tStep (range queries):      " + config.GetString(PrometheusStepPropertyName) + "\n" +
 		"\t-----------------------------------------------------------------")
 }
 
@@ -78,22 +104,34 @@ func logConfig(config *viper.Viper) {
 Retrieve implements genericadapter.Retrieve
 */
 func (r Retriever) Retrieve() genericadapter.Retrieve {
-	return func(agreement model.SLA, items []monitor.RetrievalItem) map[model.Variable][]model.MetricValue {
+	return func(ctx context.Context, agreement model.SLA, items []monitor.RetrievalItem) map[model.Variable][]model.MetricValue {
 		rootURL := r.URL
 		logs.GetLogger().Info(pathLOG + "[Retrieve] Retrieving metrics from Monitoring-PROMETHEUS adapter [" + rootURL + "] ...")
 
 		result := make(map[model.Variable][]model.MetricValue)
 		for _, item := range items {
+			if ctx.Err() != nil {
+				logs.GetLogger().Warn(pathLOG+"[Retrieve] Retrieval cancelled: ", ctx.Err())
+				break
+			}
 			logs.GetLogger().Info(pathLOG + "[Retrieve] Checking [item.Var.Metric=" + item.Var.Metric + "], [item.Var.Name=" + item.Var.Name + "] ...")
 
-			// call to monitoring engine:
+			// call to monitoring engine: range query over the window of the item, or instant query at the end of the
+			// window (the assessment time, that is the current time except in backtests)
 			q := PromQLQuery{
 				Metric: item.Var.Metric,
 				Params: map[string]string{}}
 
 			res := make([]model.MetricValue, 0, 1)
-			for _, resQuery := range PromQuery(q.String()) {
-				fv, err := strconv.ParseFloat(resQuery.Value.String(), 8)
+			// range selectors are evaluated with an instant query, except in the range retrievals (see query)
+			step := r.Step
+			if item.Step > 0 {
+				step = item.Step
+			} else if IsRangeSelector(q.String()) {
+				step = 0
+			}
+			for _, sample := range r.query(ctx, q.String(), item.From, item.To, step) {
+				fv, err := strconv.ParseFloat(sample.Value.String(), 8)
 
 				if err != nil {
 					logs.GetLogger().Error(pathLOG + "ParseFloat Error: " + err.Error())
@@ -101,7 +139,8 @@ func (r Retriever) Retrieve() genericadapter.Retrieve {
 					metric := model.MetricValue{
 						Key:      item.Var.Name,
 						Value:    fv,
-						DateTime: resQuery.Timestamp.Time(),
+						DateTime: sample.Timestamp.Time(),
+						Labels:   labels(sample.Metric),
 					}
 					res = append(res, metric)
 				}
@@ -115,3 +154,68 @@ func (r Retriever) Retrieve() genericadapter.Retrieve {
 		return result
 	}
 }
+
+/*
+query retrieves the samples of a query in the window (from, to]: a range query is evaluated every step, ending at "to"
+(e.g. from 10:00:00 to 10:01:00 with a 15s step: 10:00:15, 10:00:30, 10:00:45 and 10:01:00), so that the samples
+that arrived since the previous assessment are evaluated too. An instant query at "to" is executed when the window is
+shorter than the step, or the range queries are disabled (step 0). The samples of a range selector (e.g.
+"cpu_usage[5m]") are returned with their own timestamps (see queryRangeSelector).
+*/
+func (r Retriever) query(ctx context.Context, query string, from, to time.Time, step time.Duration) []*prommodel.Sample {
+	n := 0
+	if step > 0 && to.After(from) {
+		n = int((to.Sub(from) - 1) / step)
+	}
+	if n > maxRangePoints-1 {
+		n = maxRangePoints - 1
+	}
+
+	var res []*prommodel.Sample
+	if n == 0 {
+		res = PromQuery(ctx, r.Client, query, to)
+	} else if IsRangeSelector(query) {
+		res = r.queryRangeSelector(ctx, query, to, n, step)
+	} else {
+		start := to.Add(-time.Duration(n) * step)
+		res = PromQueryRange(ctx, r.Client, query, start, to, step)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Timestamp.Before(res[j].Timestamp)
+	})
+	return res
+}
+
+/*
+queryRangeSelector retrieves the samples of a range selector (that cannot be evaluated by a range query) with an instant
+query every step, from "to" back to n steps before "to". The samples returned by several queries are returned once.
+*/
+func (r Retriever) queryRangeSelector(ctx context.Context, query string, to time.Time, n int, step time.Duration) []*prommodel.Sample {
+	res := make([]*prommodel.Sample, 0)
+	seen := make(map[string]bool)
+	for i := 0; i <= n && ctx.Err() == nil; i++ {
+		for _, sample := range PromQuery(ctx, r.Client, query, to.Add(-time.Duration(i)*step)) {
+			key := sample.Metric.String() + "@" + sample.Timestamp.String()
+			if !seen[key] {
+				seen[key] = true
+				res = append(res, sample)
+			}
+		}
+	}
+	return res
+}
+
+// labels returns the labels of a series (without the metric name), or nil if the series has no labels
+func labels(metric prommodel.Metric) map[string]string {
+	var res map[string]string
+	for name, value := range metric {
+		if name == prommodel.MetricNameLabel {
+			continue
+		}
+		if res == nil {
+			res = make(map[string]string, len(metric))
+		}
+		res[string(name)] = string(value)
+	}
+	return res
+}
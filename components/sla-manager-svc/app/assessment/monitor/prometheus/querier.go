@@ -38,15 +38,14 @@ package prometheus
 import (
 	"context"
 	"errors"
-	"os"
 	"strings"
 	"time"
 
+	"colmena/sla-management-svc/app/assessment/monitor/promclient"
 	"colmena/sla-management-svc/app/common/logs"
 
-	"github.com/prometheus/client_golang/api"
-	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
 )
 
 type PromQLQuery struct {
@@ -68,48 +67,90 @@ func (q PromQLQuery) String() string {
 
 }
 
-func PromQuery(query string) model.Vector {
+/*
+PromQuery executes a PromQL instant query evaluated at time ts with the Prometheus client, and returns the samples of
+the result (see Samples). The query is cancelled when ctx is done, or after the timeout of the client
+*/
+func PromQuery(ctx context.Context, client *promclient.Client, query string, ts time.Time) []*model.Sample {
 
-	query = strings.ReplaceAll(query, "%5B", "[")
-	query = strings.ReplaceAll(query, "%5D", "]")
+	query = DecodeQuery(query)
 
-	// create prometheus API client
-	client, err := api.NewClient(api.Config{
-		Address: os.Getenv("PROMETHEUS_ADDRESS"),
-	})
+	result, err := client.Query(ctx, query, ts)
 	if err != nil {
-		logs.GetLogger().Error(pathLOG+"Error creating client: ", err)
+		logs.GetLogger().Error(pathLOG+"Error querying Prometheus: ", err)
+		return nil
 	}
 
-	logs.GetLogger().Debug(pathLOG+"PromQL Query: ", query)
+	res := Samples(result)
+	if len(res) == 0 {
+		logs.GetLogger().Debug(pathLOG+"PromQL Query Result length is zero: ", query)
+	}
+	return res
+}
 
-	// create prometheus API object
-	v1api := v1.NewAPI(client)
+/*
+PromQueryRange executes a PromQL range query evaluated every step from start to end (both included) with the
+Prometheus client, and returns the samples of the result (see Samples). The query is cancelled when ctx is done, or
+after the timeout of the client
+*/
+func PromQueryRange(ctx context.Context, client *promclient.Client, query string, start, end time.Time, step time.Duration) []*model.Sample {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	query = DecodeQuery(query)
 
-	result, warnings, err := v1api.Query(ctx, query, time.Now(), v1.WithTimeout(10*time.Second))
+	result, err := client.QueryRange(ctx, query, start, end, step)
 	if err != nil {
 		logs.GetLogger().Error(pathLOG+"Error querying Prometheus: ", err)
+		return nil
 	}
-	if len(warnings) > 0 {
-		logs.GetLogger().Warn(pathLOG+"Warnings: ", warnings)
+
+	res := Samples(result)
+	if len(res) == 0 {
+		logs.GetLogger().Debug(pathLOG+"PromQL Range Query Result length is zero: ", query)
 	}
+	return res
+}
 
-	// match the response to vector and print the response values
-	switch r := result.(type) {
+/*
+Samples returns the samples of the result of a query:
+  - vector (e.g. "cpu_usage"): a sample per series
+  - matrix (e.g. "cpu_usage[5m]", or any range query): a sample per point of each series, with its own timestamp
+  - scalar (e.g. "scalar(sum(cpu_usage))"): a sample without labels
+*/
+func Samples(value model.Value) []*model.Sample {
+	switch r := value.(type) {
 	case model.Vector:
+		return r
 
-		if r.Len() == 0 {
-			logs.GetLogger().Debug(pathLOG+"PromQL Query Result length is zero: ", query)
+	case model.Matrix:
+		res := make([]*model.Sample, 0, len(r))
+		for _, stream := range r {
+			for _, p := range stream.Values {
+				res = append(res, &model.Sample{Metric: stream.Metric, Value: p.Value, Timestamp: p.Timestamp})
+			}
 		}
+		return res
 
-		return r
+	case *model.Scalar:
+		return []*model.Sample{{Metric: model.Metric{}, Value: r.Value, Timestamp: r.Timestamp}}
 
 	default:
-		logs.GetLogger().Error(pathLOG + "Response is not a modelVector. Error: " + errors.New("not implemented").Error())
-
+		logs.GetLogger().Error(pathLOG + "Response is not a modelVector, modelMatrix or modelScalar. Error: " + errors.New("not implemented").Error())
 		return nil
 	}
 }
+
+// DecodeQuery decodes the brackets of a metric query (see expressions.EncodeConstraint)
+func DecodeQuery(query string) string {
+	query = strings.ReplaceAll(query, "%5B", "[")
+	query = strings.ReplaceAll(query, "%5D", "]")
+	return query
+}
+
+/*
+IsRangeSelector returns true if the result of a query is a matrix (e.g. "cpu_usage[5m]"). These queries can only be
+evaluated with instant queries
+*/
+func IsRangeSelector(query string) bool {
+	expr, err := parser.ParseExpr(DecodeQuery(query))
+	return err == nil && expr.Type() == parser.ValueTypeMatrix
+}
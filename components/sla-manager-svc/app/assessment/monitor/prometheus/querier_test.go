@@ -0,0 +1,141 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"colmena/sla-management-svc/app/assessment/monitor"
+	"colmena/sla-management-svc/app/assessment/monitor/promclient"
+	"colmena/sla-management-svc/app/model"
+
+	prommodel "github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// t0 is the time of the samples of the test server (1700000000)
+var t0 = time.Unix(1700000000, 0)
+
+// testResults are the results of the test server by query
+var testResults = map[string]string{
+	"cpu": `{"resultType":"vector","result":[
+		{"metric":{"__name__":"cpu","instance":"i1"},"value":[1700000000,"0.5"]},
+		{"metric":{"__name__":"cpu","instance":"i2"},"value":[1700000000,"0.7"]}]}`,
+	"cpu[1m]": `{"resultType":"matrix","result":[
+		{"metric":{"__name__":"cpu","instance":"i1"},"values":[[1699999940,"0.1"],[1699999970,"0.2"],[1700000000,"0.3"]]}]}`,
+	"scalar(sum(cpu))": `{"resultType":"scalar","result":[1700000000,"1.2"]}`,
+	"label_replace(vector(1), \"x\", \"y\", \"\", \"\")": `{"resultType":"string","result":[1700000000,"text"]}`,
+}
+
+// newTestRetriever returns a retriever of a Prometheus server that answers the queries of testResults
+func newTestRetriever(t *testing.T) Retriever {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		result, ok := testResults[r.Form.Get("query")]
+		if !ok {
+			result = `{"resultType":"vector","result":[]}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":`+result+`}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := promclient.New(promclient.Config{Address: srv.URL})
+	assert.NoError(t, err)
+	return Retriever{URL: srv.URL, Client: client}
+}
+
+func TestRetrieveMetricValues(t *testing.T) {
+	r := newTestRetriever(t)
+
+	for _, tc := range []struct {
+		name     string
+		metric   string
+		expected []model.MetricValue
+	}{
+		{
+			name:   "vector",
+			metric: "cpu",
+			expected: []model.MetricValue{
+				{Key: "v", Value: 0.5, DateTime: t0, Labels: map[string]string{"instance": "i1"}},
+				{Key: "v", Value: 0.7, DateTime: t0, Labels: map[string]string{"instance": "i2"}},
+			},
+		},
+		{
+			// encoded as in the constraints
+			name:   "matrix",
+			metric: "cpu%5B1m%5D",
+			expected: []model.MetricValue{
+				{Key: "v", Value: 0.1, DateTime: t0.Add(-time.Minute), Labels: map[string]string{"instance": "i1"}},
+				{Key: "v", Value: 0.2, DateTime: t0.Add(-30 * time.Second), Labels: map[string]string{"instance": "i1"}},
+				{Key: "v", Value: 0.3, DateTime: t0, Labels: map[string]string{"instance": "i1"}},
+			},
+		},
+		{
+			name:     "scalar",
+			metric:   "scalar(sum(cpu))",
+			expected: []model.MetricValue{{Key: "v", Value: 1.2, DateTime: t0}},
+		},
+		{
+			name:     "string",
+			metric:   `label_replace(vector(1), "x", "y", "", "")`,
+			expected: []model.MetricValue{},
+		},
+		{
+			name:     "no results",
+			metric:   "memory",
+			expected: []model.MetricValue{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v := model.Variable{Name: "v", Metric: tc.metric}
+			items := []monitor.RetrievalItem{{Var: v, From: t0.Add(-time.Minute), To: t0}}
+
+			result := r.Retrieve()(context.Background(), model.SLA{}, items)
+			actual := result[v]
+			if assert.Len(t, actual, len(tc.expected)) {
+				for i := range actual {
+					assert.Equal(t, tc.expected[i].Key, actual[i].Key)
+					assert.Equal(t, tc.expected[i].Value, actual[i].Value)
+					assert.True(t, tc.expected[i].DateTime.Equal(actual[i].DateTime), actual[i].DateTime)
+					assert.Equal(t, tc.expected[i].Labels, actual[i].Labels)
+				}
+			}
+		})
+	}
+}
+
+func TestSamples(t *testing.T) {
+	metric := prommodel.Metric{"__name__": "cpu", "instance": "i1"}
+	ts := prommodel.TimeFromUnix(t0.Unix())
+
+	vector := prommodel.Vector{{Metric: metric, Value: 1, Timestamp: ts}}
+	assert.Equal(t, []*prommodel.Sample(vector), Samples(vector))
+
+	matrix := prommodel.Matrix{{Metric: metric, Values: []prommodel.SamplePair{{Timestamp: ts - 1000, Value: 1}, {Timestamp: ts, Value: 2}}}}
+	assert.Equal(t, []*prommodel.Sample{
+		{Metric: metric, Value: 1, Timestamp: ts - 1000},
+		{Metric: metric, Value: 2, Timestamp: ts},
+	}, Samples(matrix))
+
+	assert.Equal(t, []*prommodel.Sample{{Metric: prommodel.Metric{}, Value: 3, Timestamp: ts}},
+		Samples(&prommodel.Scalar{Value: 3, Timestamp: ts}))
+
+	assert.Nil(t, Samples(&prommodel.String{Value: "text", Timestamp: ts}))
+}
+
+func TestIsRangeSelector(t *testing.T) {
+	for query, expected := range map[string]bool{
+		"cpu":                          false,
+		"cpu[5m]":                      true,
+		"cpu%5B5m%5D":                  true,
+		"rate(cpu[5m])":                false,
+		"max_over_time(cpu[5m])[10m:]": true,
+		"cpu[":                         false,
+	} {
+		assert.Equal(t, expected, IsRangeSelector(query), query)
+	}
+}
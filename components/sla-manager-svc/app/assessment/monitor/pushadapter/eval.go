@@ -0,0 +1,288 @@
+/*
+Copyright © 2024 EVIDEN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+This work has been implemented within the context of COLMENA project.
+*/
+package pushadapter
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"colmena/sla-management-svc/app/assessment/monitor/prometheus"
+	"colmena/sla-management-svc/app/assessment/monitor/tsbuffer"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// Sample is a value of a series at a time
+type Sample struct {
+	Labels labels.Labels
+	T      time.Time
+	V      float64
+}
+
+// rangeFunctions are the functions over a range selector (e.g. "avg_over_time(cpu_usage[5m])")
+var rangeFunctions = map[string]func(points []tsbuffer.Point, window time.Duration) float64{
+	"avg_over_time": func(points []tsbuffer.Point, _ time.Duration) float64 {
+		return sum(points) / float64(len(points))
+	},
+	"sum_over_time": func(points []tsbuffer.Point, _ time.Duration) float64 {
+		return sum(points)
+	},
+	"min_over_time": func(points []tsbuffer.Point, _ time.Duration) float64 {
+		res := math.Inf(1)
+		for _, p := range points {
+			res = math.Min(res, p.V)
+		}
+		return res
+	},
+	"max_over_time": func(points []tsbuffer.Point, _ time.Duration) float64 {
+		res := math.Inf(-1)
+		for _, p := range points {
+			res = math.Max(res, p.V)
+		}
+		return res
+	},
+	"count_over_time": func(points []tsbuffer.Point, _ time.Duration) float64 {
+		return float64(len(points))
+	},
+	"last_over_time": func(points []tsbuffer.Point, _ time.Duration) float64 {
+		return points[len(points)-1].V
+	},
+	"increase": func(points []tsbuffer.Point, _ time.Duration) float64 {
+		return increase(points)
+	},
+	"rate": func(points []tsbuffer.Point, window time.Duration) float64 {
+		return increase(points) / window.Seconds()
+	},
+}
+
+/*
+Eval evaluates a metric with the samples of the buffer in the window (from, to] of a retrieval:
+  - selector (e.g. "cpu_usage{instance="i1"}"): the samples of each series in the window, or the last sample of each
+    series (if it is not older than the lookback delta) when the series has no samples in the window
+  - range selector (e.g. "cpu_usage[5m]"): the samples of each series in the range, ending at "to"
+  - aggregation or function (e.g. "avg(avg_over_time(cpu_usage[5m]))"): the result evaluated at "to"
+*/
+func Eval(buffer *tsbuffer.Buffer, metric string, from, to time.Time) ([]Sample, error) {
+	expr, err := parser.ParseExpr(prometheus.DecodeQuery(metric))
+	if err != nil {
+		return nil, err
+	}
+
+	switch e := unwrap(expr).(type) {
+	case *parser.VectorSelector:
+		at := to.Add(-e.OriginalOffset)
+		start := from.Add(-e.OriginalOffset)
+		if lookback := at.Add(-lookbackDelta); lookback.Before(start) {
+			start = lookback
+		}
+		res := make([]Sample, 0)
+		for _, s := range buffer.Select(e.LabelMatchers, start, at) {
+			points := after(s.Points, from.Add(-e.OriginalOffset))
+			if len(points) == 0 {
+				points = s.Points[len(s.Points)-1:]
+			}
+			res = append(res, samples(s.Labels, points)...)
+		}
+		return sortSamples(res), nil
+
+	case *parser.MatrixSelector:
+		vs := e.VectorSelector.(*parser.VectorSelector)
+		at := to.Add(-vs.OriginalOffset)
+		res := make([]Sample, 0)
+		for _, s := range buffer.Select(vs.LabelMatchers, at.Add(-e.Range), at) {
+			res = append(res, samples(s.Labels, s.Points)...)
+		}
+		return sortSamples(res), nil
+
+	default:
+		return evalAt(buffer, expr, to)
+	}
+}
+
+// evalAt evaluates an expression at a time. The result has a sample per series (or group), at that time
+func evalAt(buffer *tsbuffer.Buffer, expr parser.Expr, t time.Time) ([]Sample, error) {
+	switch e := unwrap(expr).(type) {
+	case *parser.NumberLiteral:
+		return []Sample{{Labels: labels.EmptyLabels(), T: t, V: e.Val}}, nil
+
+	case *parser.VectorSelector:
+		at := t.Add(-e.OriginalOffset)
+		res := make([]Sample, 0)
+		for _, s := range buffer.Select(e.LabelMatchers, at.Add(-lookbackDelta), at) {
+			res = append(res, Sample{Labels: s.Labels, T: t, V: s.Points[len(s.Points)-1].V})
+		}
+		return res, nil
+
+	case *parser.Call:
+		f, ok := rangeFunctions[e.Func.Name]
+		if !ok || len(e.Args) != 1 {
+			return nil, errors.New("function not supported: " + e.Func.Name)
+		}
+		ms, ok := unwrap(e.Args[0]).(*parser.MatrixSelector)
+		if !ok {
+			return nil, errors.New("function " + e.Func.Name + " requires a range selector")
+		}
+		vs := ms.VectorSelector.(*parser.VectorSelector)
+		at := t.Add(-vs.OriginalOffset)
+		res := make([]Sample, 0)
+		for _, s := range buffer.Select(vs.LabelMatchers, at.Add(-ms.Range), at) {
+			res = append(res, Sample{Labels: s.Labels.DropMetricName(), T: t, V: f(s.Points, ms.Range)})
+		}
+		return res, nil
+
+	case *parser.AggregateExpr:
+		samples, err := evalAt(buffer, e.Expr, t)
+		if err != nil {
+			return nil, err
+		}
+		return aggregate(e, samples, t)
+
+	default:
+		return nil, errors.New("expression not supported: " + expr.String())
+	}
+}
+
+// aggregate aggregates the samples by the groups of an aggregation expression (e.g. "sum by (job) (...)")
+func aggregate(e *parser.AggregateExpr, samples []Sample, t time.Time) ([]Sample, error) {
+	type group struct {
+		labels labels.Labels
+		values []float64
+	}
+
+	var op func(values []float64) float64
+	switch e.Op {
+	case parser.SUM:
+		op = func(values []float64) float64 { return sumValues(values) }
+	case parser.AVG:
+		op = func(values []float64) float64 { return sumValues(values) / float64(len(values)) }
+	case parser.MIN:
+		op = func(values []float64) float64 { return fold(values, math.Min) }
+	case parser.MAX:
+		op = func(values []float64) float64 { return fold(values, math.Max) }
+	case parser.COUNT:
+		op = func(values []float64) float64 { return float64(len(values)) }
+	default:
+		return nil, errors.New("aggregation not supported: " + e.Op.String())
+	}
+
+	groups := make(map[string]*group)
+	keys := make([]string, 0)
+	for _, s := range samples {
+		var lbls labels.Labels
+		if e.Without {
+			lbls = labels.NewBuilder(s.Labels.DropMetricName()).Del(e.Grouping...).Labels()
+		} else {
+			lbls = labels.NewBuilder(s.Labels).Keep(e.Grouping...).Labels()
+		}
+		key := lbls.String()
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: lbls}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		g.values = append(g.values, s.V)
+	}
+
+	sort.Strings(keys)
+	res := make([]Sample, 0, len(keys))
+	for _, key := range keys {
+		g := groups[key]
+		res = append(res, Sample{Labels: g.labels, T: t, V: op(g.values)})
+	}
+	return res, nil
+}
+
+// unwrap returns the expression inside parentheses
+func unwrap(expr parser.Expr) parser.Expr {
+	for {
+		p, ok := expr.(*parser.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = p.Expr
+	}
+}
+
+// after returns the points after a time
+func after(points []tsbuffer.Point, t time.Time) []tsbuffer.Point {
+	i := sort.Search(len(points), func(i int) bool {
+		return points[i].T.After(t)
+	})
+	return points[i:]
+}
+
+// samples returns the samples of the points of a series
+func samples(lbls labels.Labels, points []tsbuffer.Point) []Sample {
+	res := make([]Sample, 0, len(points))
+	for _, p := range points {
+		res = append(res, Sample{Labels: lbls, T: p.T, V: p.V})
+	}
+	return res
+}
+
+// sortSamples sorts the samples by time (the samples of a time keep the order of their series)
+func sortSamples(samples []Sample) []Sample {
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].T.Before(samples[j].T)
+	})
+	return samples
+}
+
+// sum returns the sum of the values of the points
+func sum(points []tsbuffer.Point) float64 {
+	res := 0.0
+	for _, p := range points {
+		res += p.V
+	}
+	return res
+}
+
+// sumValues returns the sum of the values
+func sumValues(values []float64) float64 {
+	res := 0.0
+	for _, v := range values {
+		res += v
+	}
+	return res
+}
+
+// fold folds the values with a function (e.g. math.Min)
+func fold(values []float64, f func(a, b float64) float64) float64 {
+	res := values[0]
+	for _, v := range values[1:] {
+		res = f(res, v)
+	}
+	return res
+}
+
+// increase returns the increase of a counter in the points, taking into account the counter resets
+func increase(points []tsbuffer.Point) float64 {
+	res := 0.0
+	for i := 1; i < len(points); i++ {
+		if delta := points[i].V - points[i-1].V; delta >= 0 {
+			res += delta
+		} else {
+			res += points[i].V // counter reset
+		}
+	}
+	return res
+}
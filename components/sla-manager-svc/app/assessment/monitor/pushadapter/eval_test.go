@@ -0,0 +1,135 @@
+package pushadapter
+
+import (
+	"testing"
+	"time"
+
+	"colmena/sla-management-svc/app/assessment/monitor/tsbuffer"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+)
+
+// newCounterBuffer returns a buffer with the counter requests_total of two jobs, with a sample every 10s until now
+// (api: 0, 10, 20, 5 (reset), 15, 25, increase 45; web: 0, 1, 2, 3, 4, 5, increase 5)
+func newCounterBuffer(t *testing.T, now time.Time) *tsbuffer.Buffer {
+	b := tsbuffer.New(10, 100, time.Hour)
+	api := labels.FromStrings(labels.MetricName, "requests_total", "job", "api")
+	web := labels.FromStrings(labels.MetricName, "requests_total", "job", "web")
+	for i, v := range []float64{0, 10, 20, 5, 15, 25} {
+		ts := now.Add(time.Duration(i-5) * 10 * time.Second)
+		assert.NoError(t, b.Append(api, ts, v))
+		assert.NoError(t, b.Append(web, ts, float64(i)))
+	}
+	return b
+}
+
+func TestIncrease(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		values   []float64
+		expected float64
+	}{
+		{name: "no points", expected: 0},
+		{name: "single point", values: []float64{5}, expected: 0},
+		{name: "counter", values: []float64{0, 10, 20}, expected: 20},
+		{name: "counter reset", values: []float64{0, 10, 20, 5, 15}, expected: 35},
+		{name: "reset to zero", values: []float64{10, 0, 3}, expected: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			points := make([]tsbuffer.Point, 0, len(tc.values))
+			for _, v := range tc.values {
+				points = append(points, tsbuffer.Point{V: v})
+			}
+			assert.Equal(t, tc.expected, increase(points))
+		})
+	}
+}
+
+func TestEvalRateAndIncrease(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	b := newCounterBuffer(t, now)
+	api := labels.FromStrings("job", "api")
+	web := labels.FromStrings("job", "web")
+
+	for _, tc := range []struct {
+		metric   string
+		expected []Sample
+	}{
+		{
+			metric:   "increase(requests_total[1m])",
+			expected: []Sample{{Labels: api, T: now, V: 45}, {Labels: web, T: now, V: 5}},
+		},
+		{
+			metric:   `rate(requests_total{job="api"}[1m])`,
+			expected: []Sample{{Labels: api, T: now, V: 45.0 / 60}},
+		},
+		{
+			// the window starts after the reset
+			metric:   `increase(requests_total{job="api"}[25s])`,
+			expected: []Sample{{Labels: api, T: now, V: 20}},
+		},
+		{
+			metric:   "sum(rate(requests_total[1m]))",
+			expected: []Sample{{Labels: labels.EmptyLabels(), T: now, V: 50.0 / 60}},
+		},
+		{
+			metric:   "max by (job) (increase(requests_total[1m]))",
+			expected: []Sample{{Labels: api, T: now, V: 45}, {Labels: web, T: now, V: 5}},
+		},
+	} {
+		t.Run(tc.metric, func(t *testing.T) {
+			res, err := Eval(b, tc.metric, now.Add(-10*time.Second), now)
+			assert.NoError(t, err)
+			if assert.Len(t, res, len(tc.expected)) {
+				for i := range res {
+					assert.Equal(t, tc.expected[i].Labels, res[i].Labels)
+					assert.True(t, tc.expected[i].T.Equal(res[i].T))
+					assert.InDelta(t, tc.expected[i].V, res[i].V, 1e-9)
+				}
+			}
+		})
+	}
+}
+
+func TestEvalSelector(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	b := newCounterBuffer(t, now)
+
+	// samples in the window (from, to]
+	res, err := Eval(b, `requests_total{job="api"}`, now.Add(-20*time.Second), now)
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{15, 25}, values(res))
+
+	// no samples in the window: the last sample, if it is not older than the lookback delta
+	res, err = Eval(b, `requests_total{job="api"}`, now.Add(time.Minute), now.Add(2*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{25}, values(res))
+
+	res, err = Eval(b, `requests_total{job="api"}`, now.Add(10*time.Minute), now.Add(11*time.Minute))
+	assert.NoError(t, err)
+	assert.Empty(t, res)
+
+	// range selector: all the samples of the range
+	res, err = Eval(b, `requests_total{job="api"}[30s]`, now.Add(-10*time.Second), now)
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{5, 15, 25}, values(res))
+}
+
+func TestEvalErrors(t *testing.T) {
+	b := tsbuffer.New(10, 100, time.Hour)
+	now := time.Now()
+	for _, metric := range []string{"requests_total[", "histogram_quantile(0.9, x)", "stddev(x)", "x + 1", "increase(x)"} {
+		_, err := Eval(b, metric, now.Add(-time.Minute), now)
+		assert.Error(t, err, metric)
+	}
+}
+
+// values returns the values of the samples
+func values(samples []Sample) []float64 {
+	res := make([]float64, 0, len(samples))
+	for _, s := range samples {
+		res = append(res, s.V)
+	}
+	return res
+}
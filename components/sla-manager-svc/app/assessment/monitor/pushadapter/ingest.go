@@ -0,0 +1,132 @@
+/*
+Copyright © 2024 EVIDEN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+This work has been implemented within the context of COLMENA project.
+*/
+package pushadapter
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"colmena/sla-management-svc/app/assessment/monitor/tsbuffer"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// maxErrors is the maximum number of errors reported in an IngestResult
+const maxErrors = 10
+
+/*
+JSONSample is a sample pushed in JSON format. The labels must not include the metric name; the timestamp is optional
+(the time of the ingestion is used if it is not set).
+
+	[
+		{"name": "cpu_usage", "labels": {"instance": "i1"}, "value": 0.5, "timestamp": "2024-06-01T10:00:00Z"}
+	]
+*/
+type JSONSample struct {
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Value     float64           `json:"value"`
+	Timestamp *time.Time        `json:"timestamp,omitempty"`
+}
+
+// IngestResult is the result of an ingestion
+type IngestResult struct {
+	Accepted int            `json:"accepted"`
+	Rejected int            `json:"rejected"`
+	Errors   []string       `json:"errors,omitempty"`
+	Buffer   tsbuffer.Stats `json:"buffer"`
+}
+
+/*
+IngestRemoteWrite stores the samples of a Prometheus remote-write request (a snappy-compressed protobuf WriteRequest)
+in the buffer. Histograms and metadata are ignored.
+*/
+func IngestRemoteWrite(buffer *tsbuffer.Buffer, body []byte) (IngestResult, error) {
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return IngestResult{}, err
+	}
+	var req prompb.WriteRequest
+	if err := req.Unmarshal(data); err != nil {
+		return IngestResult{}, err
+	}
+
+	res := IngestResult{}
+	for _, ts := range req.Timeseries {
+		b := labels.NewScratchBuilder(len(ts.Labels))
+		for _, l := range ts.Labels {
+			b.Add(l.Name, l.Value)
+		}
+		b.Sort()
+		lbls := b.Labels()
+		for _, s := range ts.Samples {
+			res.add(buffer.Append(lbls, time.UnixMilli(s.Timestamp), s.Value), lbls)
+		}
+	}
+	res.Buffer = buffer.Stats()
+	return res, nil
+}
+
+/*
+IngestJSON stores the samples of a JSON request (a list of JSONSample, or a single JSONSample) in the buffer
+*/
+func IngestJSON(buffer *tsbuffer.Buffer, body []byte) (IngestResult, error) {
+	var samples []JSONSample
+	if body = bytes.TrimSpace(body); len(body) > 0 && body[0] == '{' {
+		samples = make([]JSONSample, 1)
+		if err := json.Unmarshal(body, &samples[0]); err != nil {
+			return IngestResult{}, err
+		}
+	} else if err := json.Unmarshal(body, &samples); err != nil {
+		return IngestResult{}, err
+	}
+
+	now := time.Now()
+	res := IngestResult{}
+	for _, s := range samples {
+		m := make(map[string]string, len(s.Labels)+1)
+		for name, value := range s.Labels {
+			m[name] = value
+		}
+		m[labels.MetricName] = s.Name
+		lbls := labels.FromMap(m)
+
+		t := now
+		if s.Timestamp != nil {
+			t = *s.Timestamp
+		}
+		res.add(buffer.Append(lbls, t, s.Value), lbls)
+	}
+	res.Buffer = buffer.Stats()
+	return res, nil
+}
+
+// add counts a sample as accepted or rejected
+func (r *IngestResult) add(err error, lbls labels.Labels) {
+	if err == nil {
+		r.Accepted++
+		return
+	}
+	r.Rejected++
+	if len(r.Errors) < maxErrors {
+		r.Errors = append(r.Errors, lbls.String()+": "+err.Error())
+	}
+}
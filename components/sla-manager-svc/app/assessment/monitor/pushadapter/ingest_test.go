@@ -0,0 +1,98 @@
+package pushadapter
+
+import (
+	"testing"
+	"time"
+
+	"colmena/sla-management-svc/app/assessment/monitor/tsbuffer"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+)
+
+// remoteWriteBody returns the body of a remote-write request (snappy-compressed protobuf) with the given series
+func remoteWriteBody(t *testing.T, series ...prompb.TimeSeries) []byte {
+	req := prompb.WriteRequest{Timeseries: series}
+	data, err := req.Marshal()
+	assert.NoError(t, err)
+	return snappy.Encode(nil, data)
+}
+
+func TestIngestRemoteWrite(t *testing.T) {
+	b := tsbuffer.New(10, 100, time.Hour)
+	now := time.Now().Truncate(time.Millisecond)
+
+	body := remoteWriteBody(t,
+		prompb.TimeSeries{
+			// unsorted labels
+			Labels: []prompb.Label{{Name: "instance", Value: "i1"}, {Name: "__name__", Value: "cpu_usage"}},
+			Samples: []prompb.Sample{
+				{Value: 0.5, Timestamp: now.Add(-time.Second).UnixMilli()},
+				{Value: 0.7, Timestamp: now.UnixMilli()},
+				{Value: 0.6, Timestamp: now.Add(-2 * time.Second).UnixMilli()}, // out of order
+			},
+		},
+		prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "instance", Value: "i1"}}, // no metric name
+			Samples: []prompb.Sample{{Value: 1, Timestamp: now.UnixMilli()}},
+		},
+	)
+	res, err := IngestRemoteWrite(b, body)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.Accepted)
+	assert.Equal(t, 2, res.Rejected)
+	assert.Len(t, res.Errors, 2)
+	assert.Equal(t, tsbuffer.Stats{Series: 1, Samples: 2}, res.Buffer)
+
+	series := b.Select([]*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "instance", "i1")}, now.Add(-time.Minute), now)
+	if assert.Len(t, series, 1) {
+		assert.Equal(t, labels.FromStrings("__name__", "cpu_usage", "instance", "i1"), series[0].Labels)
+		assert.Equal(t, []tsbuffer.Point{{T: now.Add(-time.Second), V: 0.5}, {T: now, V: 0.7}}, series[0].Points)
+	}
+}
+
+func TestIngestRemoteWriteInvalid(t *testing.T) {
+	b := tsbuffer.New(10, 100, time.Hour)
+
+	_, err := IngestRemoteWrite(b, []byte("not snappy"))
+	assert.Error(t, err)
+
+	_, err = IngestRemoteWrite(b, snappy.Encode(nil, []byte("not protobuf")))
+	assert.Error(t, err)
+}
+
+func TestIngestJSON(t *testing.T) {
+	b := tsbuffer.New(10, 100, time.Hour)
+	now := time.Now().UTC().Truncate(time.Second)
+	ts := now.Add(-time.Minute).Format(time.RFC3339)
+
+	// list of samples
+	res, err := IngestJSON(b, []byte(`[
+		{"name": "cpu_usage", "labels": {"instance": "i1"}, "value": 0.5, "timestamp": "`+ts+`"},
+		{"name": "cpu_usage", "labels": {"instance": "i2"}, "value": 0.7},
+		{"labels": {"instance": "i3"}, "value": 1}
+	]`))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.Accepted)
+	assert.Equal(t, 1, res.Rejected)
+	assert.Equal(t, tsbuffer.Stats{Series: 2, Samples: 2}, res.Buffer)
+
+	// single sample
+	res, err = IngestJSON(b, []byte(` {"name": "mem_usage", "value": 100}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Accepted)
+
+	cpu := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "cpu_usage")
+	series := b.Select([]*labels.Matcher{cpu}, now.Add(-time.Hour), time.Now())
+	if assert.Len(t, series, 2) {
+		assert.Equal(t, labels.FromStrings("__name__", "cpu_usage", "instance", "i1"), series[0].Labels)
+		assert.True(t, now.Add(-time.Minute).Equal(series[0].Points[0].T))
+		// no timestamp: time of the ingestion
+		assert.False(t, series[1].Points[0].T.Before(now))
+	}
+
+	_, err = IngestJSON(b, []byte(`{"name": "cpu_usage", "value": "high"}`))
+	assert.Error(t, err)
+}
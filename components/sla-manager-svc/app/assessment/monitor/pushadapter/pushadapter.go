@@ -0,0 +1,219 @@
+/*
+Copyright © 2024 EVIDEN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+This work has been implemented within the context of COLMENA project.
+*/
+
+/*
+Package pushadapter provides a monitoring adapter that retrieves the metrics from the samples pushed to the SLA manager
+(Prometheus remote-write or JSON, see Ingest), which are stored in a bounded in-memory buffer (see tsbuffer). It lets
+the KPIs be assessed without a Prometheus server on the device.
+
+The metric of a KPI is a subset of PromQL (see Eval):
+  - selectors: cpu_usage, cpu_usage{instance="i1", job=~"edge.*"}, cpu_usage[5m]
+  - aggregations: sum, avg, min, max, count (with "by" and "without")
+  - functions over a range: avg_over_time, sum_over_time, min_over_time, max_over_time, count_over_time,
+    last_over_time, rate, increase
+
+Usage:
+
+	buffer := pushadapter.NewBuffer(config)
+	retriever := pushadapter.New(buffer)
+	adapter := genericadapter.NewWithQuerier(pushadapter.Name, retriever.Retrieve(), genericadapter.Identity, pushadapter.NewQuerier(buffer))
+*/
+package pushadapter
+
+import (
+	"colmena/sla-management-svc/app/assessment/monitor"
+	"colmena/sla-management-svc/app/assessment/monitor/genericadapter"
+	"colmena/sla-management-svc/app/assessment/monitor/tsbuffer"
+	"colmena/sla-management-svc/app/common"
+	"colmena/sla-management-svc/app/common/logs"
+	"colmena/sla-management-svc/app/model"
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/spf13/viper"
+)
+
+// path used in logs
+const pathLOG string = "SLA > Assessment > Monitor > PUSH "
+
+const (
+	// Name is the unique identifier of this adapter/retriever
+	Name = "push"
+
+	// MaxSeriesPropertyName is the config property name of the maximum number of series of the buffer
+	MaxSeriesPropertyName = "PUSH_MAX_SERIES"
+
+	// MaxSamplesPropertyName is the config property name of the maximum number of samples of each series
+	MaxSamplesPropertyName = "PUSH_MAX_SAMPLES"
+
+	// RetentionPropertyName is the config property name of the retention of the samples (e.g. "1h")
+	RetentionPropertyName = "PUSH_RETENTION"
+
+	// default values of the buffer
+	defaultMaxSeries  = 10000
+	defaultMaxSamples = 1000
+	defaultRetention  = "1h"
+
+	// lookbackDelta is the maximum age of the last sample of a series evaluated at a time (as in Prometheus)
+	lookbackDelta = 5 * time.Minute
+)
+
+// Retriever implements genericadapter.Retrieve with the samples of a buffer
+type Retriever struct {
+	Buffer *tsbuffer.Buffer
+}
+
+/*
+NewBuffer constructs the buffer of the pushed samples from a Viper configuration
+*/
+func NewBuffer(config *viper.Viper) *tsbuffer.Buffer {
+	setDefault(config, MaxSeriesPropertyName, strconv.Itoa(defaultMaxSeries))
+	setDefault(config, MaxSamplesPropertyName, strconv.Itoa(defaultMaxSamples))
+	setDefault(config, RetentionPropertyName, defaultRetention)
+
+	maxSeries := config.GetInt(MaxSeriesPropertyName)
+	if maxSeries <= 0 {
+		logs.GetLogger().Warn(pathLOG + "Invalid maximum number of series '" + config.GetString(MaxSeriesPropertyName) + "'. Using default value ...")
+		maxSeries = defaultMaxSeries
+	}
+	maxSamples := config.GetInt(MaxSamplesPropertyName)
+	if maxSamples <= 0 {
+		logs.GetLogger().Warn(pathLOG + "Invalid maximum number of samples '" + config.GetString(MaxSamplesPropertyName) + "'. Using default value ...")
+		maxSamples = defaultMaxSamples
+	}
+	retention, err := common.ParseDuration(config.GetString(RetentionPropertyName))
+	if err != nil || retention <= 0 {
+		logs.GetLogger().Warn(pathLOG + "Invalid retention '" + config.GetString(RetentionPropertyName) + "'. Using default value (" + defaultRetention + ") ...")
+		retention, _ = common.ParseDuration(defaultRetention)
+	}
+
+	logs.GetLogger().Info(pathLOG + "Push buffer configuration:\n" +
+		"\t-----------------------------------------------------------------\n" +
+		"\tMax series:            " + strconv.Itoa(maxSeries) + "\n" +
+		"\tMax samples (series):  " + strconv.Itoa(maxSamples) + "\n" +
+		"\tRetention:             " + retention.String() + "\n" +
+		"\t-----------------------------------------------------------------")
+
+	return tsbuffer.New(maxSeries, maxSamples, retention)
+}
+
+// setDefault sets the value of a property from the environment, or its default value
+func setDefault(config *viper.Viper, name string, value string) {
+	if os.Getenv(name) != "" {
+		config.Set(name, os.Getenv(name))
+	} else {
+		config.SetDefault(name, value)
+	}
+}
+
+/*
+New constructs a push adapter from the buffer of the pushed samples
+*/
+func New(buffer *tsbuffer.Buffer) Retriever {
+	return Retriever{Buffer: buffer}
+}
+
+/*
+Retrieve implements genericadapter.Retrieve
+*/
+func (r Retriever) Retrieve() genericadapter.Retrieve {
+	return func(ctx context.Context, agreement model.SLA, items []monitor.RetrievalItem) map[model.Variable][]model.MetricValue {
+		logs.GetLogger().Info(pathLOG + "[Retrieve] Retrieving metrics from Monitoring-PUSH adapter ...")
+
+		result := make(map[model.Variable][]model.MetricValue)
+		for _, item := range items {
+			if ctx.Err() != nil {
+				logs.GetLogger().Warn(pathLOG+"[Retrieve] Retrieval cancelled: ", ctx.Err())
+				break
+			}
+			logs.GetLogger().Info(pathLOG + "[Retrieve] Checking [item.Var.Metric=" + item.Var.Metric + "], [item.Var.Name=" + item.Var.Name + "] ...")
+
+			samples, err := Eval(r.Buffer, item.Var.Metric, item.From, item.To)
+			if err != nil {
+				logs.GetLogger().Error(pathLOG + "[Retrieve] Error evaluating [" + item.Var.Metric + "]: " + err.Error())
+			}
+
+			res := make([]model.MetricValue, 0, len(samples))
+			for _, sample := range samples {
+				res = append(res, model.MetricValue{
+					Key:      item.Var.Name,
+					Value:    sample.V,
+					DateTime: sample.T,
+					Labels:   labelsMap(sample.Labels),
+				})
+			}
+			result[item.Var] = res
+		}
+		logs.GetLogger().Infof(pathLOG+" Returning result: ", result)
+
+		return result
+	}
+}
+
+/*
+NewQuerier returns a function that implements monitor.MonitoringAdapter.Query with the samples of a buffer. The metric
+is evaluated at the current time; if path is set, only the series with that "path" label are evaluated (a path
+starting with '~' is a regular expression).
+*/
+func NewQuerier(buffer *tsbuffer.Buffer) genericadapter.Querier {
+	return func(metric string, path string) (interface{}, error) {
+		logs.GetLogger().Debug("metric: " + metric + ", path: " + path)
+
+		query := metric
+		if re, ok := strings.CutPrefix(path, "~"); ok {
+			query = metric + `{path=~"` + re + `"}`
+		} else if path != "" {
+			query = metric + `{path="` + path + `"}`
+		}
+
+		now := time.Now()
+		samples, err := Eval(buffer, query, now, now)
+		if err != nil {
+			return nil, err
+		}
+		res := make([]model.MetricValue, 0, len(samples))
+		for _, sample := range samples {
+			res = append(res, model.MetricValue{
+				Key:      metric,
+				Value:    sample.V,
+				DateTime: sample.T,
+				Labels:   labelsMap(sample.Labels),
+			})
+		}
+		return res, nil
+	}
+}
+
+// labelsMap returns the labels of a series (without the metric name), or nil if the series has no labels
+func labelsMap(lbls labels.Labels) map[string]string {
+	var res map[string]string
+	lbls.Range(func(l labels.Label) {
+		if l.Name == labels.MetricName {
+			return
+		}
+		if res == nil {
+			res = make(map[string]string, lbls.Len())
+		}
+		res[l.Name] = l.Value
+	})
+	return res
+}
@@ -38,15 +38,13 @@ package prometheus
 import (
 	"context"
 	"errors"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"colmena/sla-management-svc/app/assessment/monitor/promclient"
 	"colmena/sla-management-svc/app/common/logs"
 
-	"github.com/prometheus/client_golang/api"
-	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
 )
 
@@ -75,8 +73,15 @@ type PromQLQueryAggregated struct {
 	Total string
 }
 
+// NewQuerier returns a function that implements monitor.MonitoringAdapter.Query with the Prometheus client
+func NewQuerier(client *promclient.Client) func(metric string, path string) (interface{}, error) {
+	return func(metric string, path string) (interface{}, error) {
+		return Query(client, metric, path)
+	}
+}
+
 // Query implements monitor.MonitoringAdapter.Query
-func Query(metric string, path string) (interface{}, error) {
+func Query(client *promclient.Client, metric string, path string) (interface{}, error) {
 	logs.GetLogger().Debug("metric: " + metric + ", path: " + path)
 
 	params := make(map[string]string)
@@ -120,7 +125,10 @@ func Query(metric string, path string) (interface{}, error) {
 	result := PromQLQueryResponse{}
 
 	// Query Prometheus
-	l := PromQuery(query_string)
+	l, err := PromQuery(client, query_string)
+	if err != nil {
+		return nil, err
+	}
 	for _, resQuery := range l {
 		result.Metrics = append(result.Metrics, *resQuery)
 		logs.GetLogger().Debug("resQuery: " + resQuery.Value.String())
@@ -161,7 +169,7 @@ func (q PromQLQuery) String() string {
 }
 
 // Query Prometheus
-func PromQuery(query string) model.Vector {
+func PromQuery(client *promclient.Client, query string) (model.Vector, error) {
 
 	// HTML URL Encoding Reference https://www.w3schools.com/tags/ref_urlencode.asp
 	// Examples:
@@ -170,28 +178,11 @@ func PromQuery(query string) model.Vector {
 	// 	]	%5D
 	query = strings.ReplaceAll(query, "%5B", "[")
 	query = strings.ReplaceAll(query, "%5D", "]")
-	// create prometheus API client
-	client, err := api.NewClient(api.Config{
-		Address: os.Getenv("PROMETHEUS_ADDRESS"),
-	})
-	if err != nil {
-		logs.GetLogger().Error(pathLOG+"Error creating client: ", err)
-	}
-
-	logs.GetLogger().Debug(pathLOG+"PromQL Query: ", query)
-
-	// create prometheus API object
-	v1api := v1.NewAPI(client)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	result, warnings, err := v1api.Query(ctx, query, time.Now(), v1.WithTimeout(10*time.Second))
+	result, err := client.Query(context.Background(), query, time.Now())
 	if err != nil {
 		logs.GetLogger().Error(pathLOG+"Error querying Prometheus: ", err)
-	}
-	if len(warnings) > 0 {
-		logs.GetLogger().Warn(pathLOG+"Warnings: ", warnings)
+		return nil, err
 	}
 
 	// match the response to vector and print the response values
@@ -202,11 +193,11 @@ func PromQuery(query string) model.Vector {
 			logs.GetLogger().Debug(pathLOG+"PromQL Query Result length is zero: ", query)
 		}
 
-		return r
+		return r, nil
 
 	default:
 		logs.GetLogger().Error(pathLOG + "Response is not a modelVector. Error: " + errors.New("not implemented").Error())
 
-		return nil
+		return nil, nil
 	}
 }
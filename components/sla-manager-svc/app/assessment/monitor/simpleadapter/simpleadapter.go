@@ -43,7 +43,7 @@ Usage:
 	ma := simpleadapter.New()
 	ma = ma.Initialize(&agreement)
 	for _, gt := range gts {
-		for values := range ma.GetValues(gt, ...) {
+		for values := range ma.GetValues(ctx, gt, ...) {
 			...
 		}
 	}
@@ -56,6 +56,7 @@ import (
 	"colmena/sla-management-svc/app/common/logs"
 	"colmena/sla-management-svc/app/model"
 
+	"context"
 	"time"
 )
 
@@ -82,7 +83,7 @@ func (ma *ArrayMonitoringAdapter) Initialize(a *model.SLA) monitor.MonitoringAda
 }
 
 // GetValues implements monitor.MonitoringAdapter.GetValues
-func (ma *ArrayMonitoringAdapter) GetValues(gt model.Guarantee, vars []string, now time.Time) assessment_model.GuaranteeData {
+func (ma *ArrayMonitoringAdapter) GetValues(ctx context.Context, gt model.Guarantee, vars []string, now time.Time) assessment_model.GuaranteeData {
 	return ma.values
 }
 
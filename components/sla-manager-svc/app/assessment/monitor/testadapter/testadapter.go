@@ -40,6 +40,7 @@ import (
 	"colmena/sla-management-svc/app/assessment/monitor/genericadapter"
 	"colmena/sla-management-svc/app/model"
 
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/rand"
@@ -80,11 +81,15 @@ func logConfig(config *viper.Viper) {
 Retrieve implements genericadapter.Retrieve
 */
 func (r Retriever) Retrieve() genericadapter.Retrieve {
-	return func(agreement model.SLA, items []monitor.RetrievalItem) map[model.Variable][]model.MetricValue {
+	return func(ctx context.Context, agreement model.SLA, items []monitor.RetrievalItem) map[model.Variable][]model.MetricValue {
 		logs.GetLogger().Info(pathLOG + "[Retrieve] Retrieving metrics from Monitoring-Test Adapter ...")
 
 		result := make(map[model.Variable][]model.MetricValue)
 		for _, item := range items {
+			if ctx.Err() != nil {
+				logs.GetLogger().Warn(pathLOG+"[Retrieve] Retrieval cancelled: ", ctx.Err())
+				break
+			}
 			logs.GetLogger().Info(pathLOG + "[Retrieve] Checking [item.Var.Name=" + item.Var.Name + "] ...")
 
 			// call to test engine
@@ -0,0 +1,250 @@
+/*
+Copyright © 2024 EVIDEN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+This work has been implemented within the context of COLMENA project.
+*/
+
+/*
+Package tsbuffer provides a bounded in-memory time-series buffer, that stores the samples pushed to the SLA manager
+(see pushadapter).
+
+The buffer is bounded by:
+  - the maximum number of series: the samples of new series are rejected when the buffer is full
+  - the maximum number of samples of each series: the oldest samples of a series are overwritten
+  - the retention: the samples older than the retention are discarded, and the series without samples are removed
+
+Usage:
+
+	b := tsbuffer.New(10000, 1000, time.Hour)
+	b.Append(labels.FromStrings("__name__", "cpu_usage", "instance", "i1"), time.Now(), 0.5)
+	for _, s := range b.Select(matchers, from, to) {
+		...
+	}
+*/
+package tsbuffer
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+var (
+	// ErrNoMetricName is returned when a sample has no metric name (label "__name__")
+	ErrNoMetricName = errors.New("sample without metric name")
+
+	// ErrTooManySeries is returned when a sample of a new series is appended to a full buffer
+	ErrTooManySeries = errors.New("maximum number of series reached")
+
+	// ErrOutOfOrder is returned when a sample is older than the last sample of its series
+	ErrOutOfOrder = errors.New("out of order sample")
+
+	// ErrTooOld is returned when a sample is older than the retention
+	ErrTooOld = errors.New("sample older than the retention")
+)
+
+// Point is a sample of a series
+type Point struct {
+	T time.Time
+	V float64
+}
+
+// Series is a series of the buffer, with its points in the selected interval sorted by time
+type Series struct {
+	Labels labels.Labels
+	Points []Point
+}
+
+// Stats are the statistics of a buffer
+type Stats struct {
+	Series  int `json:"series"`
+	Samples int `json:"samples"`
+}
+
+// Buffer is a bounded in-memory time-series buffer. It is safe for concurrent use
+type Buffer struct {
+	maxSeries  int
+	maxSamples int
+	retention  time.Duration
+	now        func() time.Time
+
+	mu     sync.RWMutex
+	series map[string]*series
+}
+
+// series is a ring of the last points of a series. The ring grows up to max points
+type series struct {
+	labels labels.Labels
+	points []Point
+	head   int // index of the oldest point
+	size   int
+	max    int
+}
+
+/*
+New builds a buffer that stores at most maxSeries series of at most maxSamples samples each, for the retention period.
+*/
+func New(maxSeries, maxSamples int, retention time.Duration) *Buffer {
+	return &Buffer{
+		maxSeries:  maxSeries,
+		maxSamples: maxSamples,
+		retention:  retention,
+		now:        time.Now,
+		series:     make(map[string]*series),
+	}
+}
+
+/*
+Append adds a sample to the series identified by its labels, which must include the metric name. If the series already
+has a sample at the same time, its value is replaced.
+*/
+func (b *Buffer) Append(lbls labels.Labels, t time.Time, v float64) error {
+	if lbls.Get(labels.MetricName) == "" {
+		return ErrNoMetricName
+	}
+	if t.Before(b.now().Add(-b.retention)) {
+		return ErrTooOld
+	}
+
+	key := lbls.String()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.series[key]
+	if !ok {
+		if len(b.series) >= b.maxSeries {
+			b.prune()
+		}
+		if len(b.series) >= b.maxSeries {
+			return ErrTooManySeries
+		}
+		s = &series{labels: lbls, max: b.maxSamples}
+		b.series[key] = s
+	}
+	return s.append(Point{T: t, V: v})
+}
+
+/*
+Select returns the series whose labels match all the matchers, with their points in the interval (from, to]. The series
+without points in the interval are not returned.
+*/
+func (b *Buffer) Select(matchers []*labels.Matcher, from, to time.Time) []Series {
+	if oldest := b.now().Add(-b.retention); from.Before(oldest) {
+		from = oldest
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	res := make([]Series, 0)
+	for _, s := range b.series {
+		if !matches(s.labels, matchers) {
+			continue
+		}
+		if points := s.between(from, to); len(points) > 0 {
+			res = append(res, Series{Labels: s.labels, Points: points})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return labels.Compare(res[i].Labels, res[j].Labels) < 0
+	})
+	return res
+}
+
+// Stats returns the number of series and samples stored in the buffer
+func (b *Buffer) Stats() Stats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	res := Stats{Series: len(b.series)}
+	for _, s := range b.series {
+		res.Samples += s.size
+	}
+	return res
+}
+
+// prune discards the points older than the retention, and removes the series without points. Must be called with the
+// lock held
+func (b *Buffer) prune() {
+	oldest := b.now().Add(-b.retention)
+	for key, s := range b.series {
+		for s.size > 0 && s.points[s.head].T.Before(oldest) {
+			s.head = (s.head + 1) % len(s.points)
+			s.size--
+		}
+		if s.size == 0 {
+			delete(b.series, key)
+		}
+	}
+}
+
+// append adds a point after the last point of the series, overwriting the oldest point if the series is full
+func (s *series) append(p Point) error {
+	if s.size > 0 {
+		last := &s.points[(s.head+s.size-1)%len(s.points)]
+		if p.T.Equal(last.T) {
+			last.V = p.V
+			return nil
+		}
+		if p.T.Before(last.T) {
+			return ErrOutOfOrder
+		}
+	}
+	if s.size == len(s.points) && s.size < s.max {
+		// the ring can grow: the points are unwrapped (the oldest first) before growing
+		if s.head != 0 {
+			points := make([]Point, 0, s.size+1)
+			s.points = append(append(points, s.points[s.head:]...), s.points[:s.head]...)
+			s.head = 0
+		}
+		s.points = append(s.points, p)
+		s.size++
+		return nil
+	}
+	if s.size == len(s.points) {
+		s.points[s.head] = p
+		s.head = (s.head + 1) % len(s.points)
+		return nil
+	}
+	s.points[(s.head+s.size)%len(s.points)] = p
+	s.size++
+	return nil
+}
+
+// between returns a copy of the points in the interval (from, to]
+func (s *series) between(from, to time.Time) []Point {
+	var res []Point
+	for i := 0; i < s.size; i++ {
+		p := s.points[(s.head+i)%len(s.points)]
+		if p.T.After(from) && !p.T.After(to) {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// matches returns true if the labels match all the matchers
+func matches(lbls labels.Labels, matchers []*labels.Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(lbls.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+package tsbuffer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+)
+
+var t0 = time.Date(2025, 5, 21, 17, 0, 0, 0, time.UTC)
+
+// newTestBuffer returns a buffer with a clock set to *now
+func newTestBuffer(maxSeries, maxSamples int, retention time.Duration, now *time.Time) *Buffer {
+	b := New(maxSeries, maxSamples, retention)
+	b.now = func() time.Time { return *now }
+	return b
+}
+
+// at returns the time of the second i
+func at(i int) time.Time {
+	return t0.Add(time.Duration(i) * time.Second)
+}
+
+// points returns the points of the seconds with value = second
+func points(seconds ...int) []Point {
+	res := make([]Point, 0, len(seconds))
+	for _, i := range seconds {
+		res = append(res, Point{T: at(i), V: float64(i)})
+	}
+	return res
+}
+
+// all returns the points of a series in the buffer
+func all(s *series) []Point {
+	return s.between(time.Time{}, at(1000))
+}
+
+func TestSeriesAppendGrowAndWrap(t *testing.T) {
+	s := &series{max: 3}
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, s.append(Point{T: at(i), V: float64(i)}))
+		assert.Len(t, s.points, i)
+	}
+	assert.Equal(t, points(1, 2, 3), all(s))
+
+	// full: the oldest points are overwritten
+	assert.NoError(t, s.append(Point{T: at(4), V: 4}))
+	assert.NoError(t, s.append(Point{T: at(5), V: 5}))
+	assert.Len(t, s.points, 3)
+	assert.Equal(t, 3, s.size)
+	assert.Equal(t, 2, s.head)
+	assert.Equal(t, points(3, 4, 5), all(s))
+}
+
+func TestSeriesAppendGrowWrapped(t *testing.T) {
+	s := &series{max: 4}
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, s.append(Point{T: at(i), V: float64(i)}))
+	}
+	// the oldest point is discarded (see prune): the ring is wrapped when the next point is appended
+	s.head, s.size = 1, 2
+	assert.NoError(t, s.append(Point{T: at(4), V: 4}))
+	assert.Equal(t, 1, s.head)
+	assert.Equal(t, points(2, 3, 4), all(s))
+
+	// the ring grows: the points are unwrapped
+	assert.NoError(t, s.append(Point{T: at(5), V: 5}))
+	assert.Equal(t, 0, s.head)
+	assert.Len(t, s.points, 4)
+	assert.Equal(t, points(2, 3, 4, 5), all(s))
+
+	assert.NoError(t, s.append(Point{T: at(6), V: 6}))
+	assert.Len(t, s.points, 4)
+	assert.Equal(t, points(3, 4, 5, 6), all(s))
+}
+
+func TestSeriesAppendOutOfOrder(t *testing.T) {
+	s := &series{max: 3}
+	assert.NoError(t, s.append(Point{T: at(1), V: 1}))
+	assert.NoError(t, s.append(Point{T: at(2), V: 2}))
+
+	assert.ErrorIs(t, s.append(Point{T: at(1), V: 10}), ErrOutOfOrder)
+
+	// same time as the last point: the value is replaced
+	assert.NoError(t, s.append(Point{T: at(2), V: 20}))
+	assert.Equal(t, []Point{{T: at(1), V: 1}, {T: at(2), V: 20}}, all(s))
+}
+
+func TestBufferAppend(t *testing.T) {
+	now := at(100)
+	b := newTestBuffer(10, 10, time.Minute, &now)
+	cpu := labels.FromStrings(labels.MetricName, "cpu", "instance", "i1")
+
+	assert.ErrorIs(t, b.Append(labels.FromStrings("instance", "i1"), at(90), 1), ErrNoMetricName)
+	assert.ErrorIs(t, b.Append(cpu, at(30), 1), ErrTooOld)
+	assert.NoError(t, b.Append(cpu, at(90), 1))
+	assert.ErrorIs(t, b.Append(cpu, at(80), 1), ErrOutOfOrder)
+	assert.Equal(t, Stats{Series: 1, Samples: 1}, b.Stats())
+}
+
+func TestBufferPrune(t *testing.T) {
+	now := at(10)
+	b := newTestBuffer(1, 10, time.Minute, &now)
+	cpu := labels.FromStrings(labels.MetricName, "cpu")
+	mem := labels.FromStrings(labels.MetricName, "mem")
+
+	assert.NoError(t, b.Append(cpu, at(1), 1))
+	assert.NoError(t, b.Append(cpu, at(2), 2))
+	assert.NoError(t, b.Append(cpu, at(65), 65))
+
+	// the old points are discarded, but the series is kept: no room for a new series
+	now = at(70)
+	assert.ErrorIs(t, b.Append(mem, at(70), 1), ErrTooManySeries)
+	assert.Equal(t, Stats{Series: 1, Samples: 1}, b.Stats())
+
+	// the series without points are removed
+	now = at(200)
+	assert.NoError(t, b.Append(mem, at(200), 1))
+	assert.Equal(t, Stats{Series: 1, Samples: 1}, b.Stats())
+	assert.Empty(t, b.Select([]*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "cpu")}, at(0), now))
+}
+
+func TestBufferSelect(t *testing.T) {
+	now := at(100)
+	b := newTestBuffer(10, 10, 50*time.Second, &now)
+	i1 := labels.FromStrings(labels.MetricName, "cpu", "instance", "i1")
+	i2 := labels.FromStrings(labels.MetricName, "cpu", "instance", "i2")
+	for _, i := range []int{60, 70, 80, 90} {
+		assert.NoError(t, b.Append(i2, at(i), float64(i)))
+		assert.NoError(t, b.Append(i1, at(i), float64(i)))
+	}
+	cpu := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "cpu")
+
+	// interval (from, to], sorted by labels
+	assert.Equal(t, []Series{{Labels: i1, Points: points(70, 80)}, {Labels: i2, Points: points(70, 80)}},
+		b.Select([]*labels.Matcher{cpu}, at(60), at(80)))
+
+	// matchers
+	instance := labels.MustNewMatcher(labels.MatchRegexp, "instance", "i2|i3")
+	assert.Equal(t, []Series{{Labels: i2, Points: points(90)}}, b.Select([]*labels.Matcher{cpu, instance}, at(80), now))
+
+	// the interval starts at the retention
+	now = at(125)
+	assert.Equal(t, []Series{{Labels: i1, Points: points(80, 90)}}, b.Select([]*labels.Matcher{cpu, labels.MustNewMatcher(labels.MatchEqual, "instance", "i1")}, at(0), now))
+}
@@ -117,20 +117,37 @@ func (not _notifier) NotifyViolations(qos *model.SLA, result *amodel.Result) {
 			]
 		}
 	*/
+	// one KPI for each violated guarantee
+	kpis := []model.OutputSLAKpi{}
+	for _, gt := range qos.Details.Guarantees {
+		gtResult, ok := result.Violated[gt.Name]
+		if !ok || len(gtResult.Violations) == 0 {
+			continue
+		}
+		ag := qos.Assessment.GetGuarantee(gt.Name)
+		kpi := model.OutputSLAKpi{
+			RoleId:          gt.Role,
+			Query:           gt.Constraint,
+			Value:           gtResult.Violations[0].Values[0].Value,
+			Level:           ag.Level,
+			Threshold:       gt.Threshold,
+			ThresholdKey:    gt.ThresholdKey,
+			Violations:      gtResult.Violations,
+			TotalViolations: ag.TotalViolations,
+		}
+		if ag.Budget != nil {
+			remaining := ag.Budget.Remaining
+			kpi.BudgetRemaining = &remaining
+			kpi.BurnRates = ag.Budget.BurnRates
+		}
+		kpi.Series, kpi.ViolatedSeries = model.OutputSeries(ag)
+		kpis = append(kpis, kpi)
+	}
+
 	info := model.OutputSLA{
 		ServiceId: qos.Name,
 		SLAId:     qos.Id,
-		Kpis: []model.OutputSLAKpi{
-			{
-				RoleId:          qos.Details.Guarantees[0].Name,
-				Query:           qos.Details.Guarantees[0].Constraint,
-				Value:           vs[0].Values[0].Value,
-				Level:           qos.Assessment.Level,
-				Threshold:       qos.Assessment.Threshold, //qos.Details.Guarantees[0].Query,
-				Violations:      vs,
-				TotalViolations: qos.Assessment.TotalViolations,
-			},
-		},
+		Kpis:      kpis,
 	}
 
 	out, err1 := json.Marshal(info)
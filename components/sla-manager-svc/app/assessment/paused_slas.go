@@ -23,9 +23,11 @@ import (
 	"colmena/sla-management-svc/app/common/logs"
 	"colmena/sla-management-svc/app/model"
 	"encoding/json"
+	"errors"
 	"io"
 	"reflect"
 	"strings"
+	"time"
 
 	"net/http"
 
@@ -50,8 +52,14 @@ type ResponseData struct {
 }
 
 /*
-CheckPausedQoSDefinitions will check the paused QoS from the provided repository and set them to active when the context
-is completed. PAUSED SLA example:
+CheckScopedQoSDefinitions will check the SLAs with scope from the provided repository against the contexts of the agent
+(provided by contexts):
+
+  - PAUSED SLAs are set to active when the context is completed and the predicates of the scopes hold (see checkSLA)
+  - active SLAs are re-bound when the values of their context change, and STARTED SLAs are set to PAUSED when a
+    predicate of the scopes does not hold (see rebindSLA)
+
+PAUSED SLA example:
 
 	{
 		"id": "ExampleApplication-b7MJb7imo5qqcA4Ah25qRC",
@@ -67,7 +75,7 @@ is completed. PAUSED SLA example:
 			"guarantees": [{
 					"name": "Processing",
 					"constraint": "[go_memstats_frees_total] < 50000",
-					"query": "[go_memstats_frees_total#LABELS#] < 50000",
+					"query": "[go_memstats_frees_total] < 50000",
 					"scope": "company_premises/building=.",
 					"scopeTemplate": "company_premises/building=."
 				}
@@ -75,36 +83,70 @@ is completed. PAUSED SLA example:
 		}
 	}
 */
-func CheckPausedQoSDefinitions(cfg Config, vconfig *viper.Viper) {
+func CheckScopedQoSDefinitions(cfg Config, contexts ContextSource, vconfig *viper.Viper) {
 	repo := cfg.Repo
 
-	// Retrieve all PAUSED SLAs
-	qosdefs, err := repo.GetSLAsByState(model.PAUSED)
+	// Retrieve all PAUSED and active SLAs
+	qosdefs, err := repo.GetSLAsByState(model.PAUSED, model.STARTED, model.STOPPED)
 	if err != nil {
-		logs.GetLogger().Error(pathLOG+"[CheckPausedQoSDefinitions] Error getting PAUSED SLAs: %s", err.Error())
-	} else {
-		logs.GetLogger().Infof(pathLOG+"[CheckPausedQoSDefinitions] [%d PAUSED SLAs to check]", len(qosdefs))
-
-		if len(qosdefs) > 0 {
-			// get results from Zenoh
-			var items []ResponseData = getContextResults(vconfig)
-			if len(items) > 0 {
-				// check PAUSED SLAs
-				for _, qosd := range qosdefs {
-					logs.GetLogger().Info(pathLOG + "[CheckPausedQoSDefinitions] Checking PAUSED SLA " + qosd.Id + " ...")
-
-					// if context updated
-					if checkSLA(&qosd, items, vconfig) {
-						// update SLA (context and status)
-						repo.UpdateSLA(&qosd)
-						logs.GetLogger().Info(pathLOG + "[CheckPausedQoSDefinitions] SLA " + qosd.Id + " set to STARTED ...")
-					}
-				}
+		logs.GetLogger().Error(pathLOG+"[CheckScopedQoSDefinitions] Error getting SLAs: %s", err.Error())
+		return
+	}
+
+	scoped := make(model.SLAs, 0, len(qosdefs))
+	for _, qosd := range qosdefs {
+		if qosd.State == model.PAUSED || hasScope(qosd) {
+			scoped = append(scoped, qosd)
+		}
+	}
+	logs.GetLogger().Infof(pathLOG+"[CheckScopedQoSDefinitions] [%d SLAs with scope to check]", len(scoped))
+	if len(scoped) == 0 {
+		return
+	}
+
+	// get contexts (from Zenoh or from the cache of the subscriber). The SLAs are checked even without contexts, so that
+	// STARTED SLAs whose predicates do not hold anymore are set to PAUSED
+	var items []ResponseData = contexts.Contexts()
+
+	for _, qosd := range scoped {
+		logs.GetLogger().Info(pathLOG + "[CheckScopedQoSDefinitions] Checking " + string(qosd.State) + " SLA " + qosd.Id + " ...")
+
+		// if context updated
+		var updated bool
+		if qosd.State == model.PAUSED {
+			updated = checkSLA(&qosd, items, vconfig, cfg.Now)
+		} else {
+			updated = rebindSLA(&qosd, items, vconfig, cfg.Now)
+		}
+		if !updated {
+			continue
+		}
+
+		// update SLA (context and status)
+		_, err := repo.UpdateSLA(&qosd)
+		if errors.Is(err, model.ErrConflict) {
+			logs.GetLogger().Warn(pathLOG + "[CheckScopedQoSDefinitions] SLA " + qosd.Id + " was modified by another process. It will be checked again ...")
+		} else if err != nil {
+			logs.GetLogger().Error(pathLOG+"[CheckScopedQoSDefinitions] Error updating SLA "+qosd.Id+": ", err)
+		} else {
+			logs.GetLogger().Info(pathLOG + "[CheckScopedQoSDefinitions] SLA " + qosd.Id + " updated (" + string(qosd.State) + ") ...")
+			if cfg.Scheduler != nil {
+				cfg.Scheduler.Changed()
 			}
 		}
 	}
 }
 
+// hasScope returns true if any guarantee of the SLA has a scope
+func hasScope(sla model.SLA) bool {
+	for _, gt := range sla.Details.Guarantees {
+		if len(gt.Scope) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 /*
 getContextResults does a query to Zenoh (e.g. GET http://192.168.137.47:8000/colmena/contexts/**) to get all values
 from context. Example:
@@ -153,7 +195,7 @@ func getContextResults(vconfig *viper.Viper) []ResponseData {
 				// Print the parsed items
 				for _, item := range items {
 
-					resultMap := item.Value.(map[string]interface{})
+					resultMap, _ := item.Value.(map[string]interface{})
 					for key, value := range resultMap {
 						logs.GetLogger().Debug(pathLOG+" Key: ", key, " Value: ", value, " Type: ", reflect.TypeOf(value))
 					}
@@ -177,7 +219,7 @@ SLA
 			"guarantees": [{
 					"name": "Processing",
 					"constraint": "[go_memstats_frees_total] < 50000",
-					"query": "[go_memstats_frees_total#LABELS#] < 50000",
+					"query": "[go_memstats_frees_total] < 50000",
 					"scope": "company_premises/building=.",
 					"scopeTemplate": "company_premises/building=."
 				}
@@ -197,220 +239,256 @@ SLA
 		...
 	]
 */
-func checkSLA(sla *model.SLA, items []ResponseData, vconfig *viper.Viper) bool {
+func checkSLA(sla *model.SLA, items []ResponseData, vconfig *viper.Viper, now time.Time) bool {
 	logs.GetLogger().Debug(pathLOG + "[checkSLA] Checking SLA ...")
 
-	item := getData(*sla, items, vconfig)
-	/*
-		item example:
-			{
-				"key":"colmena/contexts/ColmenaAgent1/company_premises",
-				"value":{"building":"Red","floor":"22","room":"Rest Room"},
-				"encoding":"application/json",
-				"timestamp":""
-			}
-	*/
-	if (item == ResponseData{}) {
-		logs.GetLogger().Debug(pathLOG + "[checkSLA] No context for SLA found.")
+	// the predicates of the scopes must hold
+	if !checkPredicates(sla, items, vconfig) {
 		return false
 	}
 
-	/*
-
-		{
-			"key":"colmena/contexts/ColmenaAgent1/company_premises",
-			"value":{"building":"Red","floor":"22","room":"Rest Room"},
-			"encoding":"application/json",
-			"timestamp":""
+	// all the guarantees with a scope must be bound to their context before starting the SLA
+	bound := make([]model.Guarantee, len(sla.Details.Guarantees))
+	for i, gt := range sla.Details.Guarantees {
+		bound[i] = gt
+		if len(gt.Scope) > 0 && !checkGuarantee(&bound[i], items, vconfig) {
+			logs.GetLogger().Debug(pathLOG + "[checkSLA] Guarantee [" + gt.Name + "] not bound to its context.")
+			return false
 		}
+	}
+	for i := range bound {
+		rebindGuarantee(sla, i, bound[i], now)
+	}
 
-		==>
+	sla.State = model.STARTED
+	return true
+}
 
-		LABEL_MARK = {company_premises_building="\Red"\}
+/*
+rebindSLA checks the context of an active SLA:
 
-	*/
+  - a STARTED SLA is set to PAUSED if a predicate of its scopes does not hold (see checkPredicates)
+  - the guarantees are bound again to the values of their context (see checkGuarantee). If the context is not found,
+    the guarantee keeps the values bound
 
-	fullContextLabel := getFullContextLabelFromScope(*sla) // e.g. "company_premises_building"
-	destLabel := getSearchLabelFromScope(*sla)             // e.g. "building"
-	destLabelValue := ""
+Returns true if the SLA was updated.
+*/
+func rebindSLA(sla *model.SLA, items []ResponseData, vconfig *viper.Viper, now time.Time) bool {
+	if sla.State == model.STARTED && !checkPredicates(sla, items, vconfig) {
+		logs.GetLogger().Info(pathLOG + "[rebindSLA] Predicates of SLA " + sla.Id + " do not hold. Setting SLA to PAUSED ...")
+		sla.State = model.PAUSED
+		return true
+	}
 
-	resultMap := item.Value.(map[string]interface{})
-	for key, value := range resultMap {
-		if key == destLabel {
-			logs.GetLogger().Debug(pathLOG+"Label from scope: ", destLabel, " Key: ", key, " Value: ", value, " Type: ", reflect.TypeOf(value))
+	updated := false
+	for i, gt := range sla.Details.Guarantees {
+		if len(gt.Scope) == 0 {
+			continue
+		}
 
-			str, ok := value.(string)
-			if ok {
-				destLabelValue = str
-			} else {
-				logs.GetLogger().Warn(pathLOG+" Key: ", key, " Value: ", value, " Type: ", reflect.TypeOf(value))
-			}
+		bound := gt
+		if !checkGuarantee(&bound, items, vconfig) {
+			logs.GetLogger().Debug(pathLOG + "[rebindSLA] Guarantee [" + gt.Name + "] not bound to its context. Keeping the current values ...")
+			continue
+		}
+		if rebindGuarantee(sla, i, bound, now) {
+			updated = true
 		}
 	}
+	return updated
+}
 
-	if len(fullContextLabel) > 0 && len(destLabel) > 0 && len(destLabelValue) > 0 {
-		sla.State = model.STARTED
-		// replace labels
-		q := strings.Replace(sla.Details.Guarantees[0].Query,
-			expressions.LABEL_MARK,
-			"{"+fullContextLabel+"=\""+destLabelValue+"\"}",
-			1)
-		sla.Details.Guarantees[0].Constraint = q
+/*
+rebindGuarantee replaces the guarantee i of the SLA with the guarantee bound to the context (see checkGuarantee). When the
+values bound change (e.g. the agent moved from building Red to building Blue), the change is recorded in the assessment
+of the guarantee, and the level state of the guarantee is reset (see resetLevel), so that the new values start clean.
+The rest of the assessment (violations, last values, budget, series) is kept.
 
-		return true
+Returns true if the values bound changed.
+*/
+func rebindGuarantee(sla *model.SLA, i int, bound model.Guarantee, now time.Time) bool {
+	gt := &sla.Details.Guarantees[i]
+	if reflect.DeepEqual(gt.Bindings, bound.Bindings) {
+		*gt = bound
+		return false
 	}
 
-	logs.GetLogger().Debug(pathLOG + "[checkSLA] Label used in PromQL queries not set.")
-	return false
+	if gt.Bindings != nil {
+		logs.GetLogger().Infof(pathLOG+"[rebindGuarantee] Guarantee [%s] of SLA %s re-bound: %v => %v", gt.Name, sla.Id, gt.Bindings, bound.Bindings)
+		ag := sla.Assessment.GetGuarantee(gt.Name)
+		resetLevel(&ag)
+		ag.Rebinding = &model.Rebinding{Time: now, From: gt.Bindings, To: bound.Bindings}
+		sla.Assessment.SetGuarantee(gt.Name, ag)
+	}
+	*gt = bound
+	return true
+}
+
+// resetLevel resets the state of the level policies (counters, window and ewma) and the level of the guarantee
+func resetLevel(ag *model.AssessmentGuarantee) {
+	ag.XCounter = 0
+	ag.YCounter = 0
+	ag.ZCounter = 0
+	ag.Window = nil
+	ag.Ewma = 0
+	ag.Level = ""
 }
 
 /*
-SLA example:
+checkPredicates returns true if all the predicates of the scopes of the guarantees of the SLA hold with the values of the
+contexts (e.g. "company_premises/occupancy>10"). A predicate does not hold if its label is not found in the context.
+*/
+func checkPredicates(sla *model.SLA, items []ResponseData, vconfig *viper.Viper) bool {
+	for _, gt := range sla.Details.Guarantees {
+		scope, err := model.ParseScope(gt.Scope)
+		if err != nil {
+			logs.GetLogger().Error(pathLOG+"[checkPredicates] Invalid scope of guarantee ["+gt.Name+"]: ", err)
+			return false
+		}
 
-	{
-		...
-		"details": {
-			"guarantees": [{
-					...
-					"scope": "company_premises/building=."
-				}
-			]
+		for _, sl := range scope {
+			if !sl.IsPredicate() {
+				continue
+			}
+			value, ok := getContextValue(sl, items, vconfig)
+			if !ok || !sl.Holds(value) {
+				logs.GetLogger().Debug(pathLOG+"[checkPredicates] Predicate '"+sl.Label+" "+sl.Operator+" "+strings.Join(sl.Values, ",")+"' of context '"+sl.Context+"' does not hold. Value: ", value)
+				return false
+			}
 		}
 	}
+	return true
+}
 
-[]ResponseData
+/*
+checkGuarantee binds the labels of the guarantee scope to the values found in their contexts (see checkSLA). A scope
+can bind several labels from one or more contexts (e.g. "company_premises/building=.,floor=.;network/zone=."); the
+predicates of the scope are not checked here (see checkPredicates).
+Returns true if the constraint of the guarantee was set, i.e. if all the labels were found.
+*/
+func checkGuarantee(gt *model.Guarantee, items []ResponseData, vconfig *viper.Viper) bool {
+	scope, err := model.ParseScope(gt.Scope)
+	if err != nil {
+		logs.GetLogger().Error(pathLOG+"[checkGuarantee] Invalid scope of guarantee ["+gt.Name+"]: ", err)
+		return false
+	}
 
-	[
+	/*
 		{
 			"key":"colmena/contexts/ColmenaAgent1/company_premises",
-			"value":{"building":"Red","floor":"22","room":"Rest Room"},
+			"value":{"building":"Red","floor":22,"room":"Rest Room"},
 			"encoding":"application/json",
 			"timestamp":""
 		}
-		...
-	]
-*/
-func getData(sla model.SLA, items []ResponseData, vconfig *viper.Viper) ResponseData {
-	context_scope := getSLAContextFromScope(sla) // e.g. "company_premises"
-	if len(context_scope) > 0 {
-		context := ""
-		if len(vconfig.GetString(cfgconst.AgentIdPropertyName)) > 0 {
-			context = vconfig.GetString(cfgconst.ContextZenohContextsPropertyName) + "/" +
-				vconfig.GetString(cfgconst.AgentIdPropertyName) + "/" + context_scope
-		} else {
-			context = vconfig.GetString(cfgconst.ContextZenohContextsPropertyName) + "/" +
-				vconfig.GetString(cfgconst.ComposeProjectPropertyName) + "/" + context_scope
-		}
 
-		// => e.g. "colmena/contexts/ColmenaAgent1/company_premises"
-		logs.GetLogger().Debug(pathLOG + "[checkSLA] context: " + context)
+		==>
 
-		for _, item := range items {
-			if item.Key == context {
-				return item
-			}
+		label matchers company_premises_building="Red", company_premises_floor="22", added to each vector selector
+		of the queries
+	*/
+	bindings := make(map[string]string, len(scope))
+	for _, sl := range scope {
+		if sl.IsPredicate() {
+			continue
 		}
-	}
 
-	logs.GetLogger().Warn(pathLOG + "[getData] Context data not found")
-	return ResponseData{}
-}
-
-/*
-SLA example:
-
-	{
-		...
-		"details": {
-			"guarantees": [{
-					...
-					"scope": "company_premises/building=."
-				}
-			]
+		value, ok := getContextValue(sl, items, vconfig)
+		if !ok {
+			return false
 		}
+		str, ok := model.FormatContextValue(value)
+		if !ok || len(str) == 0 {
+			logs.GetLogger().Warn(pathLOG+"[checkGuarantee] Label '"+sl.Label+"' of context '"+sl.Context+"' can not be bound. Value: ", value, " Type: ", reflect.TypeOf(value))
+			return false
+		}
+		bindings[sl.Name()] = str
 	}
 
-Returns "company_premises"
-*/
-func getSLAContextFromScope(sla model.SLA) string {
-	logs.GetLogger().Debug(pathLOG + "[getSLAContextFromScope] Getting context from SLA scope ...")
-	if len(sla.Details.Guarantees) > 0 {
-		scope := sla.Details.Guarantees[0].Scope
-		if len(scope) > 0 {
-			arr := strings.Split(scope, "/")
-			if len(arr) > 0 {
-				logs.GetLogger().Debug(pathLOG + "[getSLAContextFromScope] return " + arr[0])
-				return arr[0]
+	// inject the labels in the vector selectors of all the metric queries
+	constraint, err := expressions.InjectLabels(gt.Query, bindings)
+	if err != nil {
+		logs.GetLogger().Error(pathLOG+"[checkGuarantee] Error injecting labels in "+gt.Query+": ", err)
+		return false
+	}
+
+	// context thresholds: the threshold of the value bound (e.g. 1 in building Red, 3 in building Blue)
+	if gt.Thresholds != nil {
+		threshold, key := gt.Thresholds.Get(bindings)
+		if len(key) > 0 {
+			if constraint, err = expressions.SetThreshold(constraint, threshold); err != nil {
+				logs.GetLogger().Error(pathLOG+"[checkGuarantee] Error setting the threshold of "+gt.Query+": ", err)
+				return false
 			}
+			gt.Threshold = threshold
+			gt.ThresholdKey = key
 		}
 	}
+	gt.Constraint = constraint
+	gt.Bindings = bindings
 
-	logs.GetLogger().Warn(pathLOG + "[getSLAContextFromScope] Error with SLA context. Return ''")
-	return ""
+	return true
 }
 
 /*
-SLA example:
+getContextValue returns the (typed) value of a label of a scope in the contexts of the agent, or false if not found:
 
 	{
+		"key":"colmena/contexts/ColmenaAgent1/company_premises",
+		"value":{"building":"Red","floor":22,"online":true},
 		...
-		"details": {
-			"guarantees": [{
-					...
-					"scope": "company_premises/building=."
-				}
-			]
-		}
 	}
 
-Returns "company_premises_building"
+Returns "Red" for the label "building" of the context "company_premises".
 */
-func getFullContextLabelFromScope(sla model.SLA) string {
-	logs.GetLogger().Debug(pathLOG + "[getFullContextLabelFromScope] Getting full context label from SLA scope ...")
-	if len(sla.Details.Guarantees) > 0 {
-		scope := sla.Details.Guarantees[0].Scope
-		if len(scope) > 0 {
-			arr := strings.Split(scope, "/")
-			if len(arr) > 1 && len(arr[1]) > 2 {
-				logs.GetLogger().Debug(pathLOG + "[getFullContextLabelFromScope] return " + arr[0] + "_" + arr[1][:len(arr[1])-2])
-				return arr[0] + "_" + arr[1][:len(arr[1])-2]
-			}
-		}
+func getContextValue(sl model.ScopeLabel, items []ResponseData, vconfig *viper.Viper) (interface{}, bool) {
+	item, ok := getData(sl.Context, items, vconfig)
+	if !ok {
+		logs.GetLogger().Debug(pathLOG + "[getContextValue] No context '" + sl.Context + "' found.")
+		return nil, false
 	}
 
-	logs.GetLogger().Warn(pathLOG + "[getFullContextLabelFromScope] Full context label error. Return ''")
-	return ""
+	resultMap, ok := item.Value.(map[string]interface{})
+	if !ok {
+		logs.GetLogger().Warn(pathLOG + "[getContextValue] Context " + item.Key + " is not an object")
+		return nil, false
+	}
+
+	value, ok := resultMap[sl.Label]
+	if !ok {
+		logs.GetLogger().Debug(pathLOG + "[getContextValue] Label '" + sl.Label + "' of context '" + sl.Context + "' not set.")
+		return nil, false
+	}
+	logs.GetLogger().Debug(pathLOG+"Label from scope: ", sl.Label, " Value: ", value, " Type: ", reflect.TypeOf(value))
+	return value, true
 }
 
 /*
-SLA example:
+getData returns the data of a context of the agent (e.g. "company_premises"):
 
-	{
-		...
-		"details": {
-			"guarantees": [{
-					...
-					"scope": "company_premises/building=."
-				}
-			]
-		}
-	}
+[]ResponseData
 
-Returns "building"
+	[
+		{
+			"key":"colmena/contexts/ColmenaAgent1/company_premises",
+			"value":{"building":"Red","floor":"22","room":"Rest Room"},
+			"encoding":"application/json",
+			"timestamp":""
+		}
+		...
+	]
 */
-func getSearchLabelFromScope(sla model.SLA) string {
-	logs.GetLogger().Debug(pathLOG + "[getSearchLabelFromScope] Getting search label from SLA scope ...")
-	if len(sla.Details.Guarantees) > 0 {
-		scope := sla.Details.Guarantees[0].Scope
-		if len(scope) > 0 {
-			arr := strings.Split(scope, "/")
-			if len(arr) > 1 && len(arr[1]) > 2 {
-				logs.GetLogger().Debug(pathLOG + "[getSearchLabelFromScope] return '" + arr[1][:len(arr[1])-2] + "'")
-				return arr[1][:len(arr[1])-2]
-			}
+func getData(contextScope string, items []ResponseData, vconfig *viper.Viper) (ResponseData, bool) {
+	context := agentContextsKey(vconfig) + "/" + contextScope
+
+	// => e.g. "colmena/contexts/ColmenaAgent1/company_premises"
+	logs.GetLogger().Debug(pathLOG + "[getData] context: " + context)
+
+	for _, item := range items {
+		if item.Key == context {
+			return item, true
 		}
 	}
 
-	logs.GetLogger().Warn(pathLOG + "[getSearchLabelFromScope] Search label error. Return ''")
-	return ""
+	logs.GetLogger().Warn(pathLOG + "[getData] Context data not found")
+	return ResponseData{}, false
 }
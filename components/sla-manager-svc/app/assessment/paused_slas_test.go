@@ -0,0 +1,258 @@
+package assessment
+
+import (
+	"testing"
+	"time"
+
+	cfgconst "colmena/sla-management-svc/app/common/cfg"
+	"colmena/sla-management-svc/app/model"
+	"colmena/sla-management-svc/app/repositories/memrepository"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestConfig returns the configuration of the agent "agent1"
+func newTestConfig() *viper.Viper {
+	vconfig := viper.New()
+	vconfig.Set(cfgconst.AgentIdPropertyName, "agent1")
+	vconfig.Set(cfgconst.ContextZenohContextsPropertyName, cfgconst.DefaultContextZenohContexts)
+	return vconfig
+}
+
+// testContextData returns the data of a context of the agent "agent1"
+func testContextData(context string, value map[string]interface{}) ResponseData {
+	return ResponseData{Key: "colmena/contexts/agent1/" + context, Value: value, Encoding: "application/json"}
+}
+
+var (
+	premisesContext = testContextData("company_premises", map[string]interface{}{"building": "Red", "floor": 22.0})
+	networkContext  = testContextData("network", map[string]interface{}{"zone": "z1"})
+)
+
+func TestRebindGuaranteeKeepsAssessment(t *testing.T) {
+	now := time.Now()
+	sla := newTestSLA()
+	sla.Details.Guarantees[0].Bindings = map[string]string{"building": "Red"}
+
+	violation := &model.Violation{Id: "v1", Guarantee: "gt1", Datetime: now.Add(-time.Minute)}
+	sla.Assessment.SetGuarantee("gt1", model.AssessmentGuarantee{
+		LastExecution:   now.Add(-time.Minute),
+		LastValues:      model.LastValues{"metric": {Key: "metric", Value: 10.0}},
+		LastViolation:   violation,
+		XCounter:        3,
+		YCounter:        1,
+		ZCounter:        2,
+		Level:           model.ASSESSMENT_LEVEL_CRITICAL,
+		Violated:        true,
+		TotalViolations: 4,
+		Window:          []bool{true, false, true},
+		Ewma:            0.7,
+		Budget:          &model.ErrorBudget{Remaining: 0.5},
+		Series:          map[string]model.AssessmentGuarantee{"s1": {TotalViolations: 2}},
+	})
+
+	bound := sla.Details.Guarantees[0]
+	bound.Bindings = map[string]string{"building": "Blue"}
+
+	// same values bound: the assessment is not changed
+	assert.False(t, rebindGuarantee(sla, 0, sla.Details.Guarantees[0], now))
+	assert.Equal(t, 3, sla.Assessment.GetGuarantee("gt1").XCounter)
+
+	assert.True(t, rebindGuarantee(sla, 0, bound, now))
+	assert.Equal(t, bound.Bindings, sla.Details.Guarantees[0].Bindings)
+
+	ag := sla.Assessment.GetGuarantee("gt1")
+	// level state reset
+	assert.Zero(t, ag.XCounter)
+	assert.Zero(t, ag.YCounter)
+	assert.Zero(t, ag.ZCounter)
+	assert.Nil(t, ag.Window)
+	assert.Zero(t, ag.Ewma)
+	assert.Empty(t, ag.Level)
+	if assert.NotNil(t, ag.Rebinding) {
+		assert.Equal(t, map[string]string{"building": "Red"}, ag.Rebinding.From)
+		assert.Equal(t, bound.Bindings, ag.Rebinding.To)
+		assert.True(t, now.Equal(ag.Rebinding.Time))
+	}
+	// rest of the assessment kept
+	assert.Equal(t, violation, ag.LastViolation)
+	assert.Equal(t, 4, ag.TotalViolations)
+	assert.Equal(t, 10.0, ag.LastValues["metric"].Value)
+	assert.Equal(t, 0.5, ag.Budget.Remaining)
+	assert.Equal(t, 2, ag.Series["s1"].TotalViolations)
+	assert.True(t, now.Add(-time.Minute).Equal(ag.LastExecution))
+}
+
+func TestCheckGuaranteeBindings(t *testing.T) {
+	vconfig := newTestConfig()
+	for _, tc := range []struct {
+		name     string
+		scope    string
+		items    []ResponseData
+		bindings map[string]string
+	}{
+		{
+			name:     "several labels",
+			scope:    "company_premises/building=.,floor=.",
+			items:    []ResponseData{premisesContext},
+			bindings: map[string]string{"company_premises_building": "Red", "company_premises_floor": "22"},
+		},
+		{
+			name:  "several contexts",
+			scope: "company_premises/building=.,floor=.;network/zone=.",
+			items: []ResponseData{networkContext, premisesContext},
+			bindings: map[string]string{
+				"company_premises_building": "Red", "company_premises_floor": "22", "network_zone": "z1"},
+		},
+		{
+			name:  "context not found",
+			scope: "company_premises/building=.;network/zone=.",
+			items: []ResponseData{premisesContext},
+		},
+		{
+			name:  "label not found",
+			scope: "company_premises/building=.,room=.",
+			items: []ResponseData{premisesContext},
+		},
+		{
+			name:  "context of other agent",
+			scope: "network/zone=.",
+			items: []ResponseData{{Key: "colmena/contexts/agent2/network", Value: map[string]interface{}{"zone": "z1"}}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gt := model.Guarantee{Name: "gt1", Query: "[metric] < 5", Constraint: "[metric] < 5", Scope: tc.scope}
+			ok := checkGuarantee(&gt, tc.items, vconfig)
+			assert.Equal(t, tc.bindings != nil, ok)
+			assert.Equal(t, tc.bindings, gt.Bindings)
+			if !ok {
+				assert.Equal(t, "[metric] < 5", gt.Constraint)
+				return
+			}
+			for name, value := range tc.bindings {
+				assert.Contains(t, gt.Constraint, name+`="`+value+`"`)
+			}
+		})
+	}
+}
+
+func TestCheckScopedSLAWaitsForAllBindings(t *testing.T) {
+	mem, _ := memrepository.New()
+	sla := newTestSLA()
+	sla.State = model.PAUSED
+	sla.Details.Guarantees = []model.Guarantee{
+		{Name: "gt1", Query: "[metric] < 5", Constraint: "[metric] < 5", Scope: "company_premises/building=."},
+		{Name: "gt2", Query: "[other] < 5", Constraint: "[other] < 5", Scope: "network/zone=."},
+	}
+	_, err := mem.CreateSLA(sla)
+	assert.NoError(t, err)
+
+	vconfig := newTestConfig()
+	cfg := Config{Repo: mem, Now: time.Now()}
+	check := func(items ...ResponseData) *model.SLA {
+		CheckScopedQoSDefinitions(cfg, fixedContextSource(items), vconfig)
+		stored, err := mem.GetSLA("sla1")
+		assert.NoError(t, err)
+		return stored
+	}
+
+	// only one guarantee can be bound: the SLA stays PAUSED, and nothing is bound
+	stored := check(premisesContext)
+	assert.Equal(t, model.PAUSED, stored.State)
+	for _, gt := range stored.Details.Guarantees {
+		assert.Nil(t, gt.Bindings, gt.Name)
+	}
+
+	stored = check(premisesContext, networkContext)
+	assert.Equal(t, model.STARTED, stored.State)
+	assert.Equal(t, map[string]string{"company_premises_building": "Red"}, stored.Details.Guarantees[0].Bindings)
+	assert.Equal(t, map[string]string{"network_zone": "z1"}, stored.Details.Guarantees[1].Bindings)
+}
+
+func TestCheckScopedSLAPredicates(t *testing.T) {
+	mem, _ := memrepository.New()
+	sla := newTestSLA()
+	sla.State = model.PAUSED
+	sla.Details.Guarantees[0].Query = "[metric] < 5"
+	sla.Details.Guarantees[0].Scope = "company_premises/building=.,occupancy>10;schedule/mode in (day,evening)"
+	_, err := mem.CreateSLA(sla)
+	assert.NoError(t, err)
+
+	vconfig := newTestConfig()
+	cfg := Config{Repo: mem, Now: time.Now()}
+	premises := func(occupancy float64) ResponseData {
+		return testContextData("company_premises", map[string]interface{}{"building": "Red", "occupancy": occupancy})
+	}
+	schedule := func(mode string) ResponseData {
+		return testContextData("schedule", map[string]interface{}{"mode": mode})
+	}
+
+	for _, step := range []struct {
+		name  string
+		items []ResponseData
+		state model.State
+	}{
+		{name: "predicate does not hold", items: []ResponseData{premises(5), schedule("day")}, state: model.PAUSED},
+		{name: "predicate label not found", items: []ResponseData{premises(20)}, state: model.PAUSED},
+		{name: "predicates hold", items: []ResponseData{premises(20), schedule("day")}, state: model.STARTED},
+		{name: "predicates still hold", items: []ResponseData{premises(30), schedule("evening")}, state: model.STARTED},
+		{name: "predicate stops holding", items: []ResponseData{premises(30), schedule("night")}, state: model.PAUSED},
+		{name: "predicates hold again", items: []ResponseData{premises(30), schedule("day")}, state: model.STARTED},
+		{name: "no contexts", items: []ResponseData{}, state: model.PAUSED},
+	} {
+		CheckScopedQoSDefinitions(cfg, fixedContextSource(step.items), vconfig)
+		stored, err := mem.GetSLA("sla1")
+		assert.NoError(t, err)
+		assert.Equal(t, step.state, stored.State, step.name)
+		if step.state == model.STARTED {
+			assert.Equal(t, map[string]string{"company_premises_building": "Red"}, stored.Details.Guarantees[0].Bindings, step.name)
+		}
+	}
+}
+
+func TestCheckGuaranteeThresholds(t *testing.T) {
+	vconfig := newTestConfig()
+	def := 2.0
+	for _, tc := range []struct {
+		name       string
+		building   string
+		fallback   *float64
+		constraint string
+		threshold  float64
+		key        string
+	}{
+		{name: "value bound", building: "Red", constraint: `[metric{company_premises_building="Red"}] < 1`, threshold: 1, key: "Red"},
+		{name: "default", building: "Green", fallback: &def, constraint: `[metric{company_premises_building="Green"}] < 2`, threshold: 2, key: model.THRESHOLD_KEY_DEFAULT},
+		{name: "no default", building: "Green", constraint: `[metric{company_premises_building="Green"}] < 5`, threshold: 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gt := model.Guarantee{
+				Name:       "gt1",
+				Query:      "[metric] < 5",
+				Constraint: "[metric] < 5",
+				Scope:      "company_premises/building=.",
+				Threshold:  5,
+				Thresholds: &model.Thresholds{
+					Label:   "company_premises_building",
+					Values:  map[string]float64{"Red": 1, "Blue": 3},
+					Default: tc.fallback,
+				},
+			}
+			items := []ResponseData{testContextData("company_premises", map[string]interface{}{"building": tc.building})}
+
+			assert.True(t, checkGuarantee(&gt, items, vconfig))
+			assert.Equal(t, tc.constraint, gt.Constraint)
+			assert.Equal(t, tc.threshold, gt.Threshold)
+			assert.Equal(t, tc.key, gt.ThresholdKey)
+			assert.Equal(t, "[metric] < 5", gt.Query)
+
+			// re-bound: the threshold is set again from the query
+			items = []ResponseData{testContextData("company_premises", map[string]interface{}{"building": "Blue"})}
+			assert.True(t, checkGuarantee(&gt, items, vconfig))
+			assert.Equal(t, `[metric{company_premises_building="Blue"}] < 3`, gt.Constraint)
+			assert.Equal(t, 3.0, gt.Threshold)
+			assert.Equal(t, "Blue", gt.ThresholdKey)
+		})
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright © 2024 EVIDEN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+This work has been implemented within the context of COLMENA project.
+*/
+package assessment
+
+import (
+	"sync"
+	"time"
+
+	"colmena/sla-management-svc/app/model"
+)
+
+// MaxSchedulerTick is the maximum time between two checks of the scheduler
+const MaxSchedulerTick = time.Second
+
+/*
+Scheduler keeps the next evaluation time of each guarantee of the SLAs. The evaluation period of a guarantee is the one
+defined in its KPI (see model.Guarantee.GetPeriod), or the global period if not defined.
+
+The assessment thread checks the scheduler every tick (see Tick). The SLAs are only loaded from the repository when a
+guarantee is due, when the global period has passed since the last load (to find the new SLAs), or when the SLAs have
+changed (see Changed); and only the guarantees that are due are evaluated (see IsDue).
+*/
+type Scheduler struct {
+	mu      sync.Mutex
+	period  time.Duration
+	tick    time.Duration
+	next    map[string]map[string]time.Time // next evaluation time of each guarantee, by SLA id
+	due     map[string]map[string]bool      // guarantees due in the current execution, by SLA id
+	reload  time.Time                       // next time the SLAs must be loaded
+	changed bool
+}
+
+// NewScheduler creates a Scheduler with the global evaluation period
+func NewScheduler(period time.Duration) *Scheduler {
+	tick := period
+	if tick > MaxSchedulerTick || tick <= 0 {
+		tick = MaxSchedulerTick
+	}
+	return &Scheduler{
+		period: period,
+		tick:   tick,
+		next:   make(map[string]map[string]time.Time),
+		due:    make(map[string]map[string]bool),
+	}
+}
+
+// Period returns the global evaluation period: the period of the guarantees that do not define one
+func (s *Scheduler) Period() time.Duration {
+	return s.period
+}
+
+// Tick returns the time between two checks of the scheduler: the global period, up to MaxSchedulerTick
+func (s *Scheduler) Tick() time.Duration {
+	return s.tick
+}
+
+/*
+Changed tells the scheduler that SLAs were created, deleted or activated out of the assessment thread (e.g. by the REST
+API or the context check thread): the SLAs are loaded again in the next check
+*/
+func (s *Scheduler) Changed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed = true
+}
+
+// Reload returns true if the SLAs must be loaded from the repository at time now (see DueSLAs)
+func (s *Scheduler) Reload(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// half a tick of tolerance, as ticks are not exact
+	return s.changed || !now.Add(s.tick/2).Before(s.reload)
+}
+
+/*
+DueSLAs returns the SLAs of the list with guarantees that must be evaluated at time now, and sets the next evaluation
+time of those guarantees (see Discarded if the results are not stored). SLAs not started are always returned (they are
+not evaluated). SLAs not in the list are removed from the scheduler.
+*/
+func (s *Scheduler) DueSLAs(slas model.SLAs, now time.Time) model.SLAs {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.changed = false
+	s.reload = now.Add(s.period)
+
+	res := make(model.SLAs, 0, len(slas))
+	next := make(map[string]map[string]time.Time, len(slas))
+	s.due = make(map[string]map[string]bool, len(slas))
+	for _, sla := range slas {
+		if sla.State != model.STARTED {
+			res = append(res, sla)
+			continue
+		}
+
+		gtsNext := make(map[string]time.Time, len(sla.Details.Guarantees))
+		due := make(map[string]bool)
+		for _, gt := range sla.Details.Guarantees {
+			// half a tick of tolerance, as ticks are not exact
+			t, ok := s.next[sla.Id][gt.Name]
+			if !ok || !now.Add(s.tick/2).Before(t) {
+				t = now.Add(gt.GetPeriod(s.period))
+				due[gt.Name] = true
+			}
+			gtsNext[gt.Name] = t
+			if t.Before(s.reload) {
+				s.reload = t
+			}
+		}
+		next[sla.Id] = gtsNext
+
+		if len(due) > 0 {
+			s.due[sla.Id] = due
+			res = append(res, sla)
+		}
+	}
+	s.next = next
+	return res
+}
+
+// IsDue returns true if the guarantee of the SLA must be evaluated in the current execution (see DueSLAs)
+func (s *Scheduler) IsDue(slaId string, gtName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.due[slaId][gtName]
+}
+
+/*
+Discarded tells the scheduler that the results of the guarantees of the SLA due in the current execution were discarded
+(e.g. the SLA was modified during the assessment, or the assessment was cancelled): the guarantees are due again, and
+the SLAs are loaded again in the next check
+*/
+func (s *Scheduler) Discarded(slaId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for gtName := range s.due[slaId] {
+		delete(s.next[slaId], gtName)
+	}
+	s.changed = true
+}
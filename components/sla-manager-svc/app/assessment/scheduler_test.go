@@ -0,0 +1,163 @@
+package assessment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"colmena/sla-management-svc/app/model"
+	"colmena/sla-management-svc/app/repositories/memrepository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingRepository counts the loads of the SLAs
+type countingRepository struct {
+	memrepository.MemRepository
+	loads *int
+}
+
+func (r countingRepository) GetSLAsByState(states ...model.State) (model.SLAs, error) {
+	*r.loads++
+	return r.MemRepository.GetSLAsByState(states...)
+}
+
+// newScheduledSLA returns a started SLA with a fast guarantee (period 5s) and a slow guarantee (period 20s)
+func newScheduledSLA() *model.SLA {
+	return &model.SLA{
+		Id:    "sla1",
+		Name:  "service1",
+		State: model.STARTED,
+		Details: model.Details{
+			Guarantees: []model.Guarantee{
+				{Name: "fast", Constraint: "metric < 5", Threshold: 5, Period: "5s"},
+				{Name: "slow", Constraint: "metric < 5", Threshold: 5, Period: "20s"},
+			},
+		},
+	}
+}
+
+func TestSchedulerDueGuarantees(t *testing.T) {
+	s := NewScheduler(30 * time.Second)
+	slas := model.SLAs{*newScheduledSLA()}
+	t0 := time.Now()
+
+	assert.True(t, s.Reload(t0))
+	assert.Len(t, s.DueSLAs(slas, t0), 1)
+	assert.True(t, s.IsDue("sla1", "fast"))
+	assert.True(t, s.IsDue("sla1", "slow"))
+
+	assert.False(t, s.Reload(t0.Add(time.Second)))
+	assert.True(t, s.Reload(t0.Add(5*time.Second)))
+
+	assert.Len(t, s.DueSLAs(slas, t0.Add(5*time.Second)), 1)
+	assert.True(t, s.IsDue("sla1", "fast"))
+	assert.False(t, s.IsDue("sla1", "slow"))
+
+	assert.Len(t, s.DueSLAs(slas, t0.Add(20*time.Second)), 1)
+	assert.True(t, s.IsDue("sla1", "fast"))
+	assert.True(t, s.IsDue("sla1", "slow"))
+
+	// no guarantee due
+	assert.Empty(t, s.DueSLAs(slas, t0.Add(21*time.Second)))
+	assert.False(t, s.IsDue("sla1", "fast"))
+}
+
+func TestSchedulerReload(t *testing.T) {
+	s := NewScheduler(30 * time.Second)
+	t0 := time.Now()
+
+	// without SLAs, the SLAs are loaded every global period
+	s.DueSLAs(model.SLAs{}, t0)
+	assert.False(t, s.Reload(t0.Add(10*time.Second)))
+	assert.True(t, s.Reload(t0.Add(30*time.Second)))
+
+	// or as soon as they change
+	s.Changed()
+	assert.True(t, s.Reload(t0.Add(10*time.Second)))
+	s.DueSLAs(model.SLAs{}, t0.Add(10*time.Second))
+	assert.False(t, s.Reload(t0.Add(11*time.Second)))
+}
+
+func TestSchedulerDiscarded(t *testing.T) {
+	s := NewScheduler(30 * time.Second)
+	slas := model.SLAs{*newScheduledSLA()}
+	t0 := time.Now()
+
+	s.DueSLAs(slas, t0)
+	s.DueSLAs(slas, t0.Add(5*time.Second))
+	assert.True(t, s.IsDue("sla1", "fast"))
+	assert.False(t, s.IsDue("sla1", "slow"))
+
+	// the results of the fast guarantee are discarded: it is due again in the next tick
+	s.Discarded("sla1")
+	assert.True(t, s.Reload(t0.Add(6*time.Second)))
+	assert.Len(t, s.DueSLAs(slas, t0.Add(6*time.Second)), 1)
+	assert.True(t, s.IsDue("sla1", "fast"))
+	assert.False(t, s.IsDue("sla1", "slow"))
+
+	// stored results: the guarantee is not due until its next period
+	assert.False(t, s.Reload(t0.Add(7*time.Second)))
+	assert.Empty(t, s.DueSLAs(slas, t0.Add(7*time.Second)))
+	assert.True(t, s.Reload(t0.Add(11*time.Second)))
+}
+
+func TestAssessScheduledGuaranteesConflict(t *testing.T) {
+	mem, _ := memrepository.New()
+	repo := conflictRepository{mem}
+	sla := newScheduledSLA()
+	_, err := repo.CreateSLA(sla)
+	assert.NoError(t, err)
+
+	t0 := time.Now()
+	s := NewScheduler(30 * time.Second)
+	cfg := Config{Now: t0, Repo: repo, Adapter: fixedAdapter{value: 10, now: t0}, Scheduler: s}
+
+	// the update is discarded: the guarantees are not skipped until their next period
+	s.DueSLAs(model.SLAs{*sla}, t0)
+	assert.Nil(t, assessSLA(context.Background(), sla, cfg))
+	assert.True(t, s.Reload(t0.Add(time.Second)))
+	assert.Len(t, s.DueSLAs(model.SLAs{*sla}, t0.Add(time.Second)), 1)
+	assert.True(t, s.IsDue("sla1", "fast"))
+	assert.True(t, s.IsDue("sla1", "slow"))
+}
+
+func TestAssessScheduledGuarantees(t *testing.T) {
+	mem, _ := memrepository.New()
+	loads := 0
+	repo := countingRepository{mem, &loads}
+	_, err := repo.CreateSLA(newScheduledSLA())
+	assert.NoError(t, err)
+
+	t0 := time.Now()
+	cfg := Config{Repo: repo, Scheduler: NewScheduler(30 * time.Second)}
+	assess := func(now time.Time) model.SLA {
+		cfg.Now = now
+		cfg.Adapter = fixedAdapter{value: 10, now: now}
+		AssessActiveQoSDefinitions(cfg)
+		sla, err := mem.GetSLA("sla1")
+		assert.NoError(t, err)
+		return *sla
+	}
+
+	sla := assess(t0)
+	assert.Equal(t, 1, loads)
+	assert.Equal(t, model.ASSESSMENT_LEVEL_BROKEN, sla.Assessment.GetGuarantee("fast").Level)
+	assert.Equal(t, model.ASSESSMENT_LEVEL_BROKEN, sla.Assessment.GetGuarantee("slow").Level)
+
+	// nothing due: the SLAs are not loaded
+	assess(t0.Add(time.Second))
+	assert.Equal(t, 1, loads)
+
+	// only the fast guarantee is evaluated; the slow guarantee keeps its assessment
+	sla = assess(t0.Add(5 * time.Second))
+	assert.Equal(t, 2, loads)
+	fast := sla.Assessment.GetGuarantee("fast")
+	slow := sla.Assessment.GetGuarantee("slow")
+	assert.Equal(t, model.ASSESSMENT_LEVEL_CRITICAL, fast.Level)
+	assert.Equal(t, 2, fast.XCounter)
+	assert.Equal(t, model.ASSESSMENT_LEVEL_BROKEN, slow.Level)
+	assert.Equal(t, 1, slow.XCounter)
+	assert.True(t, slow.LastExecution.Equal(t0))
+	assert.Equal(t, model.ASSESSMENT_LEVEL_CRITICAL, sla.Assessment.Level)
+}
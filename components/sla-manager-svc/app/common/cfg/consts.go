@@ -68,6 +68,13 @@ const (
 	DefaultContextZenohEndpoint      string = "http://localhost:8000"
 	ContextZenohContextsPropertyName string = "CONTEXT_ZENOH_CONTEXTS"
 	DefaultContextZenohContexts      string = "colmena/contexts"
+	// ContextZenohModePropertyName is the name of the property that sets how the contexts are read from Zenoh:
+	// "poll" (query of all the contexts in each check period) or "subscribe" (Server-Sent Events subscription to the
+	// contexts of the agent)
+	ContextZenohModePropertyName string = "CONTEXT_ZENOH_MODE"
+	DefaultContextZenohMode      string = ContextZenohPollMode
+	ContextZenohSubscribeMode    string = "subscribe"
+	ContextZenohPollMode         string = "poll"
 
 	// Monitoring
 	// MonitoringAdapterPropertyName is the name of the property monitoring adater type
@@ -92,6 +99,28 @@ const (
 	// to raise a violation for the same guarantee term
 	DefaultTransientTime time.Duration = 0
 
+	// HistorySizePropertyName is the name of the property that holds the maximum number of
+	// assessment results kept in the KPI history of each SLA
+	HistorySizePropertyName string = "HISTORY_SIZE"
+	// DefaultHistorySize is the default maximum number of points of the KPI history of each SLA
+	DefaultHistorySize string = "1000"
+
+	// ViolationsSizePropertyName is the name of the property that holds the maximum number of
+	// violations kept per SLA by the memory repository
+	ViolationsSizePropertyName string = "VIOLATIONS_SIZE"
+	// DefaultViolationsSize is the default maximum number of violations kept per SLA by the memory repository
+	DefaultViolationsSize string = "1000"
+
+	// AssessmentWorkersPropertyName is the name of the property that holds the maximum number of SLAs assessed in parallel
+	AssessmentWorkersPropertyName string = "ASSESSMENT_WORKERS"
+	// DefaultAssessmentWorkers is the default maximum number of SLAs assessed in parallel
+	DefaultAssessmentWorkers string = "4"
+
+	// AssessmentTimeoutPropertyName is the name of the property that holds the maximum duration of the assessment of a SLA
+	AssessmentTimeoutPropertyName string = "ASSESSMENT_TIMEOUT"
+	// DefaultAssessmentTimeout is the default maximum duration of the assessment of a SLA
+	DefaultAssessmentTimeout string = "15s"
+
 	// Assessment
 	ASSESSMENT_X string = "ASSESSMENT_X"
 	ASSESSMENT_Y string = "ASSESSMENT_Y"
@@ -21,6 +21,8 @@ package common
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 /*
@@ -49,3 +51,25 @@ func GetIntEnv(name string, defaultval int) int {
 	}
 	return int(num)
 }
+
+/*
+ParseDuration parses a duration (e.g. "30s", "5m"), a (decimal) number of days (e.g. "30d") or a (decimal) number of
+seconds (e.g. "30", "0.5")
+*/
+func ParseDuration(value string) (time.Duration, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return d, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	secs, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
@@ -0,0 +1,291 @@
+/*
+Copyright © 2024 EVIDEN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+This work has been implemented within the context of COLMENA project.
+*/
+package expressions
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+/*
+Compound constraints combine several comparisons with '&&', '||' and parentheses. The metric queries of a compound
+constraint must be written between brackets (terms), e.g.:
+
+	([avg(cpu)] > 0.9 && [avg(latency)] > 2) || [errors] > 0
+
+Grammar:
+
+	expr       := and ( '||' and )*
+	and        := unary ( '&&' unary )*
+	unary      := '(' expr ')' | comparison
+	comparison := TERM OPERATOR ( VALUE | TERM )
+*/
+
+// kinds of tokens of a compound constraint
+const (
+	tokTerm = iota
+	tokValue
+	tokOperator
+	tokLogic
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind int
+	text string
+}
+
+// comparison is a comparison of a compound constraint: '<term> <operator> <value>'
+type comparison struct {
+	term     string
+	operator string
+	value    string
+}
+
+func (c comparison) String() string {
+	return c.term + " " + c.operator + " " + c.value
+}
+
+/*
+IsCompound returns true if the constraint combines several comparisons ('&&' or '||' out of the brackets and quotes)
+*/
+func IsCompound(constraint string) bool {
+	depth := 0
+	for i := 0; i < len(constraint); i++ {
+		switch c := constraint[i]; c {
+		case '"', '\'', '`':
+			i = skipQuoted(constraint, i)
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '&', '|':
+			if depth == 0 && i+1 < len(constraint) && constraint[i+1] == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// skipQuoted returns the position of the quote that closes the string that starts at position i of s (or the end of s)
+func skipQuoted(s string, i int) int {
+	quote := s[i]
+	for j := i + 1; j < len(s); j++ {
+		if s[j] == '\\' && quote != '`' {
+			j++
+		} else if s[j] == quote {
+			return j
+		}
+	}
+	return len(s)
+}
+
+/*
+parseCompound parses a compound constraint, and returns the constraint rebuilt with the terms transformed by the
+function term, and its comparisons
+*/
+func parseCompound(constraint string, term func(string) (string, error)) (string, []comparison, error) {
+	return runParser(constraint, &constraintParser{term: term})
+}
+
+// runParser parses a compound constraint with the parser p (see parseCompound)
+func runParser(constraint string, p *constraintParser) (string, []comparison, error) {
+	tokens, err := tokenize(constraint)
+	if err != nil {
+		return "", nil, err
+	}
+	p.tokens = tokens
+	res, err := p.expr()
+	if err != nil {
+		return "", nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return "", nil, errors.New("not valid expression: unexpected '" + p.tokens[p.pos].text + "'")
+	}
+	return res, p.comparisons, nil
+}
+
+// tokenize splits a compound constraint in tokens
+func tokenize(expr string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '[':
+			depth := 0
+			j := i
+			for ; j < len(expr); j++ {
+				if expr[j] == '"' || expr[j] == '\'' || expr[j] == '`' {
+					j = skipQuoted(expr, j)
+				} else if expr[j] == '[' {
+					depth++
+				} else if expr[j] == ']' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			if j >= len(expr) {
+				return nil, errors.New("not valid expression: no final bracket found in '" + expr[i:] + "'")
+			}
+			tokens = append(tokens, token{tokTerm, expr[i : j+1]})
+			i = j + 1
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")"})
+			i++
+		case strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, token{tokLogic, expr[i : i+2]})
+			i += 2
+		default:
+			if op := operatorAt(expr[i:]); op != "" {
+				tokens = append(tokens, token{tokOperator, op})
+				i += len(op)
+				continue
+			}
+			j := i
+			for j < len(expr) && !strings.ContainsRune(" \t\n()[]&|<>=!", rune(expr[j])) {
+				j++
+			}
+			if j == i {
+				return nil, errors.New("not valid expression: unexpected '" + string(c) + "'")
+			}
+			tokens = append(tokens, token{tokValue, expr[i:j]})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// operatorAt returns the comparison operator at the beginning of s, or "" if none
+func operatorAt(s string) string {
+	for _, op := range []string{"==", "<=", ">=", "!=", "<", ">"} {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// constraintParser is a recursive descent parser of compound constraints; term transforms the terms of the comparisons,
+// and threshold (if set) replaces the value of the first comparison
+type constraintParser struct {
+	tokens      []token
+	pos         int
+	term        func(string) (string, error)
+	threshold   string
+	comparisons []comparison
+}
+
+func (p *constraintParser) next() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, true
+}
+
+func (p *constraintParser) peek(kind int, text string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == kind && p.tokens[p.pos].text == text
+}
+
+func (p *constraintParser) expr() (string, error) {
+	res, err := p.and()
+	for err == nil && p.peek(tokLogic, "||") {
+		p.pos++
+		var right string
+		right, err = p.and()
+		res += " || " + right
+	}
+	return res, err
+}
+
+func (p *constraintParser) and() (string, error) {
+	res, err := p.unary()
+	for err == nil && p.peek(tokLogic, "&&") {
+		p.pos++
+		var right string
+		right, err = p.unary()
+		res += " && " + right
+	}
+	return res, err
+}
+
+func (p *constraintParser) unary() (string, error) {
+	if p.peek(tokLParen, "(") {
+		p.pos++
+		res, err := p.expr()
+		if err != nil {
+			return "", err
+		}
+		if !p.peek(tokRParen, ")") {
+			return "", errors.New("not valid expression: no closing parenthesis found")
+		}
+		p.pos++
+		return "(" + res + ")", nil
+	}
+	return p.comparison()
+}
+
+func (p *constraintParser) comparison() (string, error) {
+	term, ok := p.next()
+	if !ok || term.kind != tokTerm {
+		return "", errors.New("not valid expression: the metric queries of a compound constraint must be written between brackets, e.g. \"[avg(cpu)] > 0.9 && [avg(latency)] > 2\"")
+	}
+	op, ok := p.next()
+	if !ok || op.kind != tokOperator {
+		return "", errors.New("no operator found after '" + term.text + "'. Valid operators: '==', '<=', '>=', '!=', '<', '>'")
+	}
+	value, ok := p.next()
+	if !ok || (value.kind != tokValue && value.kind != tokTerm) {
+		return "", errors.New("no value found after '" + term.text + " " + op.text + "'")
+	}
+	if value.kind == tokValue {
+		if _, err := strconv.ParseFloat(value.text, 64); err != nil {
+			return "", errors.New("not valid value '" + value.text + "': a number is expected")
+		}
+	}
+
+	c := comparison{term: term.text, operator: op.text, value: value.text}
+	var err error
+	if c.term, err = p.term(c.term); err != nil {
+		return "", err
+	}
+	if value.kind == tokTerm {
+		if c.value, err = p.term(c.value); err != nil {
+			return "", err
+		}
+	}
+	if len(p.comparisons) == 0 && len(p.threshold) > 0 {
+		if value.kind != tokValue {
+			return "", errors.New("the first comparison of '" + term.text + "' has no threshold: a number is expected")
+		}
+		c.value = p.threshold
+	}
+	p.comparisons = append(p.comparisons, c)
+	return c.String(), nil
+}
@@ -0,0 +1,143 @@
+package expressions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// identityTerm returns the terms as they are
+func identityTerm(term string) (string, error) {
+	return term, nil
+}
+
+func TestIsCompound(t *testing.T) {
+	for _, tc := range []struct {
+		constraint string
+		compound   bool
+	}{
+		{`[cpu] > 0.9`, false},
+		{`[cpu] > 0.9 && [mem] > 0.8`, true},
+		{`[cpu] > 0.9 || [mem] > 0.8`, true},
+		{`[cpu{job="a&&b"}] > 0.9`, false},
+		{`[cpu{job='a||b'}] > 0.9`, false},
+		{`[cpu{job="a]&&b"}] > 0.9`, false},
+		{`[cpu and on(job) mem] > 0.9`, false},
+		{`[cpu[5m] & 1] > 0.9`, false},
+	} {
+		assert.Equal(t, tc.compound, IsCompound(tc.constraint), tc.constraint)
+	}
+}
+
+func TestParseCompound(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		constraint  string
+		expected    string
+		comparisons []string
+	}{
+		{
+			name:        "single comparison",
+			constraint:  `[avg(cpu)] > 0.9`,
+			expected:    `[avg(cpu)] > 0.9`,
+			comparisons: []string{`[avg(cpu)] > 0.9`},
+		},
+		{
+			name:        "precedence",
+			constraint:  `[a] > 1 || [b] > 2 && [c] > 3`,
+			expected:    `[a] > 1 || [b] > 2 && [c] > 3`,
+			comparisons: []string{`[a] > 1`, `[b] > 2`, `[c] > 3`},
+		},
+		{
+			name:        "parentheses",
+			constraint:  `([avg(cpu)] > 0.9 && [avg(latency)] > 2) || [errors] > 0`,
+			expected:    `([avg(cpu)] > 0.9 && [avg(latency)] > 2) || [errors] > 0`,
+			comparisons: []string{`[avg(cpu)] > 0.9`, `[avg(latency)] > 2`, `[errors] > 0`},
+		},
+		{
+			name:        "nested parentheses",
+			constraint:  `(([a] > 1 || ([b] < 2)) && [c] != 3)`,
+			expected:    `(([a] > 1 || ([b] < 2)) && [c] != 3)`,
+			comparisons: []string{`[a] > 1`, `[b] < 2`, `[c] != 3`},
+		},
+		{
+			name:        "spacing",
+			constraint:  "[a]>=1&&\n\t[b]<=2",
+			expected:    `[a] >= 1 && [b] <= 2`,
+			comparisons: []string{`[a] >= 1`, `[b] <= 2`},
+		},
+		{
+			name:        "brackets in terms",
+			constraint:  `[avg_over_time(cpu[5m])] > 0.9 && [rate(errors[1m])] == 0`,
+			expected:    `[avg_over_time(cpu[5m])] > 0.9 && [rate(errors[1m])] == 0`,
+			comparisons: []string{`[avg_over_time(cpu[5m])] > 0.9`, `[rate(errors[1m])] == 0`},
+		},
+		{
+			name:        "quoted bracket",
+			constraint:  `[up{job="a]b"}] == 1 && [cpu] < 2`,
+			expected:    `[up{job="a]b"}] == 1 && [cpu] < 2`,
+			comparisons: []string{`[up{job="a]b"}] == 1`, `[cpu] < 2`},
+		},
+		{
+			name:        "quoted logical operators",
+			constraint:  `[up{job="a&&b"}] == 1 || [up{job='c||d'}] == 1`,
+			expected:    `[up{job="a&&b"}] == 1 || [up{job='c||d'}] == 1`,
+			comparisons: []string{`[up{job="a&&b"}] == 1`, `[up{job='c||d'}] == 1`},
+		},
+		{
+			name:        "escaped quote",
+			constraint:  `[up{job="a\"]"}] == 1 && [cpu] < 2`,
+			expected:    `[up{job="a\"]"}] == 1 && [cpu] < 2`,
+			comparisons: []string{`[up{job="a\"]"}] == 1`, `[cpu] < 2`},
+		},
+		{
+			name:        "term vs term",
+			constraint:  `[cpu] > [limit] && [mem] < 0.8`,
+			expected:    `[cpu] > [limit] && [mem] < 0.8`,
+			comparisons: []string{`[cpu] > [limit]`, `[mem] < 0.8`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res, comparisons, err := parseCompound(tc.constraint, identityTerm)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, res)
+			terms := make([]string, 0, len(comparisons))
+			for _, c := range comparisons {
+				terms = append(terms, c.String())
+			}
+			assert.Equal(t, tc.comparisons, terms)
+		})
+	}
+}
+
+func TestParseCompoundErrors(t *testing.T) {
+	for _, tc := range []struct {
+		constraint string
+		err        string
+	}{
+		{`[a] > 1 && [b > 2`, `not valid expression: no final bracket found in '[b > 2'`},
+		{`([a] > 1 && [b] > 2`, `not valid expression: no closing parenthesis found`},
+		{`[a] > 1) && [b] > 2`, `not valid expression: unexpected ')'`},
+		{`[a] > 1 && b > 2`, `not valid expression: the metric queries of a compound constraint must be written between brackets, e.g. "[avg(cpu)] > 0.9 && [avg(latency)] > 2"`},
+		{`[a] 1 && [b] > 2`, `no operator found after '[a]'. Valid operators: '==', '<=', '>=', '!=', '<', '>'`},
+		{`[a] > && [b] > 2`, `no value found after '[a] >'`},
+		{`[a] > x && [b] > 2`, `not valid value 'x': a number is expected`},
+		{`[a] > 1 && [b] > 2 [c]`, `not valid expression: unexpected '[c]'`},
+		{`[a] > 1 &&`, `not valid expression: the metric queries of a compound constraint must be written between brackets, e.g. "[avg(cpu)] > 0.9 && [avg(latency)] > 2"`},
+		{`[a] > 1 # [b] > 2`, `not valid expression: unexpected '#'`},
+	} {
+		_, _, err := parseCompound(tc.constraint, identityTerm)
+		assert.EqualError(t, err, tc.err, tc.constraint)
+	}
+}
+
+func TestSetThresholdFirstComparison(t *testing.T) {
+	p := &constraintParser{term: identityTerm, threshold: "3"}
+	res, _, err := runParser(`[a] < 1 && [b] == 0`, p)
+	assert.NoError(t, err)
+	assert.Equal(t, `[a] < 3 && [b] == 0`, res)
+
+	p = &constraintParser{term: identityTerm, threshold: "3"}
+	_, _, err = runParser(`[a] < [b] && [c] == 0`, p)
+	assert.EqualError(t, err, `the first comparison of '[a]' has no threshold: a number is expected`)
+}
@@ -20,162 +20,207 @@ package expressions
 import (
 	"colmena/sla-management-svc/app/common/logs"
 	"errors"
+	"sort"
+	"strconv"
 	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
 )
 
 // path used in logs
 const pathLOG string = "SLA > Common "
 
+// LABEL_MARK was the mark of the position of the context labels in the queries of the SLAs stored by previous versions.
+// The labels are now injected in the vector selectors of the queries (see InjectLabels), and the mark is ignored.
 const LABEL_MARK = "#LABELS#"
 
 /*
-Returns an array containg the expression '<metrics_query> <operator> <value>'
-arr[0] = <metrics_query>
-arr[1] = <operator>
-arr[2] = <value>
+CheckAndParseConstraint checks the syntax of a KPI query, and returns the constraint (with the metric queries between
+brackets, in PromQL canonical format) and the threshold (the value of the first comparison). The metric queries are
+parsed with the Prometheus PromQL parser:
+
+	from "avg_over_time(processing_time[5s]) < 1"
+	to "[avg_over_time(processing_time[5s])] < 1"
+
+Compound constraints (see IsCompound) are parsed term by term:
+
+	from "([avg(cpu)] > 0.9 && [avg(latency)] > 2) || [errors] > 0"
+	to "([avg(cpu)] > 0.9 && [avg(latency)] > 2) || [errors] > 0"
 */
-func getContraintParts(expr string) ([]string, error) {
-	operators_arr := [6]string{"==", "<=", ">=", "!=", "<", ">"}
-	for _, op := range operators_arr {
-		if pos := strings.Index(expr, op); pos != -1 {
-			arr := strings.Split(expr, string(op))
-			if len(arr) != 2 {
-				return nil, errors.New("not valid expression. Expression format: \"<metrics_query> <operator> <value>\"")
-			} else {
-				var res = make([]string, 3)
-				res[0] = strings.Trim(arr[0], " ")
-				res[1] = string(op)
-				res[2] = strings.Trim(arr[1], " ")
-				return res, nil
-			}
-		}
+func CheckAndParseConstraint(constraint string) (string, string, error) {
+	logs.GetLogger().Debug(pathLOG + "[CheckAndParseConstraint] Checking expression: " + constraint)
+
+	res, comparisons, err := parseConstraint(constraint, canonicalTerm)
+	if err != nil {
+		logs.GetLogger().Error(pathLOG+"[CheckAndParseConstraint] ", err)
+		return "", "", err
 	}
-	return nil, errors.New("no operator found. Valid operators: '==', '<=', '>=', '!=', '<', '>'. Expression format: \"<metrics_query> <operator> <value>\"")
+	logs.GetLogger().Debug(pathLOG + "[CheckAndParseConstraint] " + res)
+	return res, comparisons[0].value, nil
 }
 
 /*
-Insert mark for labels before time interval
+InjectLabels adds the label matchers (label name: value) to every vector selector of the metric queries of a
+constraint (replacing the matchers of the same labels, if any). E.g., with {"company_premises_building": "Red"}:
+
+	from "[avg_over_time(processing_time[5s])] < 1 && [up] == 1"
+	to "[avg_over_time(processing_time{company_premises_building=\"Red\"}[5s])] < 1 && [up{company_premises_building=\"Red\"}] == 1"
 */
-func setLabelsMark(metrics_query string) (string, error) {
-	logs.GetLogger().Debug(pathLOG + "[setLabelsMark] " + metrics_query)
-	posBracketIni := strings.Index(metrics_query, "[")
-
-	totalInitBrackets := countOccurrences(metrics_query, '[')
-	if posBracketIni > 0 && totalInitBrackets == 1 {
-		// ej. "avg_over_time(processing_time[5s])"
-		logs.GetLogger().Debug(pathLOG + "[setLabelsMark] case 1")
-		return "[" + strings.Replace(metrics_query, "[", LABEL_MARK+"[", 1) + "]", nil
-	} else if posBracketIni == 0 && totalInitBrackets == 1 {
-		// ej. "[go_memstats_frees_total]"
-		logs.GetLogger().Debug(pathLOG + "[setLabelsMark] case 2")
-		return strings.Replace(metrics_query, "]", LABEL_MARK+"]", 1), nil
-	} else if posBracketIni == 0 && totalInitBrackets == 2 && strings.HasSuffix(metrics_query, "]") {
-		// ej. "[avg_over_time(processing_time[5s])]"
-		logs.GetLogger().Debug(pathLOG + "[setLabelsMark] case 3")
-		return "[" + strings.Replace(metrics_query[1:], "[", LABEL_MARK+"[", 1), nil
-	} else if posBracketIni < 0 {
-		// ej. No '[', ']' ==>  ')'
-		posParenthEnd := strings.Index(metrics_query, ")")
-		if posParenthEnd > 0 {
-			logs.GetLogger().Debug(pathLOG + "[setLabelsMark] case 4: " + "[" + strings.Replace(metrics_query, ")", LABEL_MARK+")", 1))
-			return "[" + strings.Replace(metrics_query, ")", LABEL_MARK+")", 1), nil
-		} else {
-			logs.GetLogger().Debug(pathLOG + "[setLabelsMark] case 5: " + "[" + metrics_query + LABEL_MARK + "]")
-			return "[" + metrics_query + LABEL_MARK + "]", nil
+func InjectLabels(constraint string, matchers map[string]string) (string, error) {
+	names := make([]string, 0, len(matchers))
+	for name := range matchers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	inject := func(term string) (string, error) {
+		expr, err := parseTerm(term)
+		if err != nil {
+			return "", err
 		}
+		parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+			vs, ok := node.(*parser.VectorSelector)
+			if !ok {
+				return nil
+			}
+			for _, name := range names {
+				lms := vs.LabelMatchers[:0]
+				for _, lm := range vs.LabelMatchers {
+					if lm.Name != name {
+						lms = append(lms, lm)
+					}
+				}
+				vs.LabelMatchers = append(lms, labels.MustNewMatcher(labels.MatchEqual, name, matchers[name]))
+			}
+			return nil
+		})
+		return "[" + expr.String() + "]", nil
 	}
 
-	logs.GetLogger().Error(pathLOG + "no valid expression")
-	return "", errors.New("not valid 'metrics_query' expression. Expression format: '[<expression>]' or '<expression>[interval]' or <expression> ")
+	res, _, err := parseConstraint(strings.ReplaceAll(constraint, LABEL_MARK, ""), inject)
+	return res, err
 }
 
 /*
-get total ocurrences of a character in a string
+SetThreshold replaces the threshold (the value of the first comparison) of a constraint:
+
+	from "[avg(latency)] < 1 && [errors] == 0", with threshold 3
+	to "[avg(latency)] < 3 && [errors] == 0"
 */
-func countOccurrences(s string, char rune) int {
-	count := 0
-	for _, c := range s {
-		if c == char {
-			count++
-		}
+func SetThreshold(constraint string, threshold float64) (string, error) {
+	identity := func(term string) (string, error) {
+		return term, nil
+	}
+
+	// constraints are rebuilt with the terms between brackets
+	res, _, err := parseConstraint(constraint, identity)
+	if err != nil {
+		return "", err
 	}
-	return count
+	res, _, err = runParser(res, &constraintParser{term: identity, threshold: strconv.FormatFloat(threshold, 'f', -1, 64)})
+	return res, err
 }
 
 /*
-Objective:
+EncodeConstraint encodes the brackets inside the metric queries of a constraint (https://www.w3schools.com/tags/ref_urlencode.asp),
+so that each metric query is a variable of a govaluate expression:
 
-	from "avg_over_time(processing_time[5s]) < 1"
-	to "[avg_over_time(processing_time#LABELS#[5s])] < 1"
-	where #LABELS# will be repalced by {building='BSC'}
+	from "[avg_over_time(go_goroutines[60m])] < 50000"
+	to "[avg_over_time(go_goroutines%5B60m%5D)] < 50000"
 */
-func CheckAndParseConstraint(constraint string) (string, string, error) {
-	logs.GetLogger().Debug(pathLOG + "[CheckAndParseConstraint] Checking expression: " + constraint)
-	res, err := getContraintParts(constraint)
+func EncodeConstraint(constraint string) (string, error) {
+	res, _, err := parseConstraint(constraint, func(term string) (string, error) {
+		inner := term[1 : len(term)-1]
+		inner = strings.ReplaceAll(inner, "[", "%5B")
+		inner = strings.ReplaceAll(inner, "]", "%5D")
+		return "[" + inner + "]", nil
+	})
+	return res, err
+}
+
+/*
+ConstraintTerms returns the comparisons of a constraint (e.g. ["[a] > 1", "[b] < 2"] for "[a] > 1 && [b] < 2").
+*/
+func ConstraintTerms(constraint string) ([]string, error) {
+	_, comparisons, err := parseConstraint(constraint, func(term string) (string, error) {
+		return term, nil
+	})
 	if err != nil {
-		logs.GetLogger().Error(pathLOG+"[CheckAndParseConstraint] ", err)
-	} else {
-		logs.GetLogger().Debug(pathLOG + "[CheckAndParseConstraint] " + strings.Join(res, " // "))
-		res2, err := setLabelsMark(res[0])
-		if err != nil {
-			logs.GetLogger().Error(pathLOG+"[CheckAndParseConstraint] ", err)
-		} else {
-			logs.GetLogger().Debug(pathLOG + "[CheckAndParseConstraint] " + res2)
-			return res2 + " " + res[1] + " " + res[2], res[2], nil
-		}
+		return nil, err
+	}
+	res := make([]string, 0, len(comparisons))
+	for _, c := range comparisons {
+		res = append(res, c.String())
 	}
+	return res, nil
+}
 
-	return "", "", err
+/*
+parseConstraint parses a constraint, and returns the constraint rebuilt with the metric queries (terms) transformed by
+the function term, and its comparisons. Constraints that are not compound nor start with a bracket are parsed as a
+PromQL comparison, e.g. "avg_over_time(processing_time[5s]) < 1".
+*/
+func parseConstraint(constraint string, term func(string) (string, error)) (string, []comparison, error) {
+	expr := strings.TrimSpace(constraint)
+	if len(expr) == 0 {
+		return "", nil, errors.New("empty expression. Expression format: \"<metrics_query> <operator> <value>\"")
+	}
+	if IsCompound(expr) || strings.HasPrefix(expr, "[") || strings.HasPrefix(expr, "(") {
+		return parseCompound(expr, term)
+	}
+
+	c, err := parseComparison(expr)
+	if err != nil {
+		return "", nil, err
+	}
+	if c.term, err = term(c.term); err != nil {
+		return "", nil, err
+	}
+	return c.String(), []comparison{c}, nil
 }
 
 /*
- */
-func ParseConstraint(constraint string) (string, error) {
-	// example: "[avg_over_time(go_goroutines[60m])] < 50000"
-	logs.GetLogger().Debug(pathLOG + "[ParseConstraint] Checking and parsing constraint expression " + constraint + " ...")
-	logs.GetLogger().Debug(pathLOG + "[ParseConstraint] Constraint expression format: [expression] '<'/'='/'>' value")
+parseComparison parses a PromQL comparison of a metric query and a number, e.g. "avg_over_time(processing_time[5s]) < 1"
+*/
+func parseComparison(expr string) (comparison, error) {
+	node, err := parser.ParseExpr(expr)
+	if err != nil {
+		return comparison{}, errors.New("not valid expression: " + err.Error())
+	}
+	be, ok := node.(*parser.BinaryExpr)
+	if !ok || !be.Op.IsComparisonOperator() || be.ReturnBool {
+		return comparison{}, errors.New("no operator found. Valid operators: '==', '<=', '>=', '!=', '<', '>'. Expression format: \"<metrics_query> <operator> <value>\"")
+	}
+	value, ok := be.RHS.(*parser.NumberLiteral)
+	if !ok {
+		return comparison{}, errors.New("not valid expression: the value of the comparison must be a number. Expression format: \"<metrics_query> <operator> <value>\"")
+	}
+	return comparison{
+		term:     "[" + be.LHS.String() + "]",
+		operator: be.Op.String(),
+		value:    value.String(),
+	}, nil
+}
 
-	expr := strings.TrimSpace(constraint)
-	// example: "[avg_over_time(go_goroutines[60m])] < 50000"
-	logs.GetLogger().Debug(pathLOG + "[ParseConstraint] expr: " + expr)
-
-	posBracketIni := strings.Index(expr, "[")
-	if posBracketIni != 0 {
-		return "", errors.New("bad constraint expression: No initial bracket found on left side. \n" +
-			"Expected Constraint expression format: '['<expression>']' '<'/'='/'>' <value> \n" +
-			"Example: [avg_over_time(go_goroutines[60m])] < 50000") // ERROR
-	} else {
-		exprsubstring1 := expr[1:]
-		// example: "avg_over_time(go_goroutines[60m])] < 50000"
-		logs.GetLogger().Debug(pathLOG + "[ParseConstraint] exprsubstring1: " + exprsubstring1)
-
-		posBracketIni2 := strings.Index(exprsubstring1, "[")
-		if posBracketIni2 < 0 {
-			// example: "[avg_over_time(go_goroutines[60m])] < 50000"
-			return constraint, nil
-		} else {
-			posBracketEnd := strings.LastIndex(exprsubstring1, "]")
-			if posBracketEnd < 0 {
-				return "", errors.New("bad constraint expression: No final bracket found. \n" +
-					"Expected Constraint expression format: '['<expression>']' '<'/'='/'>' <value> \n" +
-					"Example: [avg_over_time(go_goroutines[60m])] < 50000") // ERROR
-			} else {
-
-				exprsubstring2 := exprsubstring1[0:posBracketEnd]
-				logs.GetLogger().Debug(pathLOG + "[ParseConstraint] exprsubstring2: " + exprsubstring2)
-
-				exprsubstring3 := exprsubstring1[posBracketEnd+1:]
-				logs.GetLogger().Debug(pathLOG + "[ParseConstraint] exprsubstring3: " + exprsubstring3)
-
-				// https://www.w3schools.com/tags/ref_urlencode.asp
-				exprsubstring2 = strings.ReplaceAll(exprsubstring2, "[", "%5B")
-				exprsubstring2 = strings.ReplaceAll(exprsubstring2, "]", "%5D")
-
-				exprStrFinal := "[" + exprsubstring2 + "]" + exprsubstring3
-				logs.GetLogger().Debug(pathLOG + "[ParseConstraint] exprStrFinal: " + exprStrFinal)
-
-				return exprStrFinal, nil
-			}
-		}
+// parseTerm parses the metric query (PromQL) of a term "[<metrics_query>]"
+func parseTerm(term string) (parser.Expr, error) {
+	query := strings.TrimSpace(term[1 : len(term)-1])
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return nil, errors.New("not valid metrics query '" + query + "': " + err.Error())
+	}
+	if expr.Type() == parser.ValueTypeString {
+		return nil, errors.New("not valid metrics query '" + query + "': a number or a series is expected")
+	}
+	return expr, nil
+}
+
+// canonicalTerm checks the metric query of a term, and returns the term with the query in PromQL canonical format
+func canonicalTerm(term string) (string, error) {
+	expr, err := parseTerm(term)
+	if err != nil {
+		return "", err
 	}
+	return "[" + expr.String() + "]", nil
 }
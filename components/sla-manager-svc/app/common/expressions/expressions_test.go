@@ -0,0 +1,126 @@
+package expressions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAndParseConstraint(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		constraint string
+		canonical  string
+		threshold  string
+	}{
+		{name: "single comparison",
+			constraint: `avg_over_time(processing_time[5s]) < 1`,
+			canonical:  `[avg_over_time(processing_time[5s])] < 1`, threshold: "1"},
+		{name: "bracketed term",
+			constraint: `[avg_over_time(processing_time[5s])]<1`,
+			canonical:  `[avg_over_time(processing_time[5s])] < 1`, threshold: "1"},
+		{name: "subquery",
+			constraint: `[max_over_time(rate(http_requests_total[5m])[30m:1m])] > 10`,
+			canonical:  `[max_over_time(rate(http_requests_total[5m])[30m:1m])] > 10`, threshold: "10"},
+		{name: "compound",
+			constraint: `[sum(rate(errors[5m])) / sum(rate(requests[5m]))] < 0.01 && [up] == 1`,
+			canonical:  `[sum(rate(errors[5m])) / sum(rate(requests[5m]))] < 0.01 && [up] == 1`, threshold: "0.01"},
+		{name: "term vs term",
+			constraint: `[cpu] > [limit]`,
+			canonical:  `[cpu] > [limit]`, threshold: "[limit]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			canonical, threshold, err := CheckAndParseConstraint(tc.constraint)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.canonical, canonical)
+			assert.Equal(t, tc.threshold, threshold)
+		})
+	}
+}
+
+func TestCheckAndParseConstraintErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		constraint string
+		err        string
+	}{
+		{name: "empty", constraint: ``, err: "empty expression"},
+		{name: "no operator", constraint: `up`, err: "no operator found"},
+		{name: "bool modifier", constraint: `sum(rate(x[5m])) > bool 1`, err: "no operator found"},
+		{name: "not a vector", constraint: `"a" < 1`, err: "binary expression must contain only scalar and instant vector types"},
+		{name: "value not a number", constraint: `rate(x[5m]) < up`, err: "the value of the comparison must be a number"},
+		{name: "quoted value", constraint: `[up] < "a"`, err: `not valid value '"a"'`},
+		{name: "unbalanced brackets", constraint: `[rate(x[5m)] < 1`, err: "no final bracket found"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := CheckAndParseConstraint(tc.constraint)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.err)
+			}
+		})
+	}
+}
+
+func TestInjectLabels(t *testing.T) {
+	matchers := map[string]string{"building": "Red", "agent": "a1"}
+	for _, tc := range []struct {
+		name       string
+		constraint string
+		expected   string
+	}{
+		{name: "single comparison",
+			constraint: `avg_over_time(processing_time[5s]) < 1`,
+			expected:   `[avg_over_time(processing_time{agent="a1",building="Red"}[5s])] < 1`},
+		{name: "compound",
+			constraint: `[avg_over_time(processing_time[5s])] < 1 && [up] == 1`,
+			expected:   `[avg_over_time(processing_time{agent="a1",building="Red"}[5s])] < 1 && [up{agent="a1",building="Red"}] == 1`},
+		{name: "subquery",
+			constraint: `[max_over_time(rate(http_requests_total[5m])[30m:1m])] > 10`,
+			expected:   `[max_over_time(rate(http_requests_total{agent="a1",building="Red"}[5m])[30m:1m])] > 10`},
+		{name: "multiple selectors, existing matchers replaced",
+			constraint: `[sum(rate(errors{job="x"}[5m])) / sum(rate(requests{building="Blue"}[5m]))] < 0.01`,
+			expected:   `[sum(rate(errors{agent="a1",building="Red",job="x"}[5m])) / sum(rate(requests{agent="a1",building="Red"}[5m]))] < 0.01`},
+		{name: "term vs term",
+			constraint: `[cpu] > [limit]`,
+			expected:   `[cpu{agent="a1",building="Red"}] > [limit{agent="a1",building="Red"}]`},
+		{name: "offset",
+			constraint: `[up offset 5m] == 1`,
+			expected:   `[up{agent="a1",building="Red"} offset 5m] == 1`},
+		{name: "label mark",
+			constraint: `[processing_time] < 1` + LABEL_MARK,
+			expected:   `[processing_time{agent="a1",building="Red"}] < 1`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := InjectLabels(tc.constraint, matchers)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestSetThreshold(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		constraint string
+		expected   string
+		err        string
+	}{
+		{name: "single comparison", constraint: `avg(latency) < 1`, expected: `[avg(latency)] < 2.5`},
+		{name: "first comparison", constraint: `[avg(latency)] < 1 && [errors] == 0`, expected: `[avg(latency)] < 2.5 && [errors] == 0`},
+		{name: "parenthesis", constraint: `([a] > 1 || [b] > 2)`, expected: `([a] > 2.5 || [b] > 2)`},
+		{name: "term vs term", constraint: `[a] > [b]`, err: "has no threshold"},
+		{name: "no value", constraint: `[a] <`, err: "no value found"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := SetThreshold(tc.constraint, 2.5)
+			if tc.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.err)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
@@ -18,10 +18,25 @@ This work has been implemented within the context of COLMENA project.
 
 package model
 
+import "time"
+
 const DEFAULT_ASSESSMENT_X = 2
 const DEFAULT_ASSESSMENT_Y = 2
 const DEFAULT_ASSESSMENT_Z = 5
 
+// default values of the "window" and "ewma" level policies
+const DEFAULT_LEVEL_WINDOW = 10
+const DEFAULT_LEVEL_CRITICAL_RATIO = 0.8
+const DEFAULT_LEVEL_ALPHA = 0.3
+
+// default compliance window and burn rate rules of the objectives
+const DEFAULT_OBJECTIVE_WINDOW = "30d"
+
+var DefaultBurnRates = []BurnRateRule{
+	{Name: "fast", LongWindow: "1h", ShortWindow: "5m", Factor: 14.4},
+	{Name: "slow", LongWindow: "6h", ShortWindow: "30m", Factor: 6},
+}
+
 /*
 Service definition (input model example):
 
@@ -85,9 +100,45 @@ type InputSLARole struct {
 	HardwareRequirements []interface{}     `json:"hardwareRequirements,omitempty"`
 }
 
+/*
+InputSLARoleKPI is a KPI of a role. Period (optional) is the time between evaluations of the KPI (a duration, e.g. "5s",
+or a number of seconds); the global check period is used if not set. Levels (optional) sets the level policy of the KPI, e.g.:
+
+	{
+		"query": "[go_memstats_frees_total] < 50000",
+		"scope": "",
+		"levels": {"critical_after": 3, "desired_after": 5, "unstable_after": 4}
+	}
+
+or, with a level policy different from the default one ("counter"):
+
+	"levels": {"policy": "window", "window": 20, "critical_ratio": 0.8}
+
+Objective (optional) turns the KPI into an SLO with an error budget, e.g. "the latency is under 200ms in 99% of the
+assessments over 30 days" (the levels are then set by the budget and the burn rate rules):
+
+	{
+		"query": "[request_latency_seconds] < 0.2",
+		"objective": {"target": 0.99, "window": "30d"}
+	}
+
+Thresholds (optional) sets the threshold of the KPI by the value bound to a label of the scope, e.g. 1s in building Red,
+3s in building Blue, and 2s (the threshold of the query) elsewhere:
+
+	{
+		"query": "[avg_over_time(latency_seconds[5m])] < 2",
+		"scope": "company_premises/building=.",
+		"thresholds": {"values": {"Red": 1, "Blue": 3}}
+	}
+*/
 type InputSLARoleKPI struct {
-	Query string `json:"query,omitempty"`
-	Scope string `json:"scope,omitempty"`
+	Query      string      `json:"query,omitempty"`
+	Scope      string      `json:"scope,omitempty"`
+	Levels     *Levels     `json:"levels,omitempty"`
+	Period     string      `json:"period,omitempty"`
+	Objective  *Objective  `json:"objective,omitempty"`
+	Thresholds *Thresholds `json:"thresholds,omitempty"`
+	Source     string      `json:"source,omitempty"`
 }
 
 /*
@@ -120,6 +171,11 @@ type ColmenaOutputKpis struct {
 	Level           string      `json:"level"`
 	Value 			interface{} `json:"value"`
 	Threshold       float64     `json:"threshold"`
+	ThresholdKey    string      `json:"thresholdKey,omitempty"`
+	BudgetRemaining *float64    `json:"budgetRemaining,omitempty"`
+	FailedTerms     []string    `json:"failedTerms,omitempty"`
+	Series          []OutputSeriesKpi `json:"series,omitempty"`         // KPIs with several series (e.g. one per instance of the role)
+	ViolatedSeries  int               `json:"violatedSeries,omitempty"` // number of series violated
 }
 
 
@@ -130,11 +186,200 @@ type OutputSLA struct {
 }
 
 type OutputSLAKpi struct {
-	RoleId          string      `json:"roleId"`
-	Query           string      `json:"query"`
-	Level           string      `json:"level"`
+	RoleId          string             `json:"roleId"`
+	Query           string             `json:"query"`
+	Level           string             `json:"level"`
+	Value           interface{}        `json:"value"`
+	Threshold       float64            `json:"threshold"`
+	ThresholdKey    string             `json:"threshold_key,omitempty"` // context thresholds: value bound whose threshold applied, or "default"
+	Violations      []Violation        `json:"violations,omitempty"`
+	TotalViolations int                `json:"total_violations"`
+	BudgetRemaining *float64           `json:"budget_remaining,omitempty"` // objectives: fraction of the error budget remaining
+	BurnRates       map[string]float64 `json:"burn_rates,omitempty"`       // objectives: burn rate of each window
+	Series          []OutputSeriesKpi  `json:"series,omitempty"`           // KPIs with several series (e.g. one per instance of the role)
+	ViolatedSeries  int                `json:"violated_series,omitempty"`  // number of series violated
+}
+
+/*
+Output model of a series of a KPI whose query returns several series (e.g. one per instance of the role). The level of
+the KPI is the aggregated (role-level) level. Example:
+
+	{
+		"labels": {"instance": "agent1:9100"},
+		"level": "Broken",
+		"value": 12.5,
+		"violated": true
+	}
+*/
+type OutputSeriesKpi struct {
+	Labels   map[string]string `json:"labels"`
+	Level    string            `json:"level"`
+	Value    interface{}       `json:"value"`
+	Violated bool              `json:"violated"`
+}
+
+/*
+Output model (VIOLATIONS PAGE) example:
+
+	{
+		"total": 25,
+		"offset": 0,
+		"limit": 10,
+		"violations": [...]
+	}
+*/
+type OutputViolations struct {
+	Total      int         `json:"total"`
+	Offset     int         `json:"offset"`
+	Limit      int         `json:"limit"`
+	Violations []Violation `json:"violations"`
+}
+
+/*
+Output model (KPI HISTORY) example:
+
+	{
+		"serviceId": "ExampleApplication_01",
+		"slaId": "ExampleApplication_01-f5tjRgFF9HZ5KbgznKamid",
+		"points": [
+			{
+				"timestamp": "2025-05-12T13:52:11.741Z",
+				"guarantee": "Processing",
+				"value": 239641613,
+				"threshold": 50000,
+				"level": "Critical",
+				"violated": true
+			}
+		]
+	}
+*/
+type OutputKPIHistory struct {
+	ServiceId string            `json:"serviceId"`
+	SLAId     string            `json:"slaId"`
+	Points    []KPIHistoryPoint `json:"points"`
+}
+
+// KPIHistoryPoint is the result of an assessment cycle of a guarantee of a SLA
+type KPIHistoryPoint struct {
+	Timestamp       time.Time   `json:"timestamp"`
+	Guarantee       string      `json:"guarantee"`
 	Value           interface{} `json:"value"`
 	Threshold       float64     `json:"threshold"`
-	Violations      []Violation `json:"violations,omitempty"`
-	TotalViolations int         `json:"total_violations"`
+	Level           string      `json:"level"`
+	Violated        bool        `json:"violated"`
+	BudgetRemaining *float64    `json:"budget_remaining,omitempty"`
+}
+
+/*
+Input model (DRY-RUN) example: a service definition and (optionally) synthetic values of the metrics used in the KPIs.
+If values are not provided, the metrics are retrieved from the monitoring adapter.
+
+	{
+		"id": {"value": "ExampleApplication_01"},
+		"dockerRoleDefinitions": [...],
+		"values": {"go_memstats_frees_total": 48000}
+	}
+*/
+type InputDryRun struct {
+	InputSLA
+	Values map[string]float64 `json:"values,omitempty"`
+}
+
+/*
+Output model (DRY-RUN) example:
+
+	{
+		"serviceId": "ExampleApplication_01",
+		"KPIs": [
+			{
+				"roleId": "Processing01",
+				"query": "[go_memstats_frees_total] < 50000",
+				"constraint": "[go_memstats_frees_total] < 50000",
+				"promql": ["go_memstats_frees_total"],
+				"threshold": 50000,
+				"values": [{"key": "go_memstats_frees_total", "value": 48000, ...}],
+				"violated": false,
+				"level": "Met"
+			}
+		]
+	}
+*/
+type OutputDryRun struct {
+	ServiceId string            `json:"serviceId"`
+	Kpis      []OutputDryRunKpi `json:"KPIs"`
+}
+
+type OutputDryRunKpi struct {
+	RoleId      string        `json:"roleId"`
+	Query       string        `json:"query"`
+	Constraint  string        `json:"constraint"`
+	PromQL      []string      `json:"promql"`
+	Scope       string        `json:"scope,omitempty"`
+	Threshold   float64       `json:"threshold"`
+	Values      []MetricValue `json:"values"`
+	Violated    bool          `json:"violated"`
+	Level       string        `json:"level"`
+	FailedTerms []string      `json:"failed_terms,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+
+/*
+Input model (BACKTEST) example: a service definition and the time range to replay. The KPIs are evaluated every step
+(duration or number of seconds) from "from" to "to" (default: now). If step is not set, the period of the KPIs is used.
+
+	{
+		"id": {"value": "ExampleApplication_01"},
+		"dockerRoleDefinitions": [...],
+		"from": "2025-05-21T17:00:00Z",
+		"to": "2025-05-21T18:00:00Z",
+		"step": "1m"
+	}
+*/
+type InputBacktest struct {
+	InputSLA
+	From time.Time  `json:"from"`
+	To   *time.Time `json:"to,omitempty"`
+	Step string     `json:"step,omitempty"`
+}
+
+/*
+Output model (BACKTEST) example:
+
+	{
+		"serviceId": "ExampleApplication_01",
+		"from": "2025-05-21T17:00:00Z",
+		"to": "2025-05-21T18:00:00Z",
+		"step": "1m0s",
+		"KPIs": [
+			{
+				"roleId": "Processing01",
+				"query": "[go_memstats_frees_total] < 50000",
+				"constraint": "[go_memstats_frees_total] < 50000",
+				"threshold": 50000,
+				"points": [
+					{"timestamp": "2025-05-21T17:00:00Z", "value": 48000, "violated": false, "level": "Met"},
+					{"timestamp": "2025-05-21T17:01:00Z", "value": 51000, "violated": true, "level": "Broken"},
+					...
+				],
+				"violations": [...]
+			}
+		]
+	}
+*/
+type OutputBacktest struct {
+	ServiceId string              `json:"serviceId"`
+	From      time.Time           `json:"from"`
+	To        time.Time           `json:"to"`
+	Step      string              `json:"step"`
+	Kpis      []OutputBacktestKpi `json:"KPIs"`
+}
+
+type OutputBacktestKpi struct {
+	RoleId     string            `json:"roleId"`
+	Query      string            `json:"query"`
+	Constraint string            `json:"constraint"`
+	Threshold  float64           `json:"threshold"`
+	Points     []KPIHistoryPoint `json:"points"`
+	Violations []Violation       `json:"violations"`
 }
@@ -25,7 +25,12 @@ package model
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	"colmena/sla-management-svc/app/common"
 )
 
 /**
@@ -97,6 +102,9 @@ var ErrNotFound = errors.New("Entity not found")
 // ErrAlreadyExist is the sentinel error for creating an entity whose id already exists
 var ErrAlreadyExist = errors.New("Entity already exists")
 
+// ErrConflict is the sentinel error for updating an entity that has been modified since it was read
+var ErrConflict = errors.New("Entity has been modified by another process")
+
 /*
  * ValidationErrors following behavioral errors
  * (https://dave.cheney.net/2016/04/27/dont-just-check-errors-handle-them-gracefully)
@@ -184,10 +192,14 @@ var States = [...]State{STOPPED, STARTED, TERMINATED}
 // SLA is the entity that represents a SLA definition.
 // The Text is ReadOnly in normal conditions, with the exception of a renegotiation.
 // The Assessment cannot be modified externally.
+//
+// Version is managed by the repository: it is incremented on every update, and an update
+// is rejected (ErrConflict) if the version of the SLA does not match the stored one.
 type SLA struct {
 	Id         string     `json:"id" bson:"_id"`
 	Name       string     `json:"name"`
 	State      State      `json:"state"`
+	Version    int64      `json:"version"`
 	Assessment Assessment `json:"assessment,omitempty"`
 	Creation   time.Time  `json:"creation,omitempty"`
 	Expiration *time.Time `json:"expiration,omitempty"`
@@ -220,14 +232,10 @@ type Assessment struct {
 
 		TODO: remove from json => `json:"-"`
 	*/
-	X              int                            `json:"x,omitempty"`                         // assessment violation; x (default 2)
-	XCounter       int                            `json:"x_assessment_broken_count,omitempty"` // assessment violation counter
-	Y              int                            `json:"y,omitempty"`                         // assessment met; y (default 2)
-	YCounter       int                            `json:"y_assessment_met_count,omitempty"`    // assessment met; y counter
-	Z              int                            `json:"z,omitempty"`                         // met to broken; z (default 5)
-	ZCounter       int                            `json:"z_met_to_broken_count,omitempty"`     // met to broken; z counter
-	Level          string                         `json:"level,omitempty"`                     // Broken, Critical, Met, Desired, Unstable, Unknown
-	Threshold      float64                        `json:"threshold,omitempty"`
+	X              int                            `json:"x,omitempty"`     // assessment violation; x (default 2)
+	Y              int                            `json:"y,omitempty"`     // assessment met; y (default 2)
+	Z              int                            `json:"z,omitempty"`     // met to broken; z (default 5)
+	Level          string                         `json:"level,omitempty"` // worst level of the guarantees: Broken, Critical, Met, Desired, Unstable, Unknown
 	Violated       bool                           `json:"violated,omitempty"`
 	FirstExecution time.Time                      `json:"first_execution"`
 	LastExecution  time.Time                      `json:"last_execution"`
@@ -244,27 +252,195 @@ const (
 	ASSESSMENT_LEVEL_MET       = "Met"
 	ASSESSMENT_LEVEL_CRITICAL  = "Critical"
 	ASSESSMENT_LEVEL_BROKEN    = "Broken"
+	ASSESSMENT_LEVEL_BURNING   = "Burning" // the error budget of an objective is burning too fast
 )
 
-// AssessmentGuarantee contain the assessment information for a guarantee term
+// levelSeverity sorts the assessment levels from the least to the most severe
+var levelSeverity = map[string]int{
+	ASSESSMENT_LEVEL_NORESULTS: 0,
+	ASSESSMENT_LEVEL_UNKNOWN:   1,
+	ASSESSMENT_LEVEL_DESIRED:   2,
+	ASSESSMENT_LEVEL_MET:       3,
+	ASSESSMENT_LEVEL_UNSTABLE:  4,
+	ASSESSMENT_LEVEL_BROKEN:    5,
+	ASSESSMENT_LEVEL_BURNING:   6,
+	ASSESSMENT_LEVEL_CRITICAL:  7,
+}
+
+// WorstLevel returns the most severe of two assessment levels
+func WorstLevel(a, b string) string {
+	if levelSeverity[b] > levelSeverity[a] {
+		return b
+	}
+	return a
+}
+
+// AssessmentGuarantee contain the assessment information for a guarantee term.
+// The level of each guarantee term is calculated with its own counters (see Assessment)
 type AssessmentGuarantee struct {
-	FirstExecution time.Time  `json:"first_execution"`
-	LastExecution  time.Time  `json:"last_execution"`
-	LastValues     LastValues `json:"last_values,omitempty"`
-	LastViolation  *Violation `json:"last_violation,omitempty"`
+	FirstExecution  time.Time    `json:"first_execution"`
+	LastExecution   time.Time    `json:"last_execution"`
+	LastValues      LastValues   `json:"last_values,omitempty"`
+	LastViolation   *Violation   `json:"last_violation,omitempty"`
+	XCounter        int          `json:"x_assessment_broken_count,omitempty"` // assessment violation counter
+	YCounter        int          `json:"y_assessment_met_count,omitempty"`    // assessment met; y counter
+	ZCounter        int          `json:"z_met_to_broken_count,omitempty"`     // met to broken; z counter
+	Level           string       `json:"level,omitempty"`                     // Broken, Critical, Met, Desired, Unstable, Unknown
+	Violated        bool         `json:"violated,omitempty"`
+	TotalViolations int          `json:"total_violations,omitempty"`
+	Window          []bool       `json:"window,omitempty"`    // last results (violated or not) used by the "window" level policy
+	Ewma            float64      `json:"ewma,omitempty"`      // smoothed violation ratio used by the "ewma" level policy
+	Budget          *ErrorBudget `json:"budget,omitempty"`    // error budget of the guarantees with an objective
+	Rebinding       *Rebinding   `json:"rebinding,omitempty"` // last change of the values bound to the scope
+
+	// Series contains the assessment of each series (by SeriesKey) of the guarantees whose queries return several
+	// series (e.g. one per instance of a role); the fields above are the aggregated (role-level) assessment
+	Series map[string]AssessmentGuarantee `json:"series,omitempty"`
+	Labels map[string]string              `json:"labels,omitempty"` // labels of the series (only in Series)
+}
+
+// Rebinding records a change of the values bound to the scope of a guarantee (e.g. the agent moved to another building)
+type Rebinding struct {
+	Time time.Time         `json:"time"`
+	From map[string]string `json:"from"`
+	To   map[string]string `json:"to"`
+}
+
+/*
+ErrorBudget is the error budget of a guarantee with an objective. Each assessment is a time slice, good if the constraint
+is met and bad otherwise; the slices are counted in buckets:
+
+  - Slices: coarse buckets covering the compliance window, used to calculate the remaining budget
+  - Recent: fine buckets covering the longest window of the burn rate rules, used to calculate the burn rates
+
+Remaining is the fraction of the budget not consumed in the compliance window (1: intact; 0 or less: exhausted).
+BurnRates contains the burn rate (consumption speed; 1 consumes the budget exactly in the compliance window) of each
+window of the burn rate rules. Burning is the name of the rule that fired in the last assessment, if any.
+*/
+type ErrorBudget struct {
+	Remaining float64            `json:"remaining"`
+	BurnRates map[string]float64 `json:"burn_rates,omitempty"`
+	Burning   string             `json:"burning,omitempty"`
+	Slices    []BudgetBucket     `json:"slices,omitempty"`
+	Recent    []BudgetBucket     `json:"recent,omitempty"`
+}
+
+// BudgetBucket counts the good and bad time slices of an error budget from Start
+type BudgetBucket struct {
+	Start time.Time `json:"start"`
+	Good  int       `json:"good"`
+	Bad   int       `json:"bad"`
 }
 
 // LastValues contain last values of variables in guarantee terms
 type LastValues map[string]MetricValue
 
-// Guarantee is the struct that represents an SLO
+// Guarantee is the struct that represents an SLO. Each KPI of a role is translated to a Guarantee.
+//
+// Name identifies the guarantee term in the SLA; Role is the role of the KPI (empty for service KPIs).
+// Levels (optional) overrides the level policy of the SLA for this guarantee term.
+// Period (optional) is the time between evaluations of the guarantee term (e.g. "5s"); see Guarantee.GetPeriod.
+// Objective (optional) makes the guarantee term an SLO over a compliance window, assessed with an error budget.
+// Bindings are the values of the labels of the Scope (see ParseScope) injected in the Constraint, by label name.
+// Thresholds (optional) sets the threshold of the Constraint by the value bound to a label; ThresholdKey is the value
+// whose threshold was applied (THRESHOLD_KEY_DEFAULT if none matched).
 type Guarantee struct {
-	Name          string `json:"name"`
-	Constraint    string `json:"constraint"`
-	Query         string `json:"query"`
-	OQuery        string `json:"oquery"`
-	Scope         string `json:"scope"`
-	ScopeTemplate string `json:"scopeTemplate"`
+	Name          string            `json:"name"`
+	Role          string            `json:"role"`
+	Constraint    string            `json:"constraint"`
+	Query         string            `json:"query"`
+	OQuery        string            `json:"oquery"`
+	Threshold     float64           `json:"threshold"`
+	Scope         string            `json:"scope"`
+	ScopeTemplate string            `json:"scopeTemplate"`
+	Bindings      map[string]string `json:"bindings,omitempty"`
+	Thresholds    *Thresholds       `json:"thresholds,omitempty"`
+	ThresholdKey  string            `json:"thresholdKey,omitempty"`
+	Levels        *Levels           `json:"levels,omitempty"`
+	Period        string            `json:"period,omitempty"`
+	Objective     *Objective        `json:"objective,omitempty"`
+	Source        string            `json:"source,omitempty"` // monitoring source pinned by the KPI (federated adapter)
+}
+
+/*
+Objective is the objective of a guarantee term over a compliance window: the constraint must be met in a ratio of the
+assessments (Target, e.g. 0.99) in the window (e.g. "30d"). The bad assessments allowed are the error budget.
+
+BurnRates are the rules that detect when the budget is burning too fast: a rule fires when the burn rate is at least
+Factor in both the long and the short windows (by default, "fast": 14.4 in 1h and 5m; "slow": 6 in 6h and 30m).
+*/
+type Objective struct {
+	Target    float64        `json:"target"`
+	Window    string         `json:"window,omitempty"`
+	BurnRates []BurnRateRule `json:"burn_rates,omitempty"`
+}
+
+// BurnRateRule is a multi-window burn rate rule of an objective
+type BurnRateRule struct {
+	Name        string  `json:"name"`
+	LongWindow  string  `json:"long_window"`
+	ShortWindow string  `json:"short_window"`
+	Factor      float64 `json:"factor"`
+}
+
+// THRESHOLD_KEY_DEFAULT is the ThresholdKey of a guarantee term when the default threshold was applied
+const THRESHOLD_KEY_DEFAULT = "default"
+
+/*
+Thresholds are the thresholds of a guarantee term that depend on the context: Values contains the threshold for each
+value bound to Label (a label of the scope, e.g. "company_premises_building"; by default, the first label bound by the
+scope), and Default the threshold used when the value is not in Values (by default, the threshold of the query):
+
+	{"label": "company_premises/building", "values": {"Red": 1, "Blue": 3}, "default": 2}
+*/
+type Thresholds struct {
+	Label   string             `json:"label,omitempty"`
+	Values  map[string]float64 `json:"values"`
+	Default *float64           `json:"default,omitempty"`
+}
+
+// Get returns the threshold for the values bound to the scope, and the key of the threshold (the value bound or
+// THRESHOLD_KEY_DEFAULT)
+func (t *Thresholds) Get(bindings map[string]string) (float64, string) {
+	if value, ok := bindings[t.Label]; ok {
+		if threshold, ok := t.Values[value]; ok {
+			return threshold, value
+		}
+	}
+	if t.Default == nil {
+		return 0, ""
+	}
+	return *t.Default, THRESHOLD_KEY_DEFAULT
+}
+
+// names of the level policies (see Levels)
+const (
+	LEVEL_POLICY_COUNTER = "counter"
+	LEVEL_POLICY_WINDOW  = "window"
+	LEVEL_POLICY_EWMA    = "ewma"
+)
+
+// IsLevelPolicy returns true if name is the name of a level policy. An empty name is the default policy ("counter")
+func IsLevelPolicy(name string) bool {
+	switch name {
+	case "", LEVEL_POLICY_COUNTER, LEVEL_POLICY_WINDOW, LEVEL_POLICY_EWMA:
+		return true
+	}
+	return false
+}
+
+// Levels is the level policy of a guarantee term (see Assessment). Zero values are taken from the SLA or set to default values.
+//
+// Policy is the name of the algorithm that calculates the level ("counter", "window", "ewma"; "counter" if empty).
+// The other fields are the parameters of the policies.
+type Levels struct {
+	Policy        string  `json:"policy,omitempty"`
+	CriticalAfter int     `json:"critical_after,omitempty"` // x: consecutive violations to set the Critical level
+	DesiredAfter  int     `json:"desired_after,omitempty"`  // y: consecutive fulfillments to set the Desired level
+	UnstableAfter int     `json:"unstable_after,omitempty"` // z: changes from met to broken to set the Unstable level
+	Window        int     `json:"window,omitempty"`         // window: number of executions considered
+	CriticalRatio float64 `json:"critical_ratio,omitempty"` // window, ewma: violation ratio to set the Critical level
+	Alpha         float64 `json:"alpha,omitempty"`          // ewma: smoothing factor, (0, 1]
 }
 
 // Aggregation gives aggregation information of a variable.
@@ -285,27 +461,61 @@ type GuaranteeType struct {
 
 // MetricValue is the SLA representation of a metric value.
 type MetricValue struct {
-	Key       string      `json:"key"`
-	Action    string      `json:"action"`
-	Namespace string      `json:"namespace"`
-	Value     interface{} `json:"value"`
-	DateTime  time.Time   `json:"datetime"`
+	Key       string            `json:"key"`
+	Action    string            `json:"action"`
+	Namespace string            `json:"namespace"`
+	Value     interface{}       `json:"value"`
+	DateTime  time.Time         `json:"datetime"`
+	Labels    map[string]string `json:"labels,omitempty"` // labels of the series of the value (e.g. the instance)
+	Source    string            `json:"source,omitempty"` // monitoring source that served the value (federated adapter)
+}
+
+// Clone returns a copy of the value whose labels are not shared
+func (v MetricValue) Clone() MetricValue {
+	v.Labels = cloneLabels(v.Labels)
+	return v
 }
 
 func (v MetricValue) String() string {
+	if len(v.Labels) > 0 {
+		return fmt.Sprintf("{Key: %s, Labels: %s, Value: %v, DateTime: %v}", v.Key, SeriesKey(v.Labels), v.Value, v.DateTime)
+	}
 	return fmt.Sprintf("{Key: %s, Value: %v, DateTime: %v}", v.Key, v.Value, v.DateTime)
 }
 
+// SeriesKey returns the key of a series from its labels (e.g. `{container="c1", instance="i1"}`), or "" if there are
+// no labels
+func SeriesKey(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+strconv.Quote(labels[name]))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
 // Violation is generated when a guarantee term is not fulfilled
 type Violation struct {
-	Id          string        `json:"id" bson:"_id"`
-	AgreementId string        `json:"agreement_id"`
-	Guarantee   string        `json:"guarantee"`
-	Datetime    time.Time     `json:"datetime"`
-	Constraint  string        `json:"constraint"`
-	Values      []MetricValue `json:"values"`
-	AppId       string        `json:"appID,omitempty"`
-	Description string        `json:"description,omitempty"`
+	Id          string            `json:"id" bson:"_id"`
+	AgreementId string            `json:"agreement_id"`
+	Guarantee   string            `json:"guarantee"`
+	Datetime    time.Time         `json:"datetime"`
+	Constraint  string            `json:"constraint"`
+	Values      []MetricValue     `json:"values"`
+	AppId       string            `json:"appID,omitempty"`
+	ServiceId   string            `json:"serviceId,omitempty"` // service (name of the SLA) of the violation
+	Description string            `json:"description,omitempty"`
+	FailedTerms []string          `json:"failed_terms,omitempty"` // comparisons not met, in compound constraints
+	Labels      map[string]string `json:"labels,omitempty"`       // series (e.g. instance) that violated the guarantee, in guarantees with several series
+	Source      string            `json:"source,omitempty"`       // monitoring source that served the values (federated adapter)
 }
 
 // SLAs is the type of an slice of SLA
@@ -393,11 +603,205 @@ func (t *Details) GetVariable(varname string) (result Variable, ok bool) {
 	return Variable{Name: varname, Metric: varname}, false
 }
 
+// GetPeriod returns the time between evaluations of the SLA: the shortest period of its guarantee terms,
+// or defaultPeriod if no guarantee term defines a (valid) period.
+func (a *SLA) GetPeriod(defaultPeriod time.Duration) time.Duration {
+	period := time.Duration(0)
+	for _, gt := range a.Details.Guarantees {
+		if d := gt.GetPeriod(0); d > 0 && (period == 0 || d < period) {
+			period = d
+		}
+	}
+	if period == 0 {
+		return defaultPeriod
+	}
+	return period
+}
+
+// GetPeriod returns the time between evaluations of the guarantee term, or defaultPeriod if it does not define a (valid) period.
+func (gt Guarantee) GetPeriod(defaultPeriod time.Duration) time.Duration {
+	if len(gt.Period) == 0 {
+		return defaultPeriod
+	}
+	d, err := common.ParseDuration(gt.Period)
+	if err != nil || d <= 0 {
+		return defaultPeriod
+	}
+	return d
+}
+
+// GetLevels returns the level policy applied to a guarantee term: the values defined in the guarantee,
+// or the SLA values (Assessment X, Y and Z) if not defined, or the default values if none is defined.
+func (a *SLA) GetLevels(gt Guarantee) Levels {
+	l := Levels{
+		CriticalAfter: a.Assessment.X,
+		DesiredAfter:  a.Assessment.Y,
+		UnstableAfter: a.Assessment.Z,
+	}
+	if gt.Levels != nil {
+		if gt.Levels.CriticalAfter > 0 {
+			l.CriticalAfter = gt.Levels.CriticalAfter
+		}
+		if gt.Levels.DesiredAfter > 0 {
+			l.DesiredAfter = gt.Levels.DesiredAfter
+		}
+		if gt.Levels.UnstableAfter > 0 {
+			l.UnstableAfter = gt.Levels.UnstableAfter
+		}
+		l.Policy = gt.Levels.Policy
+		l.Window = gt.Levels.Window
+		l.CriticalRatio = gt.Levels.CriticalRatio
+		l.Alpha = gt.Levels.Alpha
+	}
+	if l.CriticalAfter <= 0 {
+		l.CriticalAfter = DEFAULT_ASSESSMENT_X
+	}
+	if l.DesiredAfter <= 0 {
+		l.DesiredAfter = DEFAULT_ASSESSMENT_Y
+	}
+	if l.UnstableAfter <= 0 {
+		l.UnstableAfter = DEFAULT_ASSESSMENT_Z
+	}
+	if l.Window <= 0 {
+		l.Window = DEFAULT_LEVEL_WINDOW
+	}
+	if l.CriticalRatio <= 0 {
+		l.CriticalRatio = DEFAULT_LEVEL_CRITICAL_RATIO
+	}
+	if l.Alpha <= 0 {
+		l.Alpha = DEFAULT_LEVEL_ALPHA
+	}
+	return l
+}
+
+// GetObjective returns the objective of a guarantee term, with the default window and burn rate rules if not defined.
+// The guarantee term must have an objective.
+func (a *SLA) GetObjective(gt Guarantee) Objective {
+	o := *gt.Objective
+	if len(o.Window) == 0 {
+		o.Window = DEFAULT_OBJECTIVE_WINDOW
+	}
+	if len(o.BurnRates) == 0 {
+		o.BurnRates = DefaultBurnRates
+	}
+	return o
+}
+
 // Validate validates the consistency of a Guarantee entity
 func (g *Guarantee) Validate(val Validator, mode ValidationMode) []error {
 	return val.ValidateGuarantee(g, mode)
 }
 
+// Clone returns a deep copy of the SLA, so that the copy can be modified without
+// affecting the original (i.e. maps and slices are not shared)
+func (a *SLA) Clone() SLA {
+	c := *a
+
+	if a.Expiration != nil {
+		exp := *a.Expiration
+		c.Expiration = &exp
+	}
+	c.Details.Variables = append([]Variable(nil), a.Details.Variables...)
+	c.Details.Guarantees = append([]Guarantee(nil), a.Details.Guarantees...)
+	for i, gt := range c.Details.Guarantees {
+		if gt.Levels != nil {
+			l := *gt.Levels
+			c.Details.Guarantees[i].Levels = &l
+		}
+		if gt.Objective != nil {
+			o := *gt.Objective
+			o.BurnRates = append([]BurnRateRule(nil), gt.Objective.BurnRates...)
+			c.Details.Guarantees[i].Objective = &o
+		}
+		c.Details.Guarantees[i].Bindings = cloneLabels(gt.Bindings)
+		if gt.Thresholds != nil {
+			t := *gt.Thresholds
+			t.Values = make(map[string]float64, len(gt.Thresholds.Values))
+			for k, v := range gt.Thresholds.Values {
+				t.Values[k] = v
+			}
+			if gt.Thresholds.Default != nil {
+				d := *gt.Thresholds.Default
+				t.Default = &d
+			}
+			c.Details.Guarantees[i].Thresholds = &t
+		}
+	}
+
+	if a.Assessment.Guarantees != nil {
+		c.Assessment.Guarantees = make(map[string]AssessmentGuarantee, len(a.Assessment.Guarantees))
+		for name, ag := range a.Assessment.Guarantees {
+			c.Assessment.Guarantees[name] = ag.Clone()
+		}
+	}
+	return c
+}
+
+// Clone returns a deep copy of the assessment info of a guarantee term
+func (ag AssessmentGuarantee) Clone() AssessmentGuarantee {
+	c := ag
+
+	if ag.LastValues != nil {
+		c.LastValues = make(LastValues, len(ag.LastValues))
+		for k, v := range ag.LastValues {
+			c.LastValues[k] = v.Clone()
+		}
+	}
+	c.Window = append([]bool(nil), ag.Window...)
+	if ag.Budget != nil {
+		b := *ag.Budget
+		b.BurnRates = make(map[string]float64, len(ag.Budget.BurnRates))
+		for k, v := range ag.Budget.BurnRates {
+			b.BurnRates[k] = v
+		}
+		b.Slices = append([]BudgetBucket(nil), ag.Budget.Slices...)
+		b.Recent = append([]BudgetBucket(nil), ag.Budget.Recent...)
+		c.Budget = &b
+	}
+	if ag.LastViolation != nil {
+		v := ag.LastViolation.Clone()
+		c.LastViolation = &v
+	}
+	if ag.Rebinding != nil {
+		r := Rebinding{Time: ag.Rebinding.Time, From: cloneLabels(ag.Rebinding.From), To: cloneLabels(ag.Rebinding.To)}
+		c.Rebinding = &r
+	}
+	if ag.Series != nil {
+		c.Series = make(map[string]AssessmentGuarantee, len(ag.Series))
+		for k, v := range ag.Series {
+			c.Series[k] = v.Clone()
+		}
+	}
+	c.Labels = cloneLabels(ag.Labels)
+	return c
+}
+
+// cloneLabels returns a copy of a set of labels (e.g. the values bound to a scope)
+func cloneLabels(bindings map[string]string) map[string]string {
+	if bindings == nil {
+		return nil
+	}
+	c := make(map[string]string, len(bindings))
+	for k, v := range bindings {
+		c[k] = v
+	}
+	return c
+}
+
+// Clone returns a deep copy of the violation
+func (v *Violation) Clone() Violation {
+	c := *v
+	if v.Values != nil {
+		c.Values = make([]MetricValue, len(v.Values))
+		for i, value := range v.Values {
+			c.Values[i] = value.Clone()
+		}
+	}
+	c.FailedTerms = append([]string(nil), v.FailedTerms...)
+	c.Labels = cloneLabels(v.Labels)
+	return c
+}
+
 // GetId returns the Id of a violation
 func (v *Violation) GetId() string {
 	return v.Id
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThresholdsGet(t *testing.T) {
+	def := 2.0
+	for _, tc := range []struct {
+		name      string
+		fallback  *float64
+		bindings  map[string]string
+		threshold float64
+		key       string
+	}{
+		{name: "value bound", bindings: map[string]string{"company_premises_building": "Red"}, threshold: 1, key: "Red"},
+		{name: "other value bound", fallback: &def, bindings: map[string]string{"company_premises_building": "Blue"}, threshold: 3, key: "Blue"},
+		{name: "default", fallback: &def, bindings: map[string]string{"company_premises_building": "Green"}, threshold: 2, key: THRESHOLD_KEY_DEFAULT},
+		{name: "label not bound", fallback: &def, bindings: map[string]string{"network_zone": "Red"}, threshold: 2, key: THRESHOLD_KEY_DEFAULT},
+		{name: "no default", bindings: map[string]string{"company_premises_building": "Green"}, threshold: 0, key: ""},
+		{name: "no bindings", threshold: 0, key: ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			thresholds := Thresholds{
+				Label:   "company_premises_building",
+				Values:  map[string]float64{"Red": 1, "Blue": 3},
+				Default: tc.fallback,
+			}
+			threshold, key := thresholds.Get(tc.bindings)
+			assert.Equal(t, tc.threshold, threshold)
+			assert.Equal(t, tc.key, key)
+		})
+	}
+}
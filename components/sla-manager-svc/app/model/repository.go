@@ -71,7 +71,11 @@ type IRepository interface {
 	CreateSLA(qos *SLA) (*SLA, error)
 
 	/*
-	 *UpdateSLA updates the information of an already saved instance of an agreement
+	 * UpdateSLA updates the information of an already saved instance of an agreement.
+	 * The update is a compare-and-swap on qos.Version: it is only applied if qos.Version
+	 * is equal to the stored version; then the version is incremented (also in qos).
+	 * error is ErrNotFound if the SLA does not exist;
+	 * error is ErrConflict if the SLA was modified after being read
 	 */
 	UpdateSLA(qos *SLA) (*SLA, error)
 
@@ -108,6 +112,13 @@ type IRepository interface {
 	 */
 	GetAppViolations(id string) (Violations, error)
 
+	/*
+	 * GetServiceViolations returns the list of violations of the SLAs of a service (see Violation.ServiceId).
+	 * The list is empty when there are no violations;
+	 * error != nil on error
+	 */
+	GetServiceViolations(name string) (Violations, error)
+
 	/*
 	 * GetAllViolations returns the list of violations.
 	 * The list is empty when there are no violations;
@@ -0,0 +1,265 @@
+/*
+Copyright © 2024 EVIDEN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+This work has been implemented within the context of COLMENA project.
+*/
+package model
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SCOPE_CONTEXT_SEPARATOR separates the contexts of a scope
+	SCOPE_CONTEXT_SEPARATOR = ";"
+	// SCOPE_LABEL_SEPARATOR separates the labels of a context in a scope
+	SCOPE_LABEL_SEPARATOR = ","
+	// SCOPE_BOUND_VALUE is the value of the labels bound to the value found in the context
+	SCOPE_BOUND_VALUE = "."
+)
+
+// Operators of the predicates of a scope
+const (
+	SCOPE_OP_EQ = "=="
+	SCOPE_OP_NE = "!="
+	SCOPE_OP_GT = ">"
+	SCOPE_OP_GE = ">="
+	SCOPE_OP_LT = "<"
+	SCOPE_OP_LE = "<="
+	SCOPE_OP_IN = "in"
+)
+
+var (
+	// labelNameRegexp validates the names of the labels injected in the queries (Prometheus label names)
+	labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// scopeTermRegexp parses a label of a scope: <label><operator><value>
+	scopeTermRegexp = regexp.MustCompile(`^([^=!<>\s]+)\s*(==|!=|>=|<=|=|>|<)\s*(.*)$`)
+	// scopeInRegexp parses a label of a scope with the "in" operator: <label> in (<value>[,<value>])
+	scopeInRegexp = regexp.MustCompile(`^([^=!<>\s]+)\s+in\s*\((.*)\)$`)
+)
+
+/*
+ScopeLabel is a label of a context of the agent used in a scope:
+
+  - bound label ("building=."): the label is bound to the value of the context, and injected in the queries as
+    company_premises_building="<value of building in the context>"
+  - predicate ("occupancy>10", "mode in (day,evening)", "online==true"): the SLA is only active while the value of
+    the label in the context satisfies the predicate (Operator and Values)
+*/
+type ScopeLabel struct {
+	Context  string
+	Label    string
+	Operator string
+	Values   []string
+}
+
+// Name returns the name of the label injected in the queries (e.g. "company_premises_building")
+func (sl ScopeLabel) Name() string {
+	return sl.Context + "_" + sl.Label
+}
+
+// IsPredicate returns true if the label is a predicate, false if it is a bound label
+func (sl ScopeLabel) IsPredicate() bool {
+	return len(sl.Operator) > 0
+}
+
+/*
+Holds returns true if the value of the label in the context satisfies the predicate. The values of the contexts are
+typed (JSON): strings, numbers and booleans. Numeric comparisons also accept strings with numbers (e.g. "22").
+*/
+func (sl ScopeLabel) Holds(value interface{}) bool {
+	switch sl.Operator {
+	case SCOPE_OP_EQ:
+		return equalContextValue(value, sl.Values[0])
+	case SCOPE_OP_NE:
+		return value != nil && !equalContextValue(value, sl.Values[0])
+	case SCOPE_OP_IN:
+		for _, v := range sl.Values {
+			if equalContextValue(value, v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	x, ok := numericContextValue(value)
+	if !ok {
+		return false
+	}
+	y, _ := strconv.ParseFloat(sl.Values[0], 64)
+	switch sl.Operator {
+	case SCOPE_OP_GT:
+		return x > y
+	case SCOPE_OP_GE:
+		return x >= y
+	case SCOPE_OP_LT:
+		return x < y
+	case SCOPE_OP_LE:
+		return x <= y
+	}
+	return false
+}
+
+/*
+ParseScope parses the scope of a KPI and returns its labels. A scope is a list of contexts separated by ';', each one
+with a list of labels separated by ',', bound to the context value ('.') or with a predicate. Examples:
+
+	company_premises/building=.
+	company_premises/building=.,floor=.;network/zone=.
+	company_premises/building=.,occupancy>10;schedule/mode in (day,evening);status/online==true
+*/
+func ParseScope(scope string) ([]ScopeLabel, error) {
+	scope = strings.TrimSpace(scope)
+	if len(scope) == 0 {
+		return nil, nil
+	}
+
+	res := make([]ScopeLabel, 0)
+	names := make(map[string]bool)
+	for _, part := range splitScope(scope, SCOPE_CONTEXT_SEPARATOR) {
+		context, labels, found := strings.Cut(strings.TrimSpace(part), "/")
+		context = strings.TrimSpace(context)
+		if !found || len(context) == 0 || len(strings.TrimSpace(labels)) == 0 {
+			return nil, errors.New("'" + part + "' is not valid. Expected format: \"<context>/<label>=.[,<label><operator><value>]\"")
+		}
+
+		for _, l := range splitScope(labels, SCOPE_LABEL_SEPARATOR) {
+			sl, err := parseScopeLabel(context, strings.TrimSpace(l))
+			if err != nil {
+				return nil, err
+			}
+
+			if !sl.IsPredicate() {
+				if !labelNameRegexp.MatchString(sl.Name()) {
+					return nil, errors.New("'" + sl.Name() + "' is not a valid label name")
+				}
+				if names[sl.Name()] {
+					return nil, errors.New("label '" + sl.Label + "' of context '" + context + "' is repeated")
+				}
+				names[sl.Name()] = true
+			}
+			res = append(res, sl)
+		}
+	}
+	return res, nil
+}
+
+// parseScopeLabel parses a label of a context of a scope (e.g. "building=.", "occupancy>10", "mode in (day,evening)")
+func parseScopeLabel(context string, l string) (ScopeLabel, error) {
+	if m := scopeInRegexp.FindStringSubmatch(l); m != nil {
+		sl := ScopeLabel{Context: context, Label: m[1], Operator: SCOPE_OP_IN}
+		for _, v := range strings.Split(m[2], SCOPE_LABEL_SEPARATOR) {
+			if v = unquote(strings.TrimSpace(v)); len(v) > 0 {
+				sl.Values = append(sl.Values, v)
+			}
+		}
+		if len(sl.Values) == 0 {
+			return sl, errors.New("'" + l + "' of context '" + context + "' is not valid: a list of values is expected")
+		}
+		return sl, nil
+	}
+
+	m := scopeTermRegexp.FindStringSubmatch(l)
+	if m == nil || len(m[3]) == 0 {
+		return ScopeLabel{}, errors.New("'" + l + "' of context '" + context + "' is not valid. Expected format: \"<label>=.\" or \"<label><operator><value>\" (operators: ==, !=, >, >=, <, <=, in)")
+	}
+
+	label, op, value := m[1], m[2], unquote(strings.TrimSpace(m[3]))
+	if op == "=" && value == SCOPE_BOUND_VALUE {
+		return ScopeLabel{Context: context, Label: label}, nil
+	}
+	if op == "=" {
+		op = SCOPE_OP_EQ
+	}
+	switch op {
+	case SCOPE_OP_GT, SCOPE_OP_GE, SCOPE_OP_LT, SCOPE_OP_LE:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return ScopeLabel{}, errors.New("'" + l + "' of context '" + context + "' is not valid: a number is expected")
+		}
+	}
+	return ScopeLabel{Context: context, Label: label, Operator: op, Values: []string{value}}, nil
+}
+
+// splitScope splits a scope by sep, ignoring the separators between parentheses (e.g. "mode in (day,evening)")
+func splitScope(scope string, sep string) []string {
+	res := make([]string, 0)
+	depth, start := 0, 0
+	for i := 0; i < len(scope); i++ {
+		switch {
+		case scope[i] == '(':
+			depth++
+		case scope[i] == ')' && depth > 0:
+			depth--
+		case depth == 0 && strings.HasPrefix(scope[i:], sep):
+			res = append(res, scope[start:i])
+			start = i + len(sep)
+		}
+	}
+	return append(res, scope[start:])
+}
+
+// unquote removes the quotes of a value of a predicate (e.g. "'day'")
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// equalContextValue compares a (typed) value of a context with a value of a predicate
+func equalContextValue(value interface{}, literal string) bool {
+	switch v := value.(type) {
+	case string:
+		return v == literal
+	case bool:
+		b, err := strconv.ParseBool(literal)
+		return err == nil && b == v
+	case float64:
+		f, err := strconv.ParseFloat(literal, 64)
+		return err == nil && f == v
+	}
+	return false
+}
+
+// numericContextValue returns the numeric value of a value of a context (numbers, or strings with numbers)
+func numericContextValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+/*
+FormatContextValue returns the value of a context as the value of a label (strings, numbers and booleans); false if
+the value cannot be used as a label value (e.g. objects).
+*/
+func FormatContextValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	}
+	return "", false
+}
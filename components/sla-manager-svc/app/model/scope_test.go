@@ -0,0 +1,194 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseScopeBindings(t *testing.T) {
+	for _, tc := range []struct {
+		scope    string
+		expected []ScopeLabel
+	}{
+		{scope: "", expected: nil},
+		{
+			scope:    "company_premises/building=.",
+			expected: []ScopeLabel{{Context: "company_premises", Label: "building"}},
+		},
+		{
+			scope: "company_premises/building=.,floor=.",
+			expected: []ScopeLabel{
+				{Context: "company_premises", Label: "building"},
+				{Context: "company_premises", Label: "floor"},
+			},
+		},
+		{
+			scope: " company_premises/building=., floor=. ; network/zone=.",
+			expected: []ScopeLabel{
+				{Context: "company_premises", Label: "building"},
+				{Context: "company_premises", Label: "floor"},
+				{Context: "network", Label: "zone"},
+			},
+		},
+		{
+			// same label in different contexts
+			scope: "company_premises/zone=.;network/zone=.",
+			expected: []ScopeLabel{
+				{Context: "company_premises", Label: "zone"},
+				{Context: "network", Label: "zone"},
+			},
+		},
+	} {
+		t.Run(tc.scope, func(t *testing.T) {
+			actual, err := ParseScope(tc.scope)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestParseScopeBindingsErrors(t *testing.T) {
+	for _, tc := range []struct {
+		scope string
+		err   string
+	}{
+		{scope: "company_premises/building=.,building=.", err: "label 'building' of context 'company_premises' is repeated"},
+		{scope: "company_premises/building=.;company_premises/building=.", err: "label 'building' of context 'company_premises' is repeated"},
+		{scope: "company_premises", err: "is not valid"},
+		{scope: "/building=.", err: "is not valid"},
+		{scope: "company_premises/", err: "is not valid"},
+		{scope: "company_premises/building=.;", err: "is not valid"},
+		{scope: "company-premises/building=.", err: "'company-premises_building' is not a valid label name"},
+	} {
+		t.Run(tc.scope, func(t *testing.T) {
+			_, err := ParseScope(tc.scope)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.err)
+			}
+		})
+	}
+}
+
+func TestParseScopeLabel(t *testing.T) {
+	for _, tc := range []struct {
+		label    string
+		expected ScopeLabel
+		err      string
+	}{
+		{label: "building=.", expected: ScopeLabel{Context: "ctx", Label: "building"}},
+		{label: "building = .", expected: ScopeLabel{Context: "ctx", Label: "building"}},
+		{label: "building=Red", expected: ScopeLabel{Context: "ctx", Label: "building", Operator: SCOPE_OP_EQ, Values: []string{"Red"}}},
+		{label: "building==Red", expected: ScopeLabel{Context: "ctx", Label: "building", Operator: SCOPE_OP_EQ, Values: []string{"Red"}}},
+		{label: "building!=Red", expected: ScopeLabel{Context: "ctx", Label: "building", Operator: SCOPE_OP_NE, Values: []string{"Red"}}},
+		{label: "building=='.'", expected: ScopeLabel{Context: "ctx", Label: "building", Operator: SCOPE_OP_EQ, Values: []string{"."}}},
+		{label: `room=="Rest Room"`, expected: ScopeLabel{Context: "ctx", Label: "room", Operator: SCOPE_OP_EQ, Values: []string{"Rest Room"}}},
+		{label: "occupancy>10", expected: ScopeLabel{Context: "ctx", Label: "occupancy", Operator: SCOPE_OP_GT, Values: []string{"10"}}},
+		{label: "occupancy >= 10.5", expected: ScopeLabel{Context: "ctx", Label: "occupancy", Operator: SCOPE_OP_GE, Values: []string{"10.5"}}},
+		{label: "occupancy<10", expected: ScopeLabel{Context: "ctx", Label: "occupancy", Operator: SCOPE_OP_LT, Values: []string{"10"}}},
+		{label: "occupancy<=-1", expected: ScopeLabel{Context: "ctx", Label: "occupancy", Operator: SCOPE_OP_LE, Values: []string{"-1"}}},
+		{label: "online==true", expected: ScopeLabel{Context: "ctx", Label: "online", Operator: SCOPE_OP_EQ, Values: []string{"true"}}},
+		{label: "mode in (day,evening)", expected: ScopeLabel{Context: "ctx", Label: "mode", Operator: SCOPE_OP_IN, Values: []string{"day", "evening"}}},
+		{label: `mode in ( 'day', "late evening", )`, expected: ScopeLabel{Context: "ctx", Label: "mode", Operator: SCOPE_OP_IN, Values: []string{"day", "late evening"}}},
+		{label: "mode in ()", err: "a list of values is expected"},
+		{label: "occupancy>ten", err: "a number is expected"},
+		{label: "occupancy>", err: "is not valid"},
+		{label: "building", err: "is not valid"},
+		{label: "=Red", err: "is not valid"},
+	} {
+		t.Run(tc.label, func(t *testing.T) {
+			actual, err := parseScopeLabel("ctx", tc.label)
+			if tc.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.err)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestParseScopePredicates(t *testing.T) {
+	scope, err := ParseScope("company_premises/building=.,occupancy>10;schedule/mode in (day,evening);status/online==true")
+	assert.NoError(t, err)
+	assert.Equal(t, []ScopeLabel{
+		{Context: "company_premises", Label: "building"},
+		{Context: "company_premises", Label: "occupancy", Operator: SCOPE_OP_GT, Values: []string{"10"}},
+		{Context: "schedule", Label: "mode", Operator: SCOPE_OP_IN, Values: []string{"day", "evening"}},
+		{Context: "status", Label: "online", Operator: SCOPE_OP_EQ, Values: []string{"true"}},
+	}, scope)
+
+	// predicates are not injected: they can be repeated
+	_, err = ParseScope("company_premises/occupancy>10,occupancy<100")
+	assert.NoError(t, err)
+}
+
+func TestSplitScope(t *testing.T) {
+	for _, tc := range []struct {
+		scope    string
+		sep      string
+		expected []string
+	}{
+		{scope: "a/x=.", sep: ";", expected: []string{"a/x=."}},
+		{scope: "a/x=.;b/y=.", sep: ";", expected: []string{"a/x=.", "b/y=."}},
+		{scope: "x=.,mode in (day,evening),y>1", sep: ",", expected: []string{"x=.", "mode in (day,evening)", "y>1"}},
+		{scope: "a/mode in (x;y);b/y=.", sep: ";", expected: []string{"a/mode in (x;y)", "b/y=."}},
+		{scope: "a/x=.;", sep: ";", expected: []string{"a/x=.", ""}},
+	} {
+		t.Run(tc.scope, func(t *testing.T) {
+			assert.Equal(t, tc.expected, splitScope(tc.scope, tc.sep))
+		})
+	}
+}
+
+func TestScopeLabelHolds(t *testing.T) {
+	label := func(op string, values ...string) ScopeLabel {
+		return ScopeLabel{Context: "ctx", Label: "l", Operator: op, Values: values}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		label    ScopeLabel
+		value    interface{}
+		expected bool
+	}{
+		// strings
+		{name: "string ==", label: label(SCOPE_OP_EQ, "Red"), value: "Red", expected: true},
+		{name: "string == other", label: label(SCOPE_OP_EQ, "Red"), value: "Blue"},
+		{name: "string !=", label: label(SCOPE_OP_NE, "Red"), value: "Blue", expected: true},
+		{name: "string != same", label: label(SCOPE_OP_NE, "Red"), value: "Red"},
+		{name: "string in", label: label(SCOPE_OP_IN, "day", "evening"), value: "evening", expected: true},
+		{name: "string not in", label: label(SCOPE_OP_IN, "day", "evening"), value: "night"},
+		// numbers
+		{name: "number ==", label: label(SCOPE_OP_EQ, "22"), value: 22.0, expected: true},
+		{name: "number == decimal", label: label(SCOPE_OP_EQ, "22.0"), value: 22.0, expected: true},
+		{name: "number == string", label: label(SCOPE_OP_EQ, "22"), value: "22", expected: true},
+		{name: "number == not a number", label: label(SCOPE_OP_EQ, "Red"), value: 22.0},
+		{name: "number in", label: label(SCOPE_OP_IN, "1", "2"), value: 2.0, expected: true},
+		{name: "number >", label: label(SCOPE_OP_GT, "10"), value: 11.0, expected: true},
+		{name: "number > same", label: label(SCOPE_OP_GT, "10"), value: 10.0},
+		{name: "number >=", label: label(SCOPE_OP_GE, "10"), value: 10.0, expected: true},
+		{name: "number <", label: label(SCOPE_OP_LT, "10"), value: 9.5, expected: true},
+		{name: "number < same", label: label(SCOPE_OP_LT, "10"), value: 10.0},
+		{name: "number <=", label: label(SCOPE_OP_LE, "10"), value: 10.0, expected: true},
+		{name: "string with number >", label: label(SCOPE_OP_GT, "10"), value: " 11 ", expected: true},
+		{name: "string without number >", label: label(SCOPE_OP_GT, "10"), value: "eleven"},
+		{name: "bool >", label: label(SCOPE_OP_GT, "0"), value: true},
+		// booleans
+		{name: "bool ==", label: label(SCOPE_OP_EQ, "true"), value: true, expected: true},
+		{name: "bool == other", label: label(SCOPE_OP_EQ, "true"), value: false},
+		{name: "bool == string", label: label(SCOPE_OP_EQ, "true"), value: "true", expected: true},
+		{name: "bool == not a bool", label: label(SCOPE_OP_EQ, "yes"), value: true},
+		{name: "bool !=", label: label(SCOPE_OP_NE, "true"), value: false, expected: true},
+		// other values
+		{name: "object ==", label: label(SCOPE_OP_EQ, "Red"), value: map[string]interface{}{"building": "Red"}},
+		{name: "nil !=", label: label(SCOPE_OP_NE, "Red"), value: nil},
+		{name: "nil <", label: label(SCOPE_OP_LT, "10"), value: nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.label.Holds(tc.value))
+		})
+	}
+}
@@ -23,109 +23,143 @@ import (
 	"colmena/sla-management-svc/app/common/cfg"
 	"colmena/sla-management-svc/app/common/expressions"
 	"colmena/sla-management-svc/app/common/logs"
+	"fmt"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	uuid "github.com/lithammer/shortuuid/v4"
 )
 
 /**
- * Transforms an SLA Model to a OutputSLA model
+ * Transforms an SLA Model to a OutputSLA model. The output contains a KPI for each guarantee with results
  */
 func SLAModelToColmenaOutputSLA(qos SLA) (ColmenaOutputSLA, error) {
-	res := float64(-1)
-	updated := false
-
-	//if qos.Assessment.Level != ASSESSMENT_LEVEL_NORESULTS && len(qos.Assessment.Guarantees) > 0 {
-	for key := range qos.Assessment.Guarantees {
-		//res = qos.Assessment.Guarantees[key].LastValues
-
-		if len(qos.Assessment.Guarantees[key].LastValues) > 0 {
-			for key2 := range qos.Assessment.Guarantees[key].LastValues {
-				if len(key2) > 0 {
-					r, ok := qos.Assessment.Guarantees[key].LastValues[key2].Value.(float64)
-					if !ok {
-						logs.GetLogger().Error(" Value is not a number")
-					} else {
-						updated = true
-						res = r
-					}
-					//logs.GetLogger().Debugf(pathLOG + " break")
-					break
-				}
-			}
-		}
-
-	}
-	//}
-
 	kpis := []ColmenaOutputKpis{}
 
-	if updated {
-		kpis = append(kpis, ColmenaOutputKpis{
-			RoleId:          qos.Details.Guarantees[0].Name,
-			Query:           qos.Details.Guarantees[0].OQuery,
-			Value:           res, //0, // TODO res, //result.LastValues,
-			Level:           qos.Assessment.Level,
-			Threshold:       qos.Assessment.Threshold, //qos.Details.Guarantees[0].Query,
-		})
+	for _, gt := range qos.Details.Guarantees {
+		ag := qos.Assessment.GetGuarantee(gt.Name)
+		if res, ok := lastValue(ag); ok {
+			kpi := ColmenaOutputKpis{
+				RoleId:       gt.Role,
+				Query:        gt.OQuery,
+				Value:        res,
+				Level:        ag.Level,
+				Threshold:    gt.Threshold,
+				ThresholdKey: gt.ThresholdKey,
+			}
+			if ag.Budget != nil {
+				remaining := ag.Budget.Remaining
+				kpi.BudgetRemaining = &remaining
+			}
+			if ag.Violated && ag.LastViolation != nil {
+				kpi.FailedTerms = ag.LastViolation.FailedTerms
+			}
+			kpi.Series, kpi.ViolatedSeries = OutputSeries(ag)
+			kpis = append(kpis, kpi)
+		}
 	}
 
 	output_model := ColmenaOutputSLA{
 		ServiceId: qos.Name,
-		Kpis: kpis,
+		Kpis:      kpis,
 	}
 
 	return output_model, nil
 }
 
 /**
- * Transforms an SLA Model to a OutputSLA model
+ * Transforms an SLA Model to a OutputSLA model. The output contains a KPI for each guarantee
  */
 func SLAModelToOutputSLA(qos SLA) (OutputSLA, error) {
-	res := float64(-1)
-
-	if qos.Assessment.Level != ASSESSMENT_LEVEL_NORESULTS && len(qos.Assessment.Guarantees) > 0 {
-		for key := range qos.Assessment.Guarantees {
-			//res = qos.Assessment.Guarantees[key].LastValues
-
-			if len(qos.Assessment.Guarantees[key].LastValues) > 0 {
-				for key2 := range qos.Assessment.Guarantees[key].LastValues {
-					if len(key2) > 0 {
-						r, ok := qos.Assessment.Guarantees[key].LastValues[key2].Value.(float64)
-						if !ok {
-							logs.GetLogger().Error(" Value is not a number")
-						} else {
-							res = r
-						}
-						//logs.GetLogger().Debugf(pathLOG + " break")
-						break
-					}
-				}
+	kpis := []OutputSLAKpi{}
+
+	for _, gt := range qos.Details.Guarantees {
+		ag := qos.Assessment.GetGuarantee(gt.Name)
+
+		res := float64(-1)
+		if ag.Level != ASSESSMENT_LEVEL_NORESULTS {
+			if r, ok := lastValue(ag); ok {
+				res = r
 			}
+		}
 
+		kpi := OutputSLAKpi{
+			RoleId:          gt.Role,
+			Query:           gt.OQuery,
+			Value:           res,
+			Level:           ag.Level,
+			Threshold:       gt.Threshold,
+			ThresholdKey:    gt.ThresholdKey,
+			TotalViolations: ag.TotalViolations,
 		}
+		if ag.Budget != nil {
+			remaining := ag.Budget.Remaining
+			kpi.BudgetRemaining = &remaining
+			kpi.BurnRates = ag.Budget.BurnRates
+		}
+		kpi.Series, kpi.ViolatedSeries = OutputSeries(ag)
+		kpis = append(kpis, kpi)
 	}
 
 	output_model := OutputSLA{
 		ServiceId: qos.Name,
 		SLAId:     qos.Id,
-		Kpis: []OutputSLAKpi{
-			{
-				RoleId:          qos.Details.Guarantees[0].Name,
-				Query:           qos.Details.Guarantees[0].OQuery,
-				Value:           res, //0, // TODO res, //result.LastValues,
-				Level:           qos.Assessment.Level,
-				Threshold:       qos.Assessment.Threshold, //qos.Details.Guarantees[0].Query,
-				TotalViolations: qos.Assessment.TotalViolations,
-			},
-		},
+		Kpis:      kpis,
 	}
 
 	return output_model, nil
 }
 
+/**
+ * lastValue returns the last numeric value assessed in a guarantee; false if there are no values
+ */
+func lastValue(ag AssessmentGuarantee) (float64, bool) {
+	for key, v := range ag.LastValues {
+		if len(key) > 0 {
+			r, ok := v.Value.(float64)
+			if !ok {
+				logs.GetLogger().Error(" Value is not a number")
+			}
+			return r, ok
+		}
+	}
+	return -1, false
+}
+
+/**
+ * OutputSeries returns the assessment of each series (sorted by key) of a guarantee with several series, and the number
+ * of series violated
+ */
+func OutputSeries(ag AssessmentGuarantee) ([]OutputSeriesKpi, int) {
+	if len(ag.Series) == 0 {
+		return nil, 0
+	}
+	keys := make([]string, 0, len(ag.Series))
+	for key := range ag.Series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	res := make([]OutputSeriesKpi, 0, len(keys))
+	violated := 0
+	for _, key := range keys {
+		s := ag.Series[key]
+		out := OutputSeriesKpi{Labels: s.Labels, Level: s.Level, Value: float64(-1), Violated: s.Violated}
+		if v, ok := lastValue(s); ok && s.Level != ASSESSMENT_LEVEL_NORESULTS {
+			out.Value = v
+		}
+		if s.Violated {
+			violated++
+		}
+		res = append(res, out)
+	}
+	return res, violated
+}
+
 /**
  * Transforms a list of SLA Models to a list of OutputSLA models
  */
@@ -167,10 +201,21 @@ func SLAModelsToColmenaOutputSLAs(l SLAs) ([]ColmenaOutputSLA, error) {
  */
 func InputSLAModelToSLAModel(c *gin.Context) ([]SLA, error) {
 	var input InputSLA
-	var slas []SLA
 
 	err := c.ShouldBindJSON(&input)
 	if err != nil {
+		return nil, err
+	}
+	return InputSLAToSLAModels(input)
+}
+
+/**
+ * Transforms an InputSLA (service definition) to the SLA Models: one SLA per role (and one for the service KPIs)
+ */
+func InputSLAToSLAModels(input InputSLA) ([]SLA, error) {
+	var slas []SLA
+
+	if err := checkInputKPIs(input); err != nil {
 		return slas, err
 	}
 
@@ -198,69 +243,254 @@ func InputSLAModelToSLAModel(c *gin.Context) ([]SLA, error) {
 	return slas, nil
 }
 
-// listToSLAModel
-func listToSLAModel(input InputSLA, roleId string, l []InputSLARoleKPI) []SLA {
-	var slas []SLA
+// sourceNameRegexp validates the names of the monitoring sources pinned by the KPIs (see Guarantee.Source)
+var sourceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
 
-	x := common.GetIntEnv(cfg.ASSESSMENT_X, DEFAULT_ASSESSMENT_X)
-	y := common.GetIntEnv(cfg.ASSESSMENT_Y, DEFAULT_ASSESSMENT_Y)
-	z := common.GetIntEnv(cfg.ASSESSMENT_Z, DEFAULT_ASSESSMENT_Z)
-
-	for _, kpi := range l {
-		uid := uuid.New()
-		sla := SLA{}
-
-		sla.Name = input.ServiceId.Value
-		sla.Id = input.ServiceId.Value + "-" + uid
-
-		// assessment
-		sla.Assessment.TotalExecutions = 0
-		sla.Assessment.TotalViolations = 0
-		sla.Assessment.X = x
-		sla.Assessment.XCounter = 0
-		sla.Assessment.Y = y
-		sla.Assessment.YCounter = 0
-		sla.Assessment.Z = z
-		sla.Assessment.ZCounter = 0
-		sla.Assessment.Level = ASSESSMENT_LEVEL_UNKNOWN // Broken, Critical, Met, Desired, Unstable, Unknown
-
-		// constraint expression
-		expr, threshold, err := expressions.CheckAndParseConstraint(kpi.Query)
-		if err != nil {
-			expr = kpi.Query
-			logs.GetLogger().Warn(" expr: "+expr+", Error: ", err)
-		} else {
-			logs.GetLogger().Debug(" expr: " + expr)
+// checkInputKPIs checks the queries (syntax), the scopes, the sources, the evaluation periods, the objectives and the level policies of the KPIs
+func checkInputKPIs(input InputSLA) error {
+	kpis := append([]InputSLARoleKPI(nil), input.Kpis...)
+	for _, r := range input.Roles {
+		kpis = append(kpis, r.Kpis...)
+	}
+
+	for _, kpi := range kpis {
+		if _, _, err := expressions.CheckAndParseConstraint(kpi.Query); err != nil {
+			return fmt.Errorf("invalid query of KPI '%s': %s", kpi.Query, err.Error())
 		}
 
-		// threshold
-		floatValue, err := strconv.ParseFloat(threshold, 64)
-		if err != nil {
-			logs.GetLogger().Error("threshold value ['"+threshold+"'] is not a float. Error: ", err)
-			sla.Assessment.Threshold = -1
-		} else {
-			sla.Assessment.Threshold = floatValue
+		if _, err := ParseScope(kpi.Scope); err != nil {
+			return fmt.Errorf("invalid scope of KPI '%s': %s", kpi.Query, err.Error())
+		}
+
+		if source := strings.TrimSpace(kpi.Source); len(source) > 0 && !sourceNameRegexp.MatchString(source) {
+			return fmt.Errorf("invalid source of KPI '%s': only letters, digits and '_' are allowed", kpi.Query)
 		}
 
-		// guarantees
-		sla.Details.Guarantees = make([]Guarantee, 1) // TODO for each KPI => 1 Guarantee
-		sla.Details.Guarantees[0].Name = roleId
-		sla.Details.Guarantees[0].Constraint = strings.ReplaceAll(expr, "#LABELS#", "") //kpi.Query
-		sla.Details.Guarantees[0].Query = expr
-		sla.Details.Guarantees[0].OQuery = kpi.Query
-		sla.Details.Guarantees[0].Scope = strings.ReplaceAll(kpi.Scope, " ", "")
-		sla.Details.Guarantees[0].ScopeTemplate = strings.ReplaceAll(kpi.Scope, " ", "")
+		if len(kpi.Period) > 0 {
+			if d, err := common.ParseDuration(kpi.Period); err != nil || d <= 0 {
+				return fmt.Errorf("invalid period of KPI '%s': a positive duration (e.g. \"5s\") or number of seconds is expected", kpi.Query)
+			}
+		}
 
-		if len(sla.Details.Guarantees[0].Constraint) > 0 && len(sla.Details.Guarantees[0].Scope) > 0 {
-			sla.State = PAUSED
-		} else if len(sla.Details.Guarantees[0].Constraint) > 0 {
-			sla.State = STARTED
-		} else {
+		if err := checkObjective(kpi); err != nil {
+			return err
+		}
+
+		if err := checkThresholds(kpi); err != nil {
+			return err
+		}
+
+		l := kpi.Levels
+		if l == nil {
+			continue
+		}
+		if !IsLevelPolicy(l.Policy) {
+			return fmt.Errorf("level policy '%s' of KPI '%s' not found", l.Policy, kpi.Query)
+		}
+		if l.CriticalAfter < 0 || l.DesiredAfter < 0 || l.UnstableAfter < 0 || l.Window < 0 {
+			return fmt.Errorf("invalid levels of KPI '%s': values must be positive", kpi.Query)
+		}
+		if l.CriticalRatio < 0 || l.CriticalRatio > 1 || l.Alpha < 0 || l.Alpha > 1 {
+			return fmt.Errorf("invalid levels of KPI '%s': critical_ratio and alpha must be in the range (0, 1]", kpi.Query)
+		}
+	}
+	return nil
+}
+
+// checkObjective checks the objective (if any) of a KPI: target in (0, 1), and positive windows and factors
+func checkObjective(kpi InputSLARoleKPI) error {
+	o := kpi.Objective
+	if o == nil {
+		return nil
+	}
+	if o.Target <= 0 || o.Target >= 1 {
+		return fmt.Errorf("invalid objective of KPI '%s': target must be in the range (0, 1)", kpi.Query)
+	}
+	if len(o.Window) > 0 {
+		if d, err := common.ParseDuration(o.Window); err != nil || d <= 0 {
+			return fmt.Errorf("invalid objective of KPI '%s': window must be a positive duration (e.g. \"30d\")", kpi.Query)
+		}
+	}
+	// the burn rates of the rules are output by long window (see ErrorBudget.BurnRates)
+	longs := make(map[time.Duration]bool, len(o.BurnRates))
+	for _, r := range o.BurnRates {
+		long, err1 := common.ParseDuration(r.LongWindow)
+		short, err2 := common.ParseDuration(r.ShortWindow)
+		if err1 != nil || err2 != nil || short <= 0 || long < short {
+			return fmt.Errorf("invalid burn rate rule '%s' of KPI '%s': long_window and short_window must be positive durations, with short_window <= long_window", r.Name, kpi.Query)
+		}
+		if r.Factor <= 0 || len(r.Name) == 0 {
+			return fmt.Errorf("invalid burn rate rule '%s' of KPI '%s': name and a positive factor are required", r.Name, kpi.Query)
+		}
+		if longs[long] {
+			return fmt.Errorf("invalid burn rate rule '%s' of KPI '%s': another rule has the same long_window", r.Name, kpi.Query)
+		}
+		longs[long] = true
+	}
+	return nil
+}
+
+// checkThresholds checks the context thresholds (if any) of a KPI: a scope that binds the label, and a query with a single
+// comparison with a threshold
+func checkThresholds(kpi InputSLARoleKPI) error {
+	t := kpi.Thresholds
+	if t == nil {
+		return nil
+	}
+	if len(t.Values) == 0 {
+		return fmt.Errorf("invalid thresholds of KPI '%s': values are required", kpi.Query)
+	}
+	if len(strings.TrimSpace(kpi.Scope)) == 0 {
+		return fmt.Errorf("invalid thresholds of KPI '%s': a scope is required", kpi.Query)
+	}
+	if _, ok := thresholdsLabel(kpi); !ok {
+		return fmt.Errorf("invalid thresholds of KPI '%s': label '%s' is not bound by the scope '%s'", kpi.Query, t.Label, kpi.Scope)
+	}
+	if _, err := expressions.SetThreshold(kpi.Query, 0); err != nil {
+		return fmt.Errorf("invalid thresholds of KPI '%s': %s", kpi.Query, err.Error())
+	}
+	// the threshold would only be set in the first comparison (see expressions.SetThreshold)
+	if terms, _ := expressions.ConstraintTerms(kpi.Query); len(terms) > 1 {
+		return fmt.Errorf("invalid thresholds of KPI '%s': thresholds are not supported in queries with several comparisons", kpi.Query)
+	}
+	return nil
+}
+
+// thresholdsLabel returns the name of the label of the context thresholds of a KPI ("company_premises/building" or
+// "company_premises_building"; by default, the first label bound by the scope), and false if the scope does not bind it
+func thresholdsLabel(kpi InputSLARoleKPI) (string, bool) {
+	scope, _ := ParseScope(kpi.Scope)
+	label := strings.Replace(strings.TrimSpace(kpi.Thresholds.Label), "/", "_", 1)
+	for _, sl := range scope {
+		if !sl.IsPredicate() && (len(label) == 0 || sl.Name() == label) {
+			return sl.Name(), true
+		}
+	}
+	return "", false
+}
+
+/*
+listToSLAModel creates the SLA of a role (or of the service, if roleId is empty). Each KPI of the list is translated
+to a guarantee of the SLA, with its own constraint, threshold and scope.
+*/
+func listToSLAModel(input InputSLA, roleId string, l []InputSLARoleKPI) []SLA {
+	if len(l) == 0 {
+		return nil
+	}
+
+	uid := uuid.New()
+	sla := SLA{}
+
+	sla.Name = input.ServiceId.Value
+	sla.Id = input.ServiceId.Value + "-" + uid
+
+	// assessment
+	sla.Assessment.TotalExecutions = 0
+	sla.Assessment.TotalViolations = 0
+	sla.Assessment.X = common.GetIntEnv(cfg.ASSESSMENT_X, DEFAULT_ASSESSMENT_X)
+	sla.Assessment.Y = common.GetIntEnv(cfg.ASSESSMENT_Y, DEFAULT_ASSESSMENT_Y)
+	sla.Assessment.Z = common.GetIntEnv(cfg.ASSESSMENT_Z, DEFAULT_ASSESSMENT_Z)
+	sla.Assessment.Level = ASSESSMENT_LEVEL_UNKNOWN // Broken, Critical, Met, Desired, Unstable, Unknown
+
+	// guarantees: 1 KPI => 1 Guarantee
+	sla.Details.Guarantees = make([]Guarantee, 0, len(l))
+	for i, kpi := range l {
+		sla.Details.Guarantees = append(sla.Details.Guarantees, kpiToGuarantee(guaranteeName(roleId, i, len(l)), roleId, kpi))
+	}
+
+	sla.State = STARTED
+	for _, gt := range sla.Details.Guarantees {
+		if len(gt.Constraint) == 0 {
 			sla.State = INVALID
+			break
+		} else if len(gt.Scope) > 0 {
+			sla.State = PAUSED
 		}
+	}
+
+	return []SLA{sla}
+}
+
+// kpiToGuarantee
+func kpiToGuarantee(name string, roleId string, kpi InputSLARoleKPI) Guarantee {
+	// constraint expression
+	expr, threshold, err := expressions.CheckAndParseConstraint(kpi.Query)
+	if err != nil {
+		expr = kpi.Query
+		logs.GetLogger().Warn(" expr: "+expr+", Error: ", err)
+	} else {
+		logs.GetLogger().Debug(" expr: " + expr)
+	}
+
+	gt := Guarantee{
+		Name:          name,
+		Role:          roleId,
+		Constraint:    expr,
+		Query:         expr,
+		OQuery:        kpi.Query,
+		Scope:         strings.TrimSpace(kpi.Scope),
+		ScopeTemplate: strings.TrimSpace(kpi.Scope),
+		Source:        strings.TrimSpace(kpi.Source),
+	}
+
+	// evaluation period
+	if len(kpi.Period) > 0 {
+		if d, err := common.ParseDuration(kpi.Period); err == nil {
+			gt.Period = d.String()
+		}
+	}
 
-		slas = append(slas, sla)
+	// level policy
+	if kpi.Levels != nil {
+		levels := *kpi.Levels
+		gt.Levels = &levels
 	}
 
-	return slas
+	// objective (error budget)
+	if kpi.Objective != nil {
+		objective := *kpi.Objective
+		objective.BurnRates = append([]BurnRateRule(nil), kpi.Objective.BurnRates...)
+		gt.Objective = &objective
+	}
+
+	// threshold
+	floatValue, err := strconv.ParseFloat(threshold, 64)
+	if err != nil {
+		logs.GetLogger().Error("threshold value ['"+threshold+"'] is not a float. Error: ", err)
+		gt.Threshold = -1
+	} else {
+		gt.Threshold = floatValue
+	}
+
+	// context thresholds: set when the scope is bound; the threshold of the query is the default one
+	if kpi.Thresholds != nil {
+		thresholds := Thresholds{Values: make(map[string]float64, len(kpi.Thresholds.Values))}
+		thresholds.Label, _ = thresholdsLabel(kpi)
+		for k, v := range kpi.Thresholds.Values {
+			thresholds.Values[k] = v
+		}
+		if kpi.Thresholds.Default != nil {
+			d := *kpi.Thresholds.Default
+			thresholds.Default = &d
+		} else if err == nil {
+			thresholds.Default = &floatValue
+		}
+		gt.Thresholds = &thresholds
+	}
+
+	return gt
+}
+
+/*
+guaranteeName returns the name of the i-th guarantee of a role with total KPIs. The name is the role id when the role
+has only one KPI (e.g. "Processing"); otherwise the position of the KPI is appended (e.g. "Processing-1", "Processing-2").
+*/
+func guaranteeName(roleId string, i int, total int) string {
+	if total == 1 {
+		return roleId
+	}
+	if len(roleId) == 0 {
+		return "kpi-" + strconv.Itoa(i+1)
+	}
+	return roleId + "-" + strconv.Itoa(i+1)
 }
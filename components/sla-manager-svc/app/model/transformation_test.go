@@ -0,0 +1,187 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInputSLAToSLAModelsLevelPolicy(t *testing.T) {
+	input := func(policy string) InputSLA {
+		return InputSLA{
+			ServiceId: ServiceId{Value: "service1"},
+			Roles: []InputSLARole{{
+				Id:   "Processing",
+				Kpis: []InputSLARoleKPI{{Query: "[processing_time] < 50", Levels: &Levels{Policy: policy}}},
+			}},
+		}
+	}
+
+	for _, policy := range []string{"", LEVEL_POLICY_COUNTER, LEVEL_POLICY_WINDOW, LEVEL_POLICY_EWMA} {
+		slas, err := InputSLAToSLAModels(input(policy))
+		assert.NoError(t, err, policy)
+		assert.Len(t, slas, 1, policy)
+	}
+
+	_, err := InputSLAToSLAModels(input("unknown"))
+	assert.EqualError(t, err, "level policy 'unknown' of KPI '[processing_time] < 50' not found")
+}
+
+func TestInputSLAToSLAModelsObjective(t *testing.T) {
+	input := func(o *Objective) InputSLA {
+		return InputSLA{
+			ServiceId: ServiceId{Value: "service1"},
+			Kpis:      []InputSLARoleKPI{{Query: "[latency] < 200", Objective: o}},
+		}
+	}
+	fast := BurnRateRule{Name: "fast", LongWindow: "1h", ShortWindow: "5m", Factor: 14.4}
+	slow := BurnRateRule{Name: "slow", LongWindow: "6h", ShortWindow: "30m", Factor: 6}
+
+	for _, tc := range []struct {
+		name      string
+		objective *Objective
+		err       string
+	}{
+		{name: "burn rate rules", objective: &Objective{Target: 0.99, Window: "30d", BurnRates: []BurnRateRule{fast, slow}}},
+		{name: "target", objective: &Objective{Target: 1}, err: "target must be in the range (0, 1)"},
+		{name: "window", objective: &Objective{Target: 0.99, Window: "month"}, err: "window must be a positive duration"},
+		{
+			name:      "short window longer than long window",
+			objective: &Objective{Target: 0.99, BurnRates: []BurnRateRule{{Name: "fast", LongWindow: "5m", ShortWindow: "1h", Factor: 14.4}}},
+			err:       "short_window <= long_window",
+		},
+		{
+			name:      "factor",
+			objective: &Objective{Target: 0.99, BurnRates: []BurnRateRule{{Name: "fast", LongWindow: "1h", ShortWindow: "5m"}}},
+			err:       "name and a positive factor are required",
+		},
+		{
+			// same duration, written differently
+			name:      "duplicate long window",
+			objective: &Objective{Target: 0.99, BurnRates: []BurnRateRule{fast, {Name: "fast2", LongWindow: "60m", ShortWindow: "10m", Factor: 10}}},
+			err:       "invalid burn rate rule 'fast2' of KPI '[latency] < 200': another rule has the same long_window",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := InputSLAToSLAModels(input(tc.objective))
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.err)
+			}
+		})
+	}
+}
+
+func TestInputSLAToSLAModelsCompoundThresholds(t *testing.T) {
+	input := func(query string) InputSLA {
+		return InputSLA{
+			ServiceId: ServiceId{Value: "service1"},
+			Kpis: []InputSLARoleKPI{{
+				Query:      query,
+				Scope:      "company_premises/building=.",
+				Thresholds: &Thresholds{Values: map[string]float64{"Red": 1}},
+			}},
+		}
+	}
+
+	_, err := InputSLAToSLAModels(input("[latency] < 2"))
+	assert.NoError(t, err)
+
+	_, err = InputSLAToSLAModels(input("[latency] < 2 && [errors] == 0"))
+	assert.EqualError(t, err, "invalid thresholds of KPI '[latency] < 2 && [errors] == 0': thresholds are not supported in queries with several comparisons")
+}
+
+func TestInputSLAToSLAModelsThresholds(t *testing.T) {
+	def, queryThreshold := 2.0, 5.0
+	for _, tc := range []struct {
+		name     string
+		kpi      InputSLARoleKPI
+		expected *Thresholds
+		err      string
+	}{
+		{
+			name: "first label bound, default threshold of the query",
+			kpi: InputSLARoleKPI{Query: "[latency] < 5", Scope: "network/zone=.;company_premises/building=.",
+				Thresholds: &Thresholds{Values: map[string]float64{"Red": 1}}},
+			expected: &Thresholds{Label: "network_zone", Values: map[string]float64{"Red": 1}, Default: &queryThreshold},
+		},
+		{
+			name: "label",
+			kpi: InputSLARoleKPI{Query: "[latency] < 5", Scope: "network/zone=.;company_premises/building=.",
+				Thresholds: &Thresholds{Label: "company_premises/building", Values: map[string]float64{"Red": 1}, Default: &def}},
+			expected: &Thresholds{Label: "company_premises_building", Values: map[string]float64{"Red": 1}, Default: &def},
+		},
+		{
+			name: "no values",
+			kpi:  InputSLARoleKPI{Query: "[latency] < 5", Scope: "company_premises/building=.", Thresholds: &Thresholds{}},
+			err:  "values are required",
+		},
+		{
+			name: "no scope",
+			kpi:  InputSLARoleKPI{Query: "[latency] < 5", Thresholds: &Thresholds{Values: map[string]float64{"Red": 1}}},
+			err:  "a scope is required",
+		},
+		{
+			name: "label not bound",
+			kpi: InputSLARoleKPI{Query: "[latency] < 5", Scope: "company_premises/building=.,occupancy>10",
+				Thresholds: &Thresholds{Label: "company_premises/occupancy", Values: map[string]float64{"Red": 1}}},
+			err: "label 'company_premises/occupancy' is not bound by the scope",
+		},
+		{
+			name: "no threshold",
+			kpi: InputSLARoleKPI{Query: "[latency] < [errors]", Scope: "company_premises/building=.",
+				Thresholds: &Thresholds{Values: map[string]float64{"Red": 1}}},
+			err: "has no threshold",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			slas, err := InputSLAToSLAModels(InputSLA{ServiceId: ServiceId{Value: "service1"}, Kpis: []InputSLARoleKPI{tc.kpi}})
+			if tc.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.err)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			if assert.Len(t, slas, 1) {
+				gt := slas[0].Details.Guarantees[0]
+				assert.Equal(t, 5.0, gt.Threshold)
+				assert.Equal(t, tc.expected, gt.Thresholds)
+			}
+		})
+	}
+}
+
+func TestOutputThresholdKey(t *testing.T) {
+	sla := SLA{
+		Id:   "sla1",
+		Name: "service1",
+		Details: Details{Guarantees: []Guarantee{
+			{Name: "gt1", OQuery: "[latency] < 2", Threshold: 1, ThresholdKey: "Red"},
+			{Name: "gt2", OQuery: "[errors] < 2", Threshold: 2},
+		}},
+	}
+	for _, name := range []string{"gt1", "gt2"} {
+		sla.Assessment.SetGuarantee(name, AssessmentGuarantee{
+			Level:      ASSESSMENT_LEVEL_BROKEN,
+			LastValues: LastValues{"metric": {Key: "metric", Value: 3.0}},
+		})
+	}
+
+	output, err := SLAModelToOutputSLA(sla)
+	assert.NoError(t, err)
+	if assert.Len(t, output.Kpis, 2) {
+		assert.Equal(t, 1.0, output.Kpis[0].Threshold)
+		assert.Equal(t, "Red", output.Kpis[0].ThresholdKey)
+		assert.Empty(t, output.Kpis[1].ThresholdKey)
+	}
+
+	violation, err := SLAModelToColmenaOutputSLA(sla)
+	assert.NoError(t, err)
+	if assert.Len(t, violation.Kpis, 2) {
+		assert.Equal(t, 1.0, violation.Kpis[0].Threshold)
+		assert.Equal(t, "Red", violation.Kpis[0].ThresholdKey)
+		assert.Empty(t, violation.Kpis[1].ThresholdKey)
+	}
+}
@@ -23,6 +23,8 @@ package memrepository
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"colmena/sla-management-svc/app/common/logs"
@@ -32,10 +34,21 @@ import (
 // path used in logs
 const pathLOG string = "SLA > Repository > Memory >  "
 
-// MemRepository is a repository in memory
+// DefaultViolationsSize is the default maximum number of violations kept per SLA
+const DefaultViolationsSize = 1000
+
+// MemRepository is a repository in memory.
+//
+// It is safe for concurrent use: the assessment thread, the context thread and the REST API
+// share the same instance. Entities are copied in and out, so callers never share maps with the store.
+//
+// The violations are bounded: only the last violationsSize violations of each SLA are kept.
 type MemRepository struct {
-	agreements map[string]model.SLA
-	violations map[string]model.Violation
+	mu             *sync.RWMutex
+	agreements     map[string]model.SLA
+	violations     map[string]model.Violation
+	violationIds   map[string][]string // ids of the violations of each SLA, from the oldest to the newest
+	violationsSize int
 }
 
 // NewMemRepository creates a MemRepository with an initial state set by the parameters
@@ -50,8 +63,22 @@ func NewMemRepository(agreements map[string]model.SLA, violations map[string]mod
 	}
 
 	r = MemRepository{
-		agreements: agreements,
-		violations: violations,
+		mu:             &sync.RWMutex{},
+		agreements:     agreements,
+		violations:     make(map[string]model.Violation),
+		violationIds:   make(map[string][]string),
+		violationsSize: DefaultViolationsSize,
+	}
+
+	initial := make(model.Violations, 0, len(violations))
+	for _, v := range violations {
+		initial = append(initial, v)
+	}
+	sort.SliceStable(initial, func(i, j int) bool {
+		return initial[i].Datetime.Before(initial[j].Datetime)
+	})
+	for _, v := range initial {
+		r.addViolation(v)
 	}
 	return r
 }
@@ -61,6 +88,15 @@ func New() (MemRepository, error) {
 	return NewMemRepository(nil, nil), nil
 }
 
+// NewBounded creates a new instance of MemRepository that keeps up to violationsSize violations per SLA
+func NewBounded(violationsSize int) (MemRepository, error) {
+	r := NewMemRepository(nil, nil)
+	if violationsSize > 0 {
+		r.violationsSize = violationsSize
+	}
+	return r, nil
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 
 /*
@@ -70,21 +106,27 @@ The list is empty when there are no QoSDefinitions;
 error != nil on error
 */
 func (r MemRepository) GetSLAs() (model.SLAs, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	result := make(model.SLAs, 0, len(r.agreements))
 
 	for _, value := range r.agreements {
-		result = append(result, value)
+		result = append(result, value.Clone())
 	}
 	return result, nil
 }
 
 // GetSLAsByName gets SLAs by Name.
 func (r MemRepository) GetSLAsByName(id string) (model.SLAs, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	result := make(model.SLAs, 0, len(r.agreements))
 
 	for _, value := range r.agreements {
 		if value.Name == id {
-			result = append(result, value)
+			result = append(result, value.Clone())
 		}
 	}
 	return result, nil
@@ -96,12 +138,15 @@ GetQoSDefinitionsByState returns the QoSDefinitions that match any of the items
 error != nil on error
 */
 func (r MemRepository) GetSLAsByState(states ...model.State) (model.SLAs, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	result := make(model.SLAs, 0)
 
 	for _, a := range r.agreements {
 		for _, state := range states {
 			if a.State == state {
-				result = append(result, a)
+				result = append(result, a.Clone())
 			}
 		}
 	}
@@ -115,12 +160,16 @@ error != nil on error;
 error is sql.ErrNoRows if the QoSDefinition is not found
 */
 func (r MemRepository) GetSLA(id string) (*model.SLA, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	var err error
 
 	item, ok := r.agreements[id]
 
 	if ok {
 		err = nil
+		item = item.Clone()
 	} else {
 		err = model.ErrNotFound
 	}
@@ -138,6 +187,9 @@ func (r MemRepository) CreateSLA(agreement *model.SLA) (*model.SLA, error) {
 	agreementstr := fmt.Sprintf("%#v", agreement)
 	logs.GetLogger().Debug(pathLOG + "[CreateAgreement] Agreement: " + agreementstr)
 
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	var err error
 
 	id := agreement.Id
@@ -153,25 +205,35 @@ func (r MemRepository) CreateSLA(agreement *model.SLA) (*model.SLA, error) {
 		*texp = time.Now().AddDate(1, 0, 0)
 
 		agreement.Expiration = texp
+		agreement.Version = 1
 
-		r.agreements[id] = *agreement
+		r.agreements[id] = agreement.Clone()
 	}
 	return agreement, err
 }
 
 /*
-UpdateQoSDefinition updates the information of an already saved instance of a QoSDefinition
+UpdateQoSDefinition updates the information of an already saved instance of a QoSDefinition.
+
+error is model.ErrConflict if the stored version is not the version of the agreement
+(i.e. the agreement was updated by someone else after being read)
 */
 func (r MemRepository) UpdateSLA(agreement *model.SLA) (*model.SLA, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	var err error
 
 	id := agreement.Id
-	_, ok := r.agreements[id]
+	current, ok := r.agreements[id]
 
 	if !ok {
 		err = model.ErrNotFound
+	} else if current.Version != agreement.Version {
+		err = model.ErrConflict
 	} else {
-		r.agreements[id] = *agreement
+		agreement.Version++
+		r.agreements[id] = agreement.Clone()
 	}
 	return agreement, err
 }
@@ -183,6 +245,9 @@ error != nil on error;
 error is sql.ErrNoRows if the Agreement does not exist.
 */
 func (r MemRepository) DeleteSLA(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	var err error
 
 	_, ok := r.agreements[id]
@@ -205,6 +270,9 @@ func (r MemRepository) CreateViolation(v *model.Violation) (*model.Violation, er
 	vstr := fmt.Sprintf("%#v", v)
 	logs.GetLogger().Debug(pathLOG + "[CreateViolation] Agreement: " + vstr)
 
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	var err error
 
 	id := v.Id
@@ -212,11 +280,27 @@ func (r MemRepository) CreateViolation(v *model.Violation) (*model.Violation, er
 	if _, ok := r.violations[id]; ok {
 		err = model.ErrAlreadyExist
 	} else {
-		r.violations[id] = *v
+		r.addViolation(*v)
 	}
 	return v, err
 }
 
+// addViolation stores a violation, discarding the oldest violation of its SLA if there are too many. Must be called
+// with the lock held
+func (r MemRepository) addViolation(v model.Violation) {
+	r.violations[v.Id] = v.Clone()
+
+	ids := append(r.violationIds[v.AgreementId], v.Id)
+	if len(ids) > r.violationsSize {
+		for _, old := range ids[:len(ids)-r.violationsSize] {
+			delete(r.violations, old)
+		}
+		// the discarded ids are released when append reallocates the underlying array
+		ids = ids[len(ids)-r.violationsSize:]
+	}
+	r.violationIds[v.AgreementId] = ids
+}
+
 /*
 GetViolation returns the Violation identified by id.
 
@@ -224,12 +308,16 @@ error != nil on error;
 error is sql.ErrNoRows if the Violation is not found
 */
 func (r MemRepository) GetViolation(id string) (*model.Violation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	var err error
 
 	item, ok := r.violations[id]
 
 	if ok {
 		err = nil
+		item = item.Clone()
 	} else {
 		err = model.ErrNotFound
 	}
@@ -243,11 +331,14 @@ The list is empty when there are no violations;
 error != nil on error
 */
 func (r MemRepository) GetViolations(id string) (model.Violations, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	result := make(model.Violations, 0, len(r.violations))
 
 	for _, value := range r.violations {
 		if value.AgreementId == id {
-			result = append(result, value)
+			result = append(result, value.Clone())
 		}
 	}
 	return result, nil
@@ -260,11 +351,34 @@ The list is empty when there are no violations;
 error != nil on error
 */
 func (r MemRepository) GetAppViolations(id string) (model.Violations, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	result := make(model.Violations, 0, len(r.violations))
 
 	for _, value := range r.violations {
 		if value.AppId == id {
-			result = append(result, value)
+			result = append(result, value.Clone())
+		}
+	}
+	return result, nil
+}
+
+/*
+GetServiceViolations returns the Violations of the SLAs of a service.
+
+The list is empty when there are no violations;
+error != nil on error
+*/
+func (r MemRepository) GetServiceViolations(name string) (model.Violations, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make(model.Violations, 0, len(r.violations))
+
+	for _, value := range r.violations {
+		if value.ServiceId == name {
+			result = append(result, value.Clone())
 		}
 	}
 	return result, nil
@@ -277,10 +391,13 @@ The list is empty when there are no violations;
 error != nil on error
 */
 func (r MemRepository) GetAllViolations() (model.Violations, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	result := make(model.Violations, 0, len(r.violations))
 
 	for _, value := range r.violations {
-		result = append(result, value)
+		result = append(result, value.Clone())
 	}
 	return result, nil
 }
@@ -289,6 +406,8 @@ func (r MemRepository) GetAllViolations() (model.Violations, error) {
 UpdateQoSDefinitionState transits the state of the QoSDefinition
 */
 func (r MemRepository) UpdateSLAState(id string, newState model.State) (*model.SLA, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
 	var ok bool
 	var err error
@@ -301,8 +420,10 @@ func (r MemRepository) UpdateSLAState(id string, newState model.State) (*model.S
 		err = model.ErrNotFound
 	} else {
 		current.State = newState
+		current.Version++
 		r.agreements[id] = current
-		result = &current
+		clone := current.Clone()
+		result = &clone
 	}
 	return result, err
 }
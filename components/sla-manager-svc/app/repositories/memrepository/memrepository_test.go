@@ -0,0 +1,133 @@
+package memrepository
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"colmena/sla-management-svc/app/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateSLAVersion(t *testing.T) {
+	repo, _ := New()
+
+	_, err := repo.CreateSLA(&model.SLA{Id: "sla1", Name: "service1", State: model.STARTED})
+	assert.NoError(t, err)
+
+	// two copies read with the same version
+	first, err := repo.GetSLA("sla1")
+	assert.NoError(t, err)
+	second, err := repo.GetSLA("sla1")
+	assert.NoError(t, err)
+	version := first.Version
+
+	// the first update succeeds and increments the version
+	first.State = model.PAUSED
+	_, err = repo.UpdateSLA(first)
+	assert.NoError(t, err)
+	assert.Equal(t, version+1, first.Version)
+
+	// the second update is based on a stale version
+	second.State = model.STOPPED
+	_, err = repo.UpdateSLA(second)
+	assert.ErrorIs(t, err, model.ErrConflict)
+	assert.Equal(t, version, second.Version)
+
+	stored, err := repo.GetSLA("sla1")
+	assert.NoError(t, err)
+	assert.Equal(t, model.PAUSED, stored.State)
+	assert.Equal(t, version+1, stored.Version)
+
+	// an update with the current version succeeds
+	stored.State = model.STARTED
+	_, err = repo.UpdateSLA(stored)
+	assert.NoError(t, err)
+	assert.Equal(t, version+2, stored.Version)
+}
+
+func TestUpdateSLANotFound(t *testing.T) {
+	repo, _ := New()
+
+	_, err := repo.UpdateSLA(&model.SLA{Id: "unknown"})
+	assert.ErrorIs(t, err, model.ErrNotFound)
+}
+
+func TestViolationsBounded(t *testing.T) {
+	repo, _ := NewBounded(3)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		for _, sla := range []string{"sla1", "sla2"} {
+			_, err := repo.CreateViolation(&model.Violation{
+				Id:          sla + "-" + strconv.Itoa(i),
+				AgreementId: sla,
+				Datetime:    start.Add(time.Duration(i) * time.Second),
+			})
+			assert.NoError(t, err)
+		}
+	}
+
+	// only the last 3 violations of each SLA are kept
+	for _, sla := range []string{"sla1", "sla2"} {
+		vs, err := repo.GetViolations(sla)
+		assert.NoError(t, err)
+		ids := make([]string, 0, len(vs))
+		for _, v := range vs {
+			ids = append(ids, v.Id)
+		}
+		assert.ElementsMatch(t, []string{sla + "-2", sla + "-3", sla + "-4"}, ids)
+	}
+
+	_, err := repo.GetViolation("sla1-0")
+	assert.ErrorIs(t, err, model.ErrNotFound)
+	all, _ := repo.GetAllViolations()
+	assert.Len(t, all, 6)
+}
+
+func TestUpdateSLAState(t *testing.T) {
+	repo, _ := New()
+
+	_, err := repo.CreateSLA(&model.SLA{Id: "sla1", Name: "service1", State: model.STARTED})
+	assert.NoError(t, err)
+	stale, _ := repo.GetSLA("sla1")
+
+	updated, err := repo.UpdateSLAState("sla1", model.PAUSED)
+	assert.NoError(t, err)
+	assert.Equal(t, model.PAUSED, updated.State)
+	assert.Equal(t, stale.Version+1, updated.Version)
+
+	// the state change is an update: the copies read before conflict
+	_, err = repo.UpdateSLA(stale)
+	assert.ErrorIs(t, err, model.ErrConflict)
+
+	_, err = repo.UpdateSLAState("unknown", model.PAUSED)
+	assert.ErrorIs(t, err, model.ErrNotFound)
+}
+
+func TestLabelsNotShared(t *testing.T) {
+	repo, _ := New()
+
+	labels := map[string]string{"instance": "i1"}
+	sla := &model.SLA{Id: "sla1", Name: "service1", State: model.STARTED}
+	sla.Assessment.SetGuarantee("gt1", model.AssessmentGuarantee{
+		LastValues: model.LastValues{"metric": {Key: "metric", Labels: labels}},
+	})
+	_, err := repo.CreateSLA(sla)
+	assert.NoError(t, err)
+	_, err = repo.CreateViolation(&model.Violation{Id: "v1", AgreementId: "sla1", Values: []model.MetricValue{{Key: "metric", Labels: labels}}})
+	assert.NoError(t, err)
+
+	// modifications of the caller maps and of the maps read do not affect the store
+	labels["instance"] = "i2"
+	stored, _ := repo.GetSLA("sla1")
+	stored.Assessment.GetGuarantee("gt1").LastValues["metric"].Labels["instance"] = "i3"
+	v, _ := repo.GetViolation("v1")
+	v.Values[0].Labels["instance"] = "i3"
+
+	stored, _ = repo.GetSLA("sla1")
+	assert.Equal(t, "i1", stored.Assessment.GetGuarantee("gt1").LastValues["metric"].Labels["instance"])
+	v, _ = repo.GetViolation("v1")
+	assert.Equal(t, "i1", v.Values[0].Labels["instance"])
+}
@@ -0,0 +1,460 @@
+/*
+Copyright © 2024 EVIDEN
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+This work has been implemented within the context of COLMENA project.
+*/
+
+/*
+Package sqliterepository is a persistent implementation of a model.IRepository backed by a SQLite database.
+
+SLAs (including their assessment state: counters, levels, last values...) and violations are stored as JSON
+documents, together with the columns needed to filter them. The schema is created on startup, so an agent
+restart resumes the assessment where it was left.
+
+Usage:
+
+	repo, err := sqliterepository.New(config)
+*/
+package sqliterepository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"colmena/sla-management-svc/app/common/logs"
+	"colmena/sla-management-svc/app/model"
+
+	"github.com/spf13/viper"
+	_ "modernc.org/sqlite" // pure Go driver (the service is built with CGO_ENABLED=0)
+)
+
+// path used in logs
+const pathLOG string = "SLA > Repository > SQLite >  "
+
+const (
+	// Name is the unique identifier of this repository
+	Name = "sqlite"
+
+	// DatabasePathPropertyName is the config property name of the SQLite database file
+	DatabasePathPropertyName = "SQLITE_DB_PATH"
+
+	// defaultDatabasePath is the value of the database file if DatabasePathPropertyName is not set
+	defaultDatabasePath = "sla_manager.db"
+
+	driverName = "sqlite"
+)
+
+// schema is executed on startup; all statements must be idempotent
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS slas (
+		id      TEXT PRIMARY KEY,
+		name    TEXT NOT NULL,
+		state   TEXT NOT NULL,
+		version INTEGER NOT NULL DEFAULT 0,
+		data    TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_slas_name ON slas (name)`,
+	`CREATE INDEX IF NOT EXISTS idx_slas_state ON slas (state)`,
+	`CREATE TABLE IF NOT EXISTS violations (
+		id           TEXT PRIMARY KEY,
+		agreement_id TEXT NOT NULL,
+		app_id       TEXT,
+		service_id   TEXT,
+		datetime     TIMESTAMP NOT NULL,
+		data         TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_violations_agreement ON violations (agreement_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_violations_app ON violations (app_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_violations_service ON violations (service_id)`,
+}
+
+// SQLiteRepository is a repository stored in a SQLite database
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+/*
+New creates a SQLiteRepository from a Viper configuration, opening (or creating) the database file and its schema
+*/
+func New(config *viper.Viper) (SQLiteRepository, error) {
+	if os.Getenv(DatabasePathPropertyName) != "" {
+		config.Set(DatabasePathPropertyName, os.Getenv(DatabasePathPropertyName))
+	} else {
+		config.SetDefault(DatabasePathPropertyName, defaultDatabasePath)
+	}
+	path := config.GetString(DatabasePathPropertyName)
+
+	logConfig(config)
+
+	return NewSQLiteRepository(path)
+}
+
+// NewSQLiteRepository creates a SQLiteRepository using the database file located in path
+func NewSQLiteRepository(path string) (SQLiteRepository, error) {
+	// busy_timeout avoids 'database is locked' errors when the assessment and the REST API threads write at the same time
+	db, err := sql.Open(driverName, "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return SQLiteRepository{}, err
+	}
+	db.SetMaxOpenConns(1)
+
+	r := SQLiteRepository{db: db}
+	if err := r.createSchema(); err != nil {
+		db.Close()
+		return SQLiteRepository{}, err
+	}
+	return r, nil
+}
+
+// logConfig
+func logConfig(config *viper.Viper) {
+	logs.GetLogger().Info(pathLOG + "SQLite configuration:\n" +
+		"\t-----------------------------------------------------------------\n" +
+		"\tDatabase file: " + config.GetString(DatabasePathPropertyName) + "\n" +
+		"\t-----------------------------------------------------------------")
+}
+
+// createSchema
+func (r SQLiteRepository) createSchema() error {
+	logs.GetLogger().Info(pathLOG + "[createSchema] Creating database schema (if not exists) ...")
+	for _, stmt := range schema {
+		if _, err := r.db.Exec(stmt); err != nil {
+			logs.GetLogger().Error(pathLOG+"[createSchema] Error creating schema: ", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the underlying database
+func (r SQLiteRepository) Close() error {
+	return r.db.Close()
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+/*
+GetSLAs returns the list of SLAs.
+
+The list is empty when there are no SLAs;
+error != nil on error
+*/
+func (r SQLiteRepository) GetSLAs() (model.SLAs, error) {
+	return r.querySLAs("SELECT data, version FROM slas")
+}
+
+// GetSLAsByName gets SLAs by Name.
+func (r SQLiteRepository) GetSLAsByName(id string) (model.SLAs, error) {
+	return r.querySLAs("SELECT data, version FROM slas WHERE name = ?", id)
+}
+
+/*
+GetSLAsByState returns the SLAs that match any of the items in states.
+
+error != nil on error
+*/
+func (r SQLiteRepository) GetSLAsByState(states ...model.State) (model.SLAs, error) {
+	if len(states) == 0 {
+		return make(model.SLAs, 0), nil
+	}
+
+	args := make([]interface{}, 0, len(states))
+	for _, state := range states {
+		args = append(args, string(state))
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(states)), ",")
+
+	return r.querySLAs("SELECT data, version FROM slas WHERE state IN ("+placeholders+")", args...)
+}
+
+/*
+GetSLA returns the SLA identified by id.
+
+error != nil on error;
+error is model.ErrNotFound if the SLA is not found
+*/
+func (r SQLiteRepository) GetSLA(id string) (*model.SLA, error) {
+	var data string
+
+	var version int64
+
+	err := r.db.QueryRow("SELECT data, version FROM slas WHERE id = ?", id).Scan(&data, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &model.SLA{}, model.ErrNotFound
+	} else if err != nil {
+		return &model.SLA{}, err
+	}
+
+	var sla model.SLA
+	if err := json.Unmarshal([]byte(data), &sla); err != nil {
+		return &model.SLA{}, err
+	}
+	sla.Version = version
+	return &sla, nil
+}
+
+/*
+CreateSLA stores a new SLA.
+
+error != nil on error;
+error is model.ErrAlreadyExist if the SLA already exists
+*/
+func (r SQLiteRepository) CreateSLA(agreement *model.SLA) (*model.SLA, error) {
+	logs.GetLogger().Info(pathLOG + "[CreateSLA] Adding NEW agreement to SQLite repository ...")
+
+	agreement.Creation = time.Now()
+
+	texp := new(time.Time)
+	*texp = time.Now().AddDate(1, 0, 0)
+	agreement.Expiration = texp
+	agreement.Version = 1
+
+	data, err := json.Marshal(agreement)
+	if err != nil {
+		return agreement, err
+	}
+
+	_, err = r.db.Exec("INSERT INTO slas (id, name, state, version, data) VALUES (?, ?, ?, ?, ?)",
+		agreement.Id, agreement.Name, string(agreement.State), agreement.Version, string(data))
+	if isConstraintError(err) {
+		return agreement, model.ErrAlreadyExist
+	}
+	return agreement, err
+}
+
+/*
+UpdateSLA updates the information of an already saved instance of a SLA.
+
+error is model.ErrConflict if the stored version is not the version of the agreement
+(i.e. the agreement was updated by someone else after being read)
+*/
+func (r SQLiteRepository) UpdateSLA(agreement *model.SLA) (*model.SLA, error) {
+	expected := agreement.Version
+	agreement.Version = expected + 1
+
+	data, err := json.Marshal(agreement)
+	if err != nil {
+		agreement.Version = expected
+		return agreement, err
+	}
+
+	res, err := r.db.Exec("UPDATE slas SET name = ?, state = ?, version = ?, data = ? WHERE id = ? AND version = ?",
+		agreement.Name, string(agreement.State), agreement.Version, string(data), agreement.Id, expected)
+	if err != nil {
+		agreement.Version = expected
+		return agreement, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		agreement.Version = expected
+		if _, err := r.GetSLA(agreement.Id); errors.Is(err, model.ErrNotFound) {
+			return agreement, model.ErrNotFound
+		}
+		return agreement, model.ErrConflict
+	}
+	return agreement, nil
+}
+
+/*
+DeleteSLA deletes from the repository the SLA whose id is provided.
+
+error != nil on error;
+error is model.ErrNotFound if the SLA does not exist.
+*/
+func (r SQLiteRepository) DeleteSLA(id string) error {
+	res, err := r.db.Exec("DELETE FROM slas WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return model.ErrNotFound
+	}
+	return nil
+}
+
+/*
+CreateViolation stores a new Violation.
+
+error != nil on error;
+error is model.ErrAlreadyExist if the Violation already exists
+*/
+func (r SQLiteRepository) CreateViolation(v *model.Violation) (*model.Violation, error) {
+	logs.GetLogger().Info(pathLOG + "[CreateViolation] Adding Violation to SQLite repository ...")
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v, err
+	}
+
+	_, err = r.db.Exec("INSERT INTO violations (id, agreement_id, app_id, service_id, datetime, data) VALUES (?, ?, ?, ?, ?, ?)",
+		v.Id, v.AgreementId, v.AppId, v.ServiceId, v.Datetime.UTC(), string(data))
+	if isConstraintError(err) {
+		return v, model.ErrAlreadyExist
+	}
+	return v, err
+}
+
+/*
+GetViolation returns the Violation identified by id.
+
+error != nil on error;
+error is model.ErrNotFound if the Violation is not found
+*/
+func (r SQLiteRepository) GetViolation(id string) (*model.Violation, error) {
+	var data string
+
+	err := r.db.QueryRow("SELECT data FROM violations WHERE id = ?", id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &model.Violation{}, model.ErrNotFound
+	} else if err != nil {
+		return &model.Violation{}, err
+	}
+
+	var v model.Violation
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		return &model.Violation{}, err
+	}
+	return &v, nil
+}
+
+/*
+GetViolations returns the Violations of an SLA.
+
+The list is empty when there are no violations;
+error != nil on error
+*/
+func (r SQLiteRepository) GetViolations(id string) (model.Violations, error) {
+	return r.queryViolations("SELECT data FROM violations WHERE agreement_id = ? ORDER BY datetime", id)
+}
+
+/*
+GetAppViolations returns the Violations of an application.
+
+The list is empty when there are no violations;
+error != nil on error
+*/
+func (r SQLiteRepository) GetAppViolations(id string) (model.Violations, error) {
+	return r.queryViolations("SELECT data FROM violations WHERE app_id = ? ORDER BY datetime", id)
+}
+
+/*
+GetServiceViolations returns the Violations of the SLAs of a service.
+
+The list is empty when there are no violations;
+error != nil on error
+*/
+func (r SQLiteRepository) GetServiceViolations(name string) (model.Violations, error) {
+	return r.queryViolations("SELECT data FROM violations WHERE service_id = ? ORDER BY datetime", name)
+}
+
+/*
+GetAllViolations returns the list of violations.
+
+The list is empty when there are no violations;
+error != nil on error
+*/
+func (r SQLiteRepository) GetAllViolations() (model.Violations, error) {
+	return r.queryViolations("SELECT data FROM violations ORDER BY datetime")
+}
+
+/*
+UpdateSLAState transits the state of the SLA. The state is set in a single statement, whatever the version of the SLA
+(as memrepository), so it does not conflict with the updates of the assessment.
+
+error is model.ErrNotFound if the SLA does not exist
+*/
+func (r SQLiteRepository) UpdateSLAState(id string, newState model.State) (*model.SLA, error) {
+	var data string
+	var version int64
+
+	err := r.db.QueryRow(`UPDATE slas SET state = ?, version = version + 1,
+		data = json_set(data, '$.state', ?, '$.version', version + 1)
+	WHERE id = ? RETURNING data, version`, string(newState), string(newState), id).Scan(&data, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, model.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var sla model.SLA
+	if err := json.Unmarshal([]byte(data), &sla); err != nil {
+		return nil, err
+	}
+	sla.Version = version
+	return &sla, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+// querySLAs runs a query whose columns are the JSON document of the SLA and its version
+func (r SQLiteRepository) querySLAs(query string, args ...interface{}) (model.SLAs, error) {
+	result := make(model.SLAs, 0)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		var version int64
+		if err := rows.Scan(&data, &version); err != nil {
+			return result, err
+		}
+
+		var sla model.SLA
+		if err := json.Unmarshal([]byte(data), &sla); err != nil {
+			return result, fmt.Errorf("error decoding SLA: %w", err)
+		}
+		sla.Version = version
+		result = append(result, sla)
+	}
+	return result, rows.Err()
+}
+
+// queryViolations runs a query whose only column is the JSON document of the violation
+func (r SQLiteRepository) queryViolations(query string, args ...interface{}) (model.Violations, error) {
+	result := make(model.Violations, 0)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return result, err
+		}
+
+		var v model.Violation
+		if err := json.Unmarshal([]byte(data), &v); err != nil {
+			return result, fmt.Errorf("error decoding violation: %w", err)
+		}
+		result = append(result, v)
+	}
+	return result, rows.Err()
+}
+
+// isConstraintError returns true if err is a primary key / unique constraint violation
+func isConstraintError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
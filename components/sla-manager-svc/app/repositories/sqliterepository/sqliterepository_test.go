@@ -0,0 +1,112 @@
+package sqliterepository
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"colmena/sla-management-svc/app/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestRepository creates a repository in a temporary database file
+func newTestRepository(t *testing.T) SQLiteRepository {
+	repo, err := NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.db.Close() })
+	return repo
+}
+
+func TestUpdateSLAVersion(t *testing.T) {
+	repo := newTestRepository(t)
+
+	_, err := repo.CreateSLA(&model.SLA{Id: "sla1", Name: "service1", State: model.STARTED})
+	assert.NoError(t, err)
+
+	// two copies read with the same version
+	first, err := repo.GetSLA("sla1")
+	assert.NoError(t, err)
+	second, err := repo.GetSLA("sla1")
+	assert.NoError(t, err)
+	version := first.Version
+
+	// the first update succeeds and increments the version
+	first.State = model.PAUSED
+	_, err = repo.UpdateSLA(first)
+	assert.NoError(t, err)
+	assert.Equal(t, version+1, first.Version)
+
+	// the second update is based on a stale version
+	second.State = model.STOPPED
+	_, err = repo.UpdateSLA(second)
+	assert.ErrorIs(t, err, model.ErrConflict)
+	assert.Equal(t, version, second.Version)
+
+	stored, err := repo.GetSLA("sla1")
+	assert.NoError(t, err)
+	assert.Equal(t, model.PAUSED, stored.State)
+	assert.Equal(t, version+1, stored.Version)
+
+	// an update with the current version succeeds
+	stored.State = model.STARTED
+	_, err = repo.UpdateSLA(stored)
+	assert.NoError(t, err)
+	assert.Equal(t, version+2, stored.Version)
+}
+
+func TestUpdateSLANotFound(t *testing.T) {
+	repo := newTestRepository(t)
+
+	_, err := repo.UpdateSLA(&model.SLA{Id: "unknown"})
+	assert.ErrorIs(t, err, model.ErrNotFound)
+}
+
+func TestUpdateSLAState(t *testing.T) {
+	repo := newTestRepository(t)
+
+	_, err := repo.CreateSLA(&model.SLA{Id: "sla1", Name: "service1", State: model.STARTED})
+	assert.NoError(t, err)
+	stale, _ := repo.GetSLA("sla1")
+
+	updated, err := repo.UpdateSLAState("sla1", model.PAUSED)
+	assert.NoError(t, err)
+	assert.Equal(t, model.PAUSED, updated.State)
+	assert.Equal(t, stale.Version+1, updated.Version)
+
+	// the column and the document are consistent
+	paused, err := repo.GetSLAsByState(model.PAUSED)
+	assert.NoError(t, err)
+	if assert.Len(t, paused, 1) {
+		assert.Equal(t, model.PAUSED, paused[0].State)
+		assert.Equal(t, updated.Version, paused[0].Version)
+	}
+
+	// the state change is an update: the copies read before conflict
+	_, err = repo.UpdateSLA(stale)
+	assert.ErrorIs(t, err, model.ErrConflict)
+
+	_, err = repo.UpdateSLAState("unknown", model.PAUSED)
+	assert.ErrorIs(t, err, model.ErrNotFound)
+}
+
+func TestServiceViolations(t *testing.T) {
+	repo := newTestRepository(t)
+
+	_, err := repo.CreateViolation(&model.Violation{Id: "v1", AgreementId: "sla1", AppId: "sla1", ServiceId: "service1", Datetime: time.Now()})
+	assert.NoError(t, err)
+	_, err = repo.CreateViolation(&model.Violation{Id: "v2", AgreementId: "sla2", AppId: "sla2", ServiceId: "service2", Datetime: time.Now()})
+	assert.NoError(t, err)
+
+	vs, err := repo.GetServiceViolations("service1")
+	assert.NoError(t, err)
+	assert.Len(t, vs, 1)
+	assert.Equal(t, "v1", vs[0].Id)
+
+	vs, err = repo.GetAppViolations("sla2")
+	assert.NoError(t, err)
+	assert.Len(t, vs, 1)
+	assert.Equal(t, "v2", vs[0].Id)
+}
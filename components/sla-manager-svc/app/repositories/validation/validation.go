@@ -140,6 +140,11 @@ func (r repository) GetAppViolations(id string) (model.Violations, error) {
 	return r.backend.GetAppViolations(id)
 }
 
+// GetServiceViolations returns the Violations of the SLAs of a service.
+func (r repository) GetServiceViolations(name string) (model.Violations, error) {
+	return r.backend.GetServiceViolations(name)
+}
+
 // GetAllViolations gets all agreements.
 func (r repository) GetAllViolations() (model.Violations, error) {
 	return r.backend.GetAllViolations()
@@ -19,10 +19,16 @@ package main
 
 import (
 	"colmena/sla-management-svc/app/assessment"
+	"colmena/sla-management-svc/app/assessment/history"
 	"colmena/sla-management-svc/app/assessment/monitor"
+	"colmena/sla-management-svc/app/assessment/monitor/federated"
 	"colmena/sla-management-svc/app/assessment/monitor/genericadapter"
+	"colmena/sla-management-svc/app/assessment/monitor/promclient"
 	"colmena/sla-management-svc/app/assessment/monitor/prometheus"
+	"colmena/sla-management-svc/app/assessment/monitor/pushadapter"
+	promqueries "colmena/sla-management-svc/app/assessment/monitor/queries/prometheus"
 	"colmena/sla-management-svc/app/assessment/monitor/testadapter"
+	"colmena/sla-management-svc/app/assessment/monitor/tsbuffer"
 	"colmena/sla-management-svc/app/assessment/notifier"
 	"colmena/sla-management-svc/app/assessment/notifier/lognotifier"
 	"colmena/sla-management-svc/app/assessment/notifier/rest"
@@ -30,8 +36,10 @@ import (
 	"colmena/sla-management-svc/app/common/logs"
 	"colmena/sla-management-svc/app/model"
 	"colmena/sla-management-svc/app/repositories/memrepository"
+	"colmena/sla-management-svc/app/repositories/sqliterepository"
 	restAPI "colmena/sla-management-svc/rest-api"
 
+	"context"
 	"os"
 	"strings"
 	"time"
@@ -46,11 +54,24 @@ const pathLOG string = "SLA > "
 Main function. Environment variables used by the SLA & QoS Manager:
   - AGENT_ID (e.g., "agente01")
   - PROMETHEUS_ADDRESS (e.g., "http://localhost:9090")
-  - MONITORING_ADAPTER (e.g., "prometheus")
+  - PROMETHEUS_TIMEOUT (e.g., "10s")
+  - PROMETHEUS_USERNAME, PROMETHEUS_PASSWORD, PROMETHEUS_BEARER_TOKEN, PROMETHEUS_BEARER_TOKEN_FILE
+  - PROMETHEUS_CA_FILE, PROMETHEUS_CERT_FILE, PROMETHEUS_KEY_FILE, PROMETHEUS_INSECURE_SKIP_VERIFY
+  - PROMETHEUS_HEADERS (e.g., "X-Scope-OrgID=tenant1")
+  - MONITORING_ADAPTER (e.g., "prometheus", "federated", "push")
+  - PROMETHEUS_SOURCES (e.g., "local,thanos"), with PROMETHEUS_SOURCE_<NAME>_ADDRESS, PROMETHEUS_SOURCE_<NAME>_HEADERS...
+  - PUSH_MAX_SERIES (e.g., "10000"), PUSH_MAX_SAMPLES (e.g., "1000"), PUSH_RETENTION (e.g., "1h")
   - NOTIFIER_ADAPTER (e.g., "rest_endpoint", "rpc")
   - NOTIFICATION_ENDPOINT (e.g., "http://localhost:10090")
   - CONTEXT_ZENOH_ENDPOINT (e.g., "http://192.168.137.47:8000/dockerContextDefinitions/**")
+  - CONTEXT_ZENOH_MODE (e.g., "poll", "subscribe")
   - COMPOSE_PROJECT_NAME (e.g., "sensor")
+  - repository_adapter (e.g., "memory", "sqlite")
+  - SQLITE_DB_PATH (e.g., "/data/sla_manager.db")
+  - HISTORY_SIZE (e.g., "1000")
+  - VIOLATIONS_SIZE (e.g., "1000")
+  - ASSESSMENT_WORKERS (e.g., "4")
+  - ASSESSMENT_TIMEOUT (e.g., "15s")
   - ASSESSMENT_X
   - ASSESSMENT_Y
   - ASSESSMENT_Z
@@ -78,7 +99,7 @@ func main() {
 
 	// REPOSITORY (DB)
 	logs.GetLogger().Info(pathLOG + "Setting Database Adapter ...")
-	repo := buildRepositoryAdapter()
+	repo := buildRepositoryAdapter(config)
 
 	// NOTIFIER
 	logs.GetLogger().Info(pathLOG + "Setting Notifier / Subscriber adapter ...")
@@ -86,11 +107,14 @@ func main() {
 
 	// MONITORING ADAPTER
 	logs.GetLogger().Info(pathLOG + "Setting Monitoring Adapter ...")
-	adapter := buildMonitoringAdapter(config)
+	adapter, buffer := buildMonitoringAdapter(config)
 
 	// VALIDATOR
 	validater := model.NewDefaultValidator(false, true)
 
+	// KPI HISTORY
+	kpiHistory := history.New(config.GetInt(cfg.HistorySizePropertyName))
+
 	// start application - thread
 	logs.GetLogger().Info(pathLOG + "Initializing QAA assessment process [THREAD] ...")
 	aCfg := assessment.Config{
@@ -98,16 +122,22 @@ func main() {
 		Adapter:   adapter,
 		Notifier:  notifier,
 		Transient: trasientTime,
+		History:   kpiHistory,
+		Scheduler: assessment.NewScheduler(checkPeriod),
+		Workers:   config.GetInt(cfg.AssessmentWorkersPropertyName),
+		Timeout:   asSeconds(config, cfg.AssessmentTimeoutPropertyName),
 	}
 
-	go createValidationThread(checkPeriod, aCfg) // assessment thread
+	go createValidationThread(aCfg) // assessment thread
 	time.Sleep(2 * time.Second)
 
-	go createContextCheckThread(checkPeriod, aCfg, config) // context check thread
+	contexts, changes := buildContextSource(config)
+	go createContextCheckThread(checkPeriod, aCfg, config, contexts, changes) // context check thread
 	time.Sleep(2 * time.Second)
 
 	// REST API server - thread
 	a, _ := restAPI.New(aCfg, repo, validater, adapter)
+	a.Buffer = buffer
 	logs.GetLogger().Info(pathLOG + "Initializing SLA REST API server [THREAD] ...")
 	a.InitializeRESTAPI() // rest api thread
 
@@ -117,13 +147,26 @@ func main() {
 ///////////////////////////////////////////////////////////////////////////////
 
 // buildRepositoryAdapter
-func buildRepositoryAdapter() model.IRepository {
-	logs.GetLogger().Warn(pathLOG + "[Repository Adapter] Using default Database Adapter [memory repository] ... ")
-	repo, errRepo := memrepository.New()
-	if errRepo != nil {
-		logs.GetLogger().Fatal(pathLOG+"[Repository Adapter] Error creating repository: ", errRepo.Error())
+func buildRepositoryAdapter(config *viper.Viper) model.IRepository {
+	rType := config.GetString(cfg.RepositoryAdapterPropertyName)
+
+	switch rType {
+	case sqliterepository.Name:
+		logs.GetLogger().Info(pathLOG + "[Repository Adapter] Using SQLite Database Adapter ...")
+		repo, errRepo := sqliterepository.New(config)
+		if errRepo != nil {
+			logs.GetLogger().Fatal(pathLOG+"[Repository Adapter] Error creating repository: ", errRepo.Error())
+		}
+		return repo
+
+	default:
+		logs.GetLogger().Warn(pathLOG + "[Repository Adapter] Using default Database Adapter [memory repository] ... ")
+		repo, errRepo := memrepository.NewBounded(config.GetInt(cfg.ViolationsSizePropertyName))
+		if errRepo != nil {
+			logs.GetLogger().Fatal(pathLOG+"[Repository Adapter] Error creating repository: ", errRepo.Error())
+		}
+		return repo
 	}
-	return repo
 }
 
 // buildNotifierAdapter
@@ -140,11 +183,32 @@ func buildNotifierAdapter(config *viper.Viper) notifier.ViolationNotifier {
 	}
 }
 
-// buildMonitoringAdapter
-func buildMonitoringAdapter(config *viper.Viper) monitor.MonitoringAdapter {
+// buildContextSource returns the source of the contexts of the agent and the channel that signals their changes (nil
+// when polling)
+func buildContextSource(config *viper.Viper) (assessment.ContextSource, <-chan struct{}) {
+	switch config.GetString(cfg.ContextZenohModePropertyName) {
+	case cfg.ContextZenohSubscribeMode:
+		logs.GetLogger().Info(pathLOG + "[Context Source] Subscribing to contexts from Zenoh ...")
+		subscriber := assessment.NewContextSubscriber(config)
+		go subscriber.Run(context.Background())
+		return subscriber, subscriber.Changes()
+
+	default:
+		logs.GetLogger().Info(pathLOG + "[Context Source] Polling contexts from Zenoh ...")
+		return assessment.NewPollingContextSource(config), nil
+	}
+}
+
+// buildMonitoringAdapter returns the monitoring adapter, and the buffer of the pushed samples (nil if the push adapter
+// is not used)
+func buildMonitoringAdapter(config *viper.Viper) (monitor.MonitoringAdapter, *tsbuffer.Buffer) {
 	aType := config.GetString(cfg.MonitoringAdapterPropertyName)
 	if os.Getenv(cfg.MonitoringAdapterPropertyName) == prometheus.Name {
 		aType = prometheus.Name
+	} else if os.Getenv(cfg.MonitoringAdapterPropertyName) == federated.Name {
+		aType = federated.Name
+	} else if os.Getenv(cfg.MonitoringAdapterPropertyName) == pushadapter.Name {
+		aType = pushadapter.Name
 	} else if os.Getenv(cfg.MonitoringAdapterPropertyName) == testadapter.Name {
 		aType = testadapter.Name
 	}
@@ -152,22 +216,59 @@ func buildMonitoringAdapter(config *viper.Viper) monitor.MonitoringAdapter {
 	switch aType {
 	case prometheus.Name:
 		logs.GetLogger().Info(pathLOG + "[Monitoring Adapter] Using Prometheus adapter ...")
-		promadapter := prometheus.New(config)
-		adapter := genericadapter.New(
-			"prometheus",
-			promadapter.Retrieve(),
-			genericadapter.Identity)
-		return adapter
+		return buildPrometheusAdapter(config, promclient.DefaultPrefix), nil
+	case federated.Name:
+		names := federated.ParseSources(os.Getenv(federated.SourcesPropertyName))
+		if len(names) == 0 {
+			names = federated.ParseSources(config.GetString(federated.SourcesPropertyName))
+		}
+		if len(names) == 0 {
+			logs.GetLogger().Fatal(pathLOG + "[Monitoring Adapter] No sources defined in " + federated.SourcesPropertyName)
+		}
+		logs.GetLogger().Info(pathLOG + "[Monitoring Adapter] Using federated adapter with sources [" + strings.Join(names, ", ") + "] ...")
+		sources := make([]federated.Source, 0, len(names))
+		for _, name := range names {
+			sources = append(sources, federated.Source{
+				Name:    name,
+				Adapter: buildPrometheusAdapter(config, federated.SourcePrefix(name)),
+			})
+		}
+		return federated.New(sources), nil
+	case pushadapter.Name:
+		logs.GetLogger().Info(pathLOG + "[Monitoring Adapter] Using Push adapter ...")
+		buffer := pushadapter.NewBuffer(config)
+		adapter := genericadapter.NewWithQuerier(
+			pushadapter.Name,
+			pushadapter.New(buffer).Retrieve(),
+			genericadapter.Identity,
+			pushadapter.NewQuerier(buffer))
+		return adapter, buffer
 	default:
 		logs.GetLogger().Info(pathLOG + "[Monitoring Adapter] Using Test adapter ...")
 		adapter := genericadapter.New(
 			"default",
 			testadapter.New(config).Retrieve(),
 			genericadapter.Identity)
-		return adapter
+		return adapter, nil
 	}
 }
 
+// buildPrometheusAdapter builds a Prometheus adapter whose client is configured with the properties with a prefix
+func buildPrometheusAdapter(config *viper.Viper, prefix string) monitor.MonitoringAdapter {
+	// the client is shared by the retriever and the queries of the REST API
+	client, err := promclient.New(promclient.ConfigFromViper(config, prefix))
+	if err != nil {
+		logs.GetLogger().Fatal(pathLOG+"[Monitoring Adapter] Error creating Prometheus client ("+prefix+"*): ", err.Error())
+	}
+	promadapter := prometheus.New(config, client)
+	adapter := genericadapter.NewRanged(
+		"prometheus",
+		promadapter.Retrieve(),
+		genericadapter.Identity,
+		promqueries.NewQuerier(client))
+	return adapter
+}
+
 // set config value
 func setConfigValue(config *viper.Viper, property_name string, default_value string) {
 	if os.Getenv(property_name) == "" {
@@ -224,6 +325,17 @@ func createMainConfig() *viper.Viper {
 	}
 
 	setConfigValue(config, cfg.ContextZenohContextsPropertyName, cfg.DefaultContextZenohContexts)
+	setConfigValue(config, cfg.ContextZenohModePropertyName, cfg.DefaultContextZenohMode)
+
+	// KPI history
+	setConfigValue(config, cfg.HistorySizePropertyName, cfg.DefaultHistorySize)
+
+	// Violations (memory repository)
+	setConfigValue(config, cfg.ViolationsSizePropertyName, cfg.DefaultViolationsSize)
+
+	// Assessment workers
+	setConfigValue(config, cfg.AssessmentWorkersPropertyName, cfg.DefaultAssessmentWorkers)
+	setConfigValue(config, cfg.AssessmentTimeoutPropertyName, cfg.DefaultAssessmentTimeout)
 
 	// ComposeProjectPropertyName
 	setConfigValue(config, cfg.ComposeProjectPropertyName, "default_agent")
@@ -276,10 +388,10 @@ func logMainConfig(config *viper.Viper) {
 		"\t-----------------------------------------------------------------")
 }
 
-// createValidationThread
-func createValidationThread(checkPeriod time.Duration, cfg assessment.Config) {
+// createValidationThread: the SLAs are evaluated according to their periods (see assessment.Scheduler)
+func createValidationThread(cfg assessment.Config) {
 	logs.GetLogger().Info(pathLOG + "Starting Validation Thread ...")
-	ticker := time.NewTicker(checkPeriod)
+	ticker := time.NewTicker(cfg.Scheduler.Tick())
 
 	for {
 		<-ticker.C
@@ -288,14 +400,19 @@ func createValidationThread(checkPeriod time.Duration, cfg assessment.Config) {
 	}
 }
 
-// createContextCheckThread
-func createContextCheckThread(checkPeriod time.Duration, cfg assessment.Config, vconfig *viper.Viper) {
+// createContextCheckThread: the SLAs with scope are checked every check period, and as soon as a context changes
+func createContextCheckThread(checkPeriod time.Duration, cfg assessment.Config, vconfig *viper.Viper,
+	contexts assessment.ContextSource, changes <-chan struct{}) {
 	logs.GetLogger().Info(pathLOG + "Starting Context Check Thread ...")
 	ticker := time.NewTicker(checkPeriod)
 
 	for {
-		<-ticker.C
+		select {
+		case <-ticker.C:
+		case <-changes:
+			logs.GetLogger().Debug(pathLOG + "Context changed")
+		}
 		cfg.Now = time.Now()
-		assessment.CheckPausedQoSDefinitions(cfg, vconfig)
+		assessment.CheckScopedQoSDefinitions(cfg, contexts, vconfig)
 	}
 }
@@ -19,19 +19,26 @@ package restapi
 
 import (
 	"colmena/sla-management-svc/app/assessment"
+	"colmena/sla-management-svc/app/assessment/history"
 	"colmena/sla-management-svc/app/assessment/monitor"
+	"colmena/sla-management-svc/app/assessment/monitor/pushadapter"
+	"colmena/sla-management-svc/app/assessment/monitor/tsbuffer"
+	"colmena/sla-management-svc/app/common"
 	"colmena/sla-management-svc/app/common/logs"
 	"colmena/sla-management-svc/app/model"
 	"context"
 	"fmt"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
 	"syscall"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 
 	"errors"
+	"io"
 	"net/http"
 	"time"
 )
@@ -39,11 +46,26 @@ import (
 // path used in logs
 const pathLOG string = "SLA > REST-API > "
 
+const (
+	// defaultViolationsLimit is the page size used when 'limit' is not set
+	defaultViolationsLimit = 100
+	// maxViolationsLimit is the maximum page size
+	maxViolationsLimit = 1000
+	// defaultBacktestStep is the step of a backtest when neither 'step' nor the period of the KPIs are set
+	defaultBacktestStep = time.Minute
+	// maxPushBodySize is the maximum size of the body of a push request
+	maxPushBodySize = 10 << 20
+)
+
 // App is a main application "object", to be built by main and testmain
 type App struct {
 	Router      *gin.Engine
 	Repository  model.IRepository
 	Monitor     monitor.MonitoringAdapter
+	History     *history.Store
+	Buffer      *tsbuffer.Buffer      // buffer of the pushed samples (nil if the push adapter is not used)
+	Scheduler   *assessment.Scheduler // scheduler of the assessment thread, told when SLAs are created or deleted (optional)
+	Timeout     time.Duration
 	Port        string
 	SslEnabled  bool
 	SslCertPath string
@@ -55,6 +77,9 @@ func New(config assessment.Config, repository model.IRepository, validator model
 	a := App{
 		Repository: repository,
 		Monitor:    monitor,
+		History:    config.History,
+		Scheduler:  config.Scheduler,
+		Timeout:    config.Timeout,
 		validator:  validator,
 	}
 
@@ -104,21 +129,36 @@ func (a *App) InitializeRESTAPI() {
 
 			// sla
 			public.POST("/sla", a.CreateSLA)
+			public.POST("/sla/dry-run", a.DryRunSLA)
+			public.POST("/sla/backtest", a.BacktestSLA)
 			public.GET("/sla/:id", a.GetSLA)
 			public.DELETE("/sla/:id", a.DeleteSLA)
 			// slas
 			public.GET("/slas", a.GetSLAs)
 			public.GET("/slas/:id", a.GetSLAsByServiceId)
 			public.DELETE("/slas/:id", responseNotImplementedFunc)
+			// violations
+			// api/v1/violations?from=<RFC3339>&to=<RFC3339>&offset=<N>&limit=<N>
+			public.GET("/violations", a.GetAllViolations)
+			public.GET("/sla/:id/violations", a.GetSLAViolations)
+			public.GET("/slas/:id/violations", a.GetServiceViolations)
 			// kpis
 			public.GET("/kpis", a.GetKPIs)
 			public.GET("/kpis/:id", a.GetKPIsByServiceId)
 			public.GET("/kpi/:id", a.GetKPI)
+			// api/v1/kpi/:id/history?guarantee=<NAME>&from=<RFC3339>&to=<RFC3339>&step=<DURATION>
+			public.GET("/kpi/:id/history", a.GetKPIHistory)
 
 			// query metrics
 			// api/v1/query?metric=<METRIC>&path=<PATH>
 			public.GET("/query", a.Query)
 
+			// push metrics (push monitoring adapter)
+			// api/v1/write: Prometheus remote-write (snappy-compressed protobuf)
+			// api/v1/push: JSON samples
+			public.POST("/write", a.RemoteWrite)
+			public.POST("/push", a.Push)
+
 			// TESTs endpoints
 			// force violation
 			public.POST("/sla/violation/:fid", responseNotImplementedFunc)
@@ -155,7 +195,7 @@ func (a *App) InitializeRESTAPI() {
 	// stop server:
 
 	// Wait for interrupt signal to gracefully shutdown the server with a timeout of 5 seconds.
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	// kill (no param) default send syscall.SIGTERM
 	// kill -2 is syscall.SIGINT
 	// kill -9 is syscall.SIGKILL but can't be catch, so don't need add it
@@ -196,9 +236,16 @@ func responseNotImplementedFunc(c *gin.Context) {
 responseError response function
 */
 func responseError(c *gin.Context, method string, message string) {
+	responseErrorCode(c, method, message, 500)
+}
+
+/*
+responseErrorCode response function with a status code
+*/
+func responseErrorCode(c *gin.Context, method string, message string, code int) {
 	logs.GetLogger().Error(pathLOG + "[" + method + "] " + message)
 
-	c.JSON(500, gin.H{
+	c.JSON(code, gin.H{
 		"Resp":    "error",
 		"Method":  method,
 		"Message": message})
@@ -293,6 +340,47 @@ func (a *App) Query(c *gin.Context) {
 	}
 }
 
+/*
+RemoteWrite stores the samples of a Prometheus remote-write request in the buffer of the push adapter: "api/v1/write"
+Example (prometheus.yml):
+
+	remote_write:
+	  - url: http://localhost:8080/api/v1/write
+*/
+func (a *App) RemoteWrite(c *gin.Context) {
+	a.ingest(c, "RemoteWrite", pushadapter.IngestRemoteWrite)
+}
+
+/*
+Push stores the samples of a JSON request in the buffer of the push adapter: "api/v1/push"
+Example:
+
+	curl -X POST http://localhost:8080/api/v1/push -d '[{"name": "cpu_usage", "labels": {"instance": "i1"}, "value": 0.5}]'
+*/
+func (a *App) Push(c *gin.Context) {
+	a.ingest(c, "Push", pushadapter.IngestJSON)
+}
+
+// ingest stores the samples of a push request in the buffer
+func (a *App) ingest(c *gin.Context, m string, ingest func(*tsbuffer.Buffer, []byte) (pushadapter.IngestResult, error)) {
+	if a.Buffer == nil {
+		responseErrorCode(c, m, "Push ingestion is not enabled: set MONITORING_ADAPTER="+pushadapter.Name, http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxPushBodySize))
+	if err != nil {
+		responseErrorCode(c, m, "Error reading input: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, err := ingest(a.Buffer, body)
+	if err != nil {
+		responseErrorCode(c, m, "Error decoding input: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	responseOk(c, m, "Samples stored ("+strconv.Itoa(res.Accepted)+" accepted, "+strconv.Itoa(res.Rejected)+" rejected)", http.StatusOK, res)
+}
+
 /*
 CreateSLAv2 creates multiple SLAs passed by REST params
 */
@@ -300,12 +388,20 @@ func (a *App) CreateSLA(c *gin.Context) {
 	slas, err := model.InputSLAModelToSLAModel(c)
 	if err != nil {
 		responseError(c, "CreateSLA", "Error decoding input: "+err.Error())
+		return
+	}
+	if err := a.checkSources(slas); err != nil {
+		responseErrorCode(c, "CreateSLA", "Error decoding input: "+err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	anyError := false
 	var resSlas []model.SLA
 	var resError []error
 
+	if a.Scheduler != nil {
+		defer a.Scheduler.Changed()
+	}
 	for _, sla := range slas {
 		m, e := a.Repository.CreateSLA(&sla)
 
@@ -327,6 +423,125 @@ func (a *App) CreateSLA(c *gin.Context) {
 
 }
 
+// checkSources checks that the sources pinned by the guarantees of the SLAs are sources of the monitoring adapter (see
+// monitor.SourceAdapter). The sources are not checked if the adapter has no named sources
+func (a *App) checkSources(slas []model.SLA) error {
+	sa, ok := a.Monitor.(monitor.SourceAdapter)
+	if !ok {
+		return nil
+	}
+	for _, sla := range slas {
+		for _, gt := range sla.Details.Guarantees {
+			if len(gt.Source) > 0 && !sa.HasSource(gt.Source) {
+				return fmt.Errorf("source '%s' of KPI '%s' not found in the monitoring sources", gt.Source, gt.OQuery)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+DryRunSLA evaluates once the SLAs of a service definition (same input as CreateSLA), with the values of the monitoring
+or with the synthetic values of the input ('values'), and returns the results of each KPI. Nothing is stored.
+*/
+func (a *App) DryRunSLA(c *gin.Context) {
+	var input model.InputDryRun
+	if err := c.ShouldBindJSON(&input); err != nil {
+		responseErrorCode(c, "DryRunSLA", "Error decoding input: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	slas, err := model.InputSLAToSLAModels(input.InputSLA)
+	if err != nil {
+		responseErrorCode(c, "DryRunSLA", "Error decoding input: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := c.Request.Context()
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+
+	cfg := assessment.Config{
+		Now:     time.Now(),
+		Adapter: a.Monitor,
+	}
+	res := model.OutputDryRun{
+		ServiceId: input.ServiceId.Value,
+		Kpis:      assessment.DryRun(ctx, cfg, slas, input.Values),
+	}
+	responseOk(c, "DryRunSLA", "SLA(s) evaluated", http.StatusOK, res)
+}
+
+/*
+BacktestSLA replays the assessment of the SLAs of a service definition (same input as CreateSLA) over a past time range
+('from', 'to' and 'step'), and returns the levels and the violations of each KPI in each step. Nothing is stored.
+*/
+func (a *App) BacktestSLA(c *gin.Context) {
+	var input model.InputBacktest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		responseErrorCode(c, "BacktestSLA", "Error decoding input: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	slas, err := model.InputSLAToSLAModels(input.InputSLA)
+	if err != nil {
+		responseErrorCode(c, "BacktestSLA", "Error decoding input: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now()
+	if input.To != nil {
+		to = *input.To
+	}
+	step, err := backtestStep(input.Step, slas)
+	if err != nil {
+		responseErrorCode(c, "BacktestSLA", "Error parsing 'step' (duration or number of seconds expected): "+err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	ctx := c.Request.Context()
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+
+	cfg := assessment.Config{
+		Adapter: a.Monitor,
+	}
+	kpis, err := assessment.Backtest(ctx, cfg, slas, input.From, to, step)
+	if errors.Is(err, assessment.ErrInvalidBacktest) {
+		responseErrorCode(c, "BacktestSLA", "Error decoding input: "+err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		responseError(c, "BacktestSLA", "Error running backtest: "+err.Error())
+		return
+	}
+	responseOk(c, "BacktestSLA", "SLA(s) evaluated", http.StatusOK, model.OutputBacktest{
+		ServiceId: input.ServiceId.Value,
+		From:      input.From,
+		To:        to,
+		Step:      step.String(),
+		Kpis:      kpis,
+	})
+}
+
+// backtestStep returns the step of a backtest: the value of the input or, if not set, the shortest period of the SLAs
+func backtestStep(value string, slas []model.SLA) (time.Duration, error) {
+	if value != "" {
+		return common.ParseDuration(value)
+	}
+	step := time.Duration(0)
+	for _, sla := range slas {
+		if p := sla.GetPeriod(defaultBacktestStep); step == 0 || p < step {
+			step = p
+		}
+	}
+	return step, nil
+}
+
 /*
 GetSLAs return all SLAs in db
 */
@@ -359,7 +574,14 @@ DeleteSLA deletes a SLA
 */
 func (a *App) DeleteSLA(c *gin.Context) {
 	delete(c, "DeleteSLA", func(id string) error {
-		return a.Repository.DeleteSLA(id)
+		err := a.Repository.DeleteSLA(id)
+		if err == nil && a.History != nil {
+			a.History.Delete(id)
+		}
+		if err == nil && a.Scheduler != nil {
+			a.Scheduler.Changed()
+		}
+		return err
 	})
 }
 
@@ -407,3 +629,180 @@ func (a *App) GetKPI(c *gin.Context) {
 		}
 	})
 }
+
+/*
+GetAllViolations returns the violations of all the SLAs:
+"api/v1/violations?from=<RFC3339>&to=<RFC3339>&offset=<N>&limit=<N>"
+*/
+func (a *App) GetAllViolations(c *gin.Context) {
+	getViolations(c, "GetAllViolations", func() (model.Violations, error) {
+		return a.Repository.GetAllViolations()
+	})
+}
+
+/*
+GetSLAViolations returns the violations of a SLA:
+"api/v1/sla/:id/violations?from=<RFC3339>&to=<RFC3339>&offset=<N>&limit=<N>"
+*/
+func (a *App) GetSLAViolations(c *gin.Context) {
+	id := c.Param("id")
+	getViolations(c, "GetSLAViolations", func() (model.Violations, error) {
+		return a.Repository.GetViolations(id)
+	})
+}
+
+/*
+GetServiceViolations returns the violations of all the SLAs of a service:
+"api/v1/slas/:id/violations?from=<RFC3339>&to=<RFC3339>&offset=<N>&limit=<N>"
+*/
+func (a *App) GetServiceViolations(c *gin.Context) {
+	id := c.Param("id")
+	getViolations(c, "GetServiceViolations", func() (model.Violations, error) {
+		return a.Repository.GetServiceViolations(id)
+	})
+}
+
+// getViolations gets the violations returned by f, filtered by the time range and paginated (most recent first)
+func getViolations(c *gin.Context, m string, f func() (model.Violations, error)) {
+	from, err := timeParam(c, "from")
+	if err != nil {
+		responseErrorCode(c, m, "Error parsing 'from' parameter (RFC3339 format expected): "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := timeParam(c, "to")
+	if err != nil {
+		responseErrorCode(c, m, "Error parsing 'to' parameter (RFC3339 format expected): "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		responseErrorCode(c, m, "Error parsing 'offset' parameter: a non negative integer is expected", http.StatusBadRequest)
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultViolationsLimit)))
+	if err != nil || limit <= 0 {
+		responseErrorCode(c, m, "Error parsing 'limit' parameter: a positive integer is expected", http.StatusBadRequest)
+		return
+	}
+	if limit > maxViolationsLimit {
+		limit = maxViolationsLimit
+	}
+
+	l, err := f()
+	if err != nil {
+		responseError(c, m, "Error getting violations: "+err.Error())
+		return
+	}
+
+	responseOk(c, m, "Violations found", http.StatusOK, filterViolations(l, from, to, offset, limit))
+}
+
+// filterViolations returns the page [offset, offset+limit) of the violations in the range [from, to]
+func filterViolations(l model.Violations, from *time.Time, to *time.Time, offset int, limit int) model.OutputViolations {
+	filtered := make(model.Violations, 0, len(l))
+	for _, v := range l {
+		if from != nil && v.Datetime.Before(*from) {
+			continue
+		}
+		if to != nil && v.Datetime.After(*to) {
+			continue
+		}
+		filtered = append(filtered, v)
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return filtered[i].Datetime.After(filtered[j].Datetime)
+	})
+
+	res := model.OutputViolations{
+		Total:      len(filtered),
+		Offset:     offset,
+		Limit:      limit,
+		Violations: []model.Violation{},
+	}
+	if offset < len(filtered) {
+		end := offset + limit
+		if end > len(filtered) {
+			end = len(filtered)
+		}
+		res.Violations = filtered[offset:end]
+	}
+	return res
+}
+
+// timeParam parses an optional RFC3339 query parameter
+func timeParam(c *gin.Context, name string) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+/*
+GetKPIHistory returns the assessment results (value, threshold, level) of the guarantees of a SLA over time:
+"api/v1/kpi/:id/history?guarantee=<NAME>&from=<RFC3339>&to=<RFC3339>&step=<DURATION>"
+Example:
+
+	curl http://localhost:8080/api/v1/kpi/ExampleApplication_01-f5tjRgFF9HZ5KbgznKamid/history?from=2025-05-21T17:00:00Z&step=5m
+*/
+func (a *App) GetKPIHistory(c *gin.Context) {
+	m := "GetKPIHistory"
+	id := c.Param("id")
+
+	from, err := timeParam(c, "from")
+	if err != nil {
+		responseErrorCode(c, m, "Error parsing 'from' parameter (RFC3339 format expected): "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := timeParam(c, "to")
+	if err != nil {
+		responseErrorCode(c, m, "Error parsing 'to' parameter (RFC3339 format expected): "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	step, err := durationParam(c, "step")
+	if err != nil {
+		responseErrorCode(c, m, "Error parsing 'step' parameter (duration or number of seconds expected): "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sla, err := a.Repository.GetSLA(id)
+	if errors.Is(err, model.ErrNotFound) {
+		responseErrorCode(c, m, "SLA "+id+" not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		responseError(c, m, "Error getting object: "+err.Error())
+		return
+	}
+
+	points := []model.KPIHistoryPoint{}
+	if a.History != nil {
+		var f, t time.Time
+		if from != nil {
+			f = *from
+		}
+		if to != nil {
+			t = *to
+		}
+		points = a.History.Get(id, c.Query("guarantee"), f, t, step)
+	}
+
+	responseOk(c, m, "Object found", http.StatusOK, model.OutputKPIHistory{
+		ServiceId: sla.Name,
+		SLAId:     sla.Id,
+		Points:    points,
+	})
+}
+
+// durationParam parses an optional duration query parameter (e.g. "30s", "5m" or a number of seconds)
+func durationParam(c *gin.Context, name string) (time.Duration, error) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, nil
+	}
+	return common.ParseDuration(value)
+}
@@ -0,0 +1,229 @@
+package restapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"colmena/sla-management-svc/app/assessment/history"
+	amodel "colmena/sla-management-svc/app/assessment/model"
+	"colmena/sla-management-svc/app/assessment/monitor/federated"
+	"colmena/sla-management-svc/app/assessment/monitor/genericadapter"
+	"colmena/sla-management-svc/app/assessment/monitor/simpleadapter"
+	"colmena/sla-management-svc/app/model"
+	"colmena/sla-management-svc/app/repositories/memrepository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestRouter returns a router with the violations endpoints of an App with a memory repository
+func newTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	repo, _ := memrepository.New()
+	a := App{Repository: repo}
+
+	r := gin.New()
+	r.GET("/api/v1/violations", a.GetAllViolations)
+	return r
+}
+
+func TestGetViolationsParams(t *testing.T) {
+	r := newTestRouter()
+
+	for _, tc := range []struct {
+		query string
+		code  int
+	}{
+		{"", http.StatusOK},
+		{"?from=2025-05-21T17:00:00Z&to=2025-05-22T17:00:00Z&offset=10&limit=5", http.StatusOK},
+		{"?from=yesterday", http.StatusBadRequest},
+		{"?to=2025-05-21", http.StatusBadRequest},
+		{"?offset=-1", http.StatusBadRequest},
+		{"?offset=a", http.StatusBadRequest},
+		{"?limit=0", http.StatusBadRequest},
+		{"?limit=ten", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/violations"+tc.query, nil))
+		assert.Equal(t, tc.code, w.Code, tc.query)
+	}
+}
+
+func TestCreateSLAPinnedSource(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sources := []federated.Source{{Name: "local", Adapter: genericadapter.New("dummy", genericadapter.DummyRetriever{Size: 1}.Retrieve(), genericadapter.Identity)}}
+
+	for _, tc := range []struct {
+		source string
+		code   int
+	}{
+		{"", http.StatusOK},
+		{"local", http.StatusOK},
+		{"LOCAL", http.StatusOK},
+		{"thanos", http.StatusBadRequest},
+	} {
+		repo, _ := memrepository.New()
+		a := App{Repository: repo, Monitor: federated.New(sources)}
+		r := gin.New()
+		r.POST("/api/v1/sla", a.CreateSLA)
+
+		body := `{"id": {"value": "service1"}, "kpis": [{"query": "[cpu] < 1", "source": "` + tc.source + `"}]}`
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/sla", strings.NewReader(body)))
+		assert.Equal(t, tc.code, w.Code, tc.source)
+
+		slas, _ := repo.GetSLAs()
+		assert.Equal(t, tc.code == http.StatusOK, len(slas) > 0, tc.source)
+	}
+}
+
+func TestGetKPIHistory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo, _ := memrepository.New()
+	_, err := repo.CreateSLA(&model.SLA{Id: "sla1", Name: "service1", State: model.STARTED})
+	assert.NoError(t, err)
+	a := App{Repository: repo, History: history.New(10)}
+	r := gin.New()
+	r.GET("/api/v1/kpi/:id/history", a.GetKPIHistory)
+
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/api/v1/kpi/sla1/history", http.StatusOK},
+		{"/api/v1/kpi/sla1/history?step=5m", http.StatusOK},
+		{"/api/v1/kpi/unknown/history", http.StatusNotFound},
+		{"/api/v1/kpi/sla1/history?from=yesterday", http.StatusBadRequest},
+		{"/api/v1/kpi/sla1/history?step=fast", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		assert.Equal(t, tc.code, w.Code, tc.path)
+	}
+}
+
+// dryRunResponse is the response of the dry-run endpoint
+type dryRunResponse struct {
+	Response model.OutputDryRun
+}
+
+func TestDryRunSLA(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Now()
+	live := simpleadapter.New(amodel.GuaranteeData{amodel.ExpressionData{
+		"go_memstats_frees_total": model.MetricValue{Key: "go_memstats_frees_total", Value: 60000, DateTime: now},
+	}})
+
+	for _, tc := range []struct {
+		name       string
+		body       string
+		code       int
+		constraint string
+		promql     []string
+		value      float64
+		violated   bool
+		level      string
+	}{
+		{
+			name:       "synthetic values",
+			body:       `{"id": {"value": "service1"}, "kpis": [{"query": "[rate(go_memstats_frees_total[1m])] < 500"}], "values": {"rate(go_memstats_frees_total[1m])": 480}}`,
+			code:       http.StatusOK,
+			constraint: "[rate(go_memstats_frees_total[1m])] < 500",
+			promql:     []string{"rate(go_memstats_frees_total[1m])"},
+			value:      480,
+			level:      model.ASSESSMENT_LEVEL_MET,
+		},
+		{
+			name:       "live values",
+			body:       `{"id": {"value": "service1"}, "kpis": [{"query": "[go_memstats_frees_total] < 50000"}]}`,
+			code:       http.StatusOK,
+			constraint: "[go_memstats_frees_total] < 50000",
+			promql:     []string{"go_memstats_frees_total"},
+			value:      60000,
+			violated:   true,
+			level:      model.ASSESSMENT_LEVEL_BROKEN,
+		},
+		{
+			name: "malformed body",
+			body: `{"id": {"value": "service1"}, "kpis": [`,
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "invalid KPI",
+			body: `{"id": {"value": "service1"}, "kpis": [{"query": "[go_memstats_frees_total] <"}]}`,
+			code: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			repo, _ := memrepository.New()
+			a := App{Repository: repo, Monitor: live}
+			r := gin.New()
+			r.POST("/api/v1/sla/dry-run", a.DryRunSLA)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/sla/dry-run", strings.NewReader(tc.body)))
+			assert.Equal(t, tc.code, w.Code)
+			if tc.code != http.StatusOK {
+				return
+			}
+
+			var res dryRunResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			assert.Equal(t, "service1", res.Response.ServiceId)
+			if assert.Len(t, res.Response.Kpis, 1) {
+				kpi := res.Response.Kpis[0]
+				assert.Equal(t, tc.constraint, kpi.Query)
+				assert.Equal(t, tc.constraint, kpi.Constraint)
+				assert.Equal(t, tc.promql, kpi.PromQL)
+				if assert.Len(t, kpi.Values, 1) {
+					assert.Equal(t, tc.value, kpi.Values[0].Value)
+				}
+				assert.Equal(t, tc.violated, kpi.Violated)
+				assert.Equal(t, tc.level, kpi.Level)
+				assert.Empty(t, kpi.Error)
+			}
+
+			// nothing is stored
+			slas, _ := repo.GetSLAs()
+			assert.Empty(t, slas)
+		})
+	}
+}
+
+func TestBacktestSLAInput(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	from := time.Date(2025, 5, 21, 17, 0, 0, 0, time.UTC)
+	live := simpleadapter.New(amodel.GuaranteeData{amodel.ExpressionData{
+		"cpu": model.MetricValue{Key: "cpu", Value: 0.5, DateTime: from},
+	}})
+	a := App{Monitor: live}
+	r := gin.New()
+	r.POST("/api/v1/sla/backtest", a.BacktestSLA)
+
+	body := func(query, to, step string) string {
+		return `{"id": {"value": "service1"}, "kpis": [{"query": "` + query + `"}], "from": "` + from.Format(time.RFC3339) +
+			`", "to": "` + to + `", "step": "` + step + `"}`
+	}
+	for _, tc := range []struct {
+		name string
+		body string
+		code int
+	}{
+		{"valid", body("[cpu] < 1", "2025-05-21T17:10:00Z", "1m"), http.StatusOK},
+		{"malformed body", `{"id": {"value": "service1"}, "kpis": [`, http.StatusBadRequest},
+		{"invalid KPI", body("[cpu] <", "2025-05-21T17:10:00Z", "1m"), http.StatusBadRequest},
+		{"invalid step", body("[cpu] < 1", "2025-05-21T17:10:00Z", "fast"), http.StatusBadRequest},
+		{"negative step", body("[cpu] < 1", "2025-05-21T17:10:00Z", "-1m"), http.StatusBadRequest},
+		{"zero step", body("[cpu] < 1", "2025-05-21T17:10:00Z", "0"), http.StatusBadRequest},
+		{"to before from", body("[cpu] < 1", "2025-05-21T16:00:00Z", "1m"), http.StatusBadRequest},
+		{"too many evaluations", body("[cpu] < 1", "2025-05-22T17:00:00Z", "1s"), http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/sla/backtest", strings.NewReader(tc.body)))
+		assert.Equal(t, tc.code, w.Code, tc.name)
+	}
+}